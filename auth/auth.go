@@ -1,43 +1,103 @@
 package auth
 
 import (
+	"connector-demo/auth/providers/confluence"
 	"connector-demo/config"
+	"connector-demo/middleware"
 	"connector-demo/utils"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/markbates/goth/gothic"
+	"github.com/markbates/goth"
 )
 
 // AuthHandler 处理OAuth2认证相关请求
 type AuthHandler struct {
 	tokenManager *utils.TokenManager
+	stateSecret  []byte     // 签名 OAuth state 的密钥
+	states       StateStore // 进行中的授权，保证 state 一次性有效
 }
 
-// NewAuthHandler 创建新的认证处理器
-func NewAuthHandler(tm *utils.TokenManager) *AuthHandler {
+// NewAuthHandler 创建新的认证处理器，进行中的授权保存在内存中
+func NewAuthHandler(tm *utils.TokenManager, stateSecret []byte) *AuthHandler {
+	return NewAuthHandlerWithStateStore(tm, stateSecret, NewMemoryStateStore())
+}
+
+// NewAuthHandlerWithStateStore 创建使用指定 state 存储的认证处理器
+func NewAuthHandlerWithStateStore(tm *utils.TokenManager, stateSecret []byte, states StateStore) *AuthHandler {
 	return &AuthHandler{
 		tokenManager: tm,
+		stateSecret:  stateSecret,
+		states:       states,
 	}
 }
 
 // Connect 处理连接请求，重定向到OAuth2授权页面
+// 需要已登录的应用用户，用户ID通过签名的 state 带到回调中；
+// provider 会话（含 PKCE code_verifier）保存在服务端，以 state 为键，回调时一次性取出。
+// 前端无法在页面跳转时携带认证头，可使用 ?response=json 获取授权地址后自行跳转。
+// 同时向浏览器写入只在回调路径上发送的绑定 cookie，回调必须由同一个浏览器完成
 func (ah *AuthHandler) Connect(c *gin.Context) {
-	provider := c.Param("provider")
-	if !IsSupportedProvider(provider) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("不支持的平台: %s", provider)})
+	providerName := c.Param("provider")
+	if !IsSupportedProvider(providerName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("不支持的平台: %s", providerName)})
+		return
+	}
+	provider, err := GetProvider(providerName)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	state, err := signState(ah.stateSecret, middleware.UserID(c), providerName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("生成state失败: %v", err)})
+		return
+	}
+
+	sess, err := provider.BeginAuth(state)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("创建授权会话失败: %v", err)})
+		return
+	}
+	authURL, err := sess.GetAuthURL()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("获取授权地址失败: %v", err)})
 		return
 	}
 
-	q := c.Request.URL.Query()
-	q.Add("provider", provider)
-	c.Request.URL.RawQuery = q.Encode()
+	binding, hash, err := newBinding()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("生成授权绑定失败: %v", err)})
+		return
+	}
+	err = ah.states.Put(state, &PendingAuth{
+		UserID:      middleware.UserID(c),
+		Provider:    providerName,
+		Session:     sess.Marshal(),
+		BindingHash: hash,
+		ExpiresAt:   time.Now().Add(stateTTL),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("保存授权会话失败: %v", err)})
+		return
+	}
+	setBindingCookie(c, providerName, binding, int(stateTTL.Seconds()))
 
-	gothic.BeginAuthHandler(c.Writer, c.Request)
+	if c.Query("response") == "json" {
+		c.JSON(http.StatusOK, gin.H{"auth_url": authURL})
+		return
+	}
+	c.Redirect(http.StatusTemporaryRedirect, authURL)
 }
 
 // Callback 处理OAuth2回调
+// state 必须签名有效、未过期、与 provider 匹配，并且在服务端存在且未被使用过；
+// 请求必须带有发起授权时写入的绑定 cookie
 func (ah *AuthHandler) Callback(c *gin.Context) {
 	provider := c.Param("provider")
 	if provider == "" {
@@ -45,33 +105,60 @@ func (ah *AuthHandler) Callback(c *gin.Context) {
 		return
 	}
 
-	// 设置provider
-	q := c.Request.URL.Query()
-	q.Add("provider", provider)
-	c.Request.URL.RawQuery = q.Encode()
-
-	// 完成OAuth2认证
-	user, err := gothic.CompleteUserAuth(c.Writer, c.Request)
+	// 从 state 中找回发起授权的应用用户
+	rawState := c.Query("state")
+	st, err := verifyState(ah.stateSecret, rawState, provider)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("OAuth2认证失败: %v", err)})
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("无效的授权请求: %v", err)})
+		return
+	}
+	pending, ok := ah.states.Take(rawState)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的授权请求: state已使用或已过期"})
+		return
+	}
+	if pending.Provider != provider || pending.UserID != st.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的授权请求: state与授权会话不匹配"})
+		return
+	}
+	// 授权链接被转发给其他浏览器时没有绑定 cookie，拒绝把对方的账号连接到发起者名下
+	cookie, _ := c.Cookie(bindingCookie)
+	setBindingCookie(c, provider, "", -1)
+	if !bindingMatches(cookie, pending.BindingHash) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "无效的授权请求: 授权未由当前浏览器发起"})
+		return
+	}
+	userID := st.UserID
+
+	// 用户在授权页拒绝等情况
+	if errCode := c.Query("error"); errCode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("授权失败: %s %s", errCode, c.Query("error_description"))})
 		return
 	}
 
-	// 获取用户ID（这里使用用户邮箱作为唯一标识）
-	userID := user.Email
-	if userID == "" {
-		userID = user.UserID
+	// 完成OAuth2认证：用授权码（和 PKCE code_verifier）换取token，再获取账号信息
+	user, err := completeAuth(provider, pending.Session, c.Request.URL.Query())
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("OAuth2认证失败: %v", err)})
+		return
 	}
 
-	// 保存token信息
+	// 保存连接：同一 provider 账号重新授权时覆盖原连接，其他账号新建连接
 	tokenInfo := &utils.TokenInfo{
 		AccessToken:  user.AccessToken,
 		RefreshToken: user.RefreshToken,
 		Expiry:       user.ExpiresAt,
 		Provider:     provider,
+		Account:      accountLabel(user),
+		AccountID:    user.UserID,
+		AccountEmail: user.Email,
+		Metadata:     connectionMetadata(provider, user),
+		Scopes:       GetProviderScopes(provider),
+		Sites:        connectionSites(provider, user),
+		Status:       utils.StatusActive,
 	}
 
-	if err := ah.tokenManager.SaveToken(userID, provider, tokenInfo); err != nil {
+	if _, err := ah.tokenManager.SaveConnection(userID, tokenInfo); err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("保存token失败: %v", err)})
 		return
 	}
@@ -87,40 +174,263 @@ func (ah *AuthHandler) Callback(c *gin.Context) {
 	c.Redirect(302, frontendURL)
 }
 
-// GetTokens 获取用户的所有token
+// setBindingCookie 写入（maxAge < 0 时删除）授权绑定 cookie。cookie 只发送到该平台的回调路径，
+// SameSite=Lax 允许从授权服务器跳转回来的顶层 GET 请求携带
+func setBindingCookie(c *gin.Context, provider, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     bindingCookie,
+		Value:    value,
+		Path:     "/auth/" + provider + "/callback",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// GetTokens 获取用户所有连接的脱敏信息
 func (ah *AuthHandler) GetTokens(c *gin.Context) {
-	userID := c.Query("user_id")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+	userID := middleware.UserID(c)
+	connections := ah.tokenManager.GetConnections(userID)
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "connections": connections})
+}
+
+// Refresh 手动刷新指定连接的token（未指定 connection_id 时为默认连接），只返回脱敏后的连接信息
+func (ah *AuthHandler) Refresh(c *gin.Context) {
+	ref := middleware.Connection(c)
+	provider := c.Param("provider")
+	if provider == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provider不能为空"})
+		return
+	}
+
+	conn, err := ah.tokenManager.ResolveConnection(ref, provider)
+	if err != nil {
+		c.JSON(connectionErrorStatus(err), gin.H{"error": fmt.Sprintf("获取连接失败: %v", err)})
+		return
+	}
+	newToken, err := ah.tokenManager.RefreshToken(ref.UserID, conn.ConnectionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "刷新token失败", "detail": err.Error()})
 		return
 	}
 
-	tokens := ah.tokenManager.GetAllTokens(userID)
-	c.JSON(http.StatusOK, gin.H{"user_id": userID, "tokens": tokens})
+	view := newToken.View()
+	c.JSON(http.StatusOK, gin.H{"connection": view})
 }
 
-// Disconnect 断开指定平台的连接
-func (ah *AuthHandler) Disconnect(c *gin.Context) {
-	userID := c.Query("user_id")
+// SetDefault 把 connection_id 指定的连接设为该平台的默认连接
+func (ah *AuthHandler) SetDefault(c *gin.Context) {
+	ref := middleware.Connection(c)
+	provider := c.Param("provider")
+	if ref.ConnectionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "connection_id不能为空"})
+		return
+	}
+	if _, err := ah.tokenManager.ResolveConnection(ref, provider); err != nil {
+		c.JSON(connectionErrorStatus(err), gin.H{"error": fmt.Sprintf("获取连接失败: %v", err)})
+		return
+	}
+
+	conn, err := ah.tokenManager.SetDefaultConnection(ref.UserID, ref.ConnectionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("设置默认连接失败: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"connection": conn.View()})
+}
+
+// GetRawToken 返回token明文，仅供内部服务调用，只挂载在受保护的内部路由下
+func (ah *AuthHandler) GetRawToken(c *gin.Context) {
 	provider := c.Param("provider")
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "缺少user_id参数"})
+	token, err := ah.tokenManager.GetConnectionToken(middleware.Connection(c), provider)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("获取token失败: %v", err)})
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"access_token":  token.AccessToken,
+		"token_type":    token.TokenType,
+		"expiry":        token.Expiry,
+		"provider":      provider,
+		"connection_id": token.ConnectionID,
+	})
+}
 
+// disconnectResult 断开单个连接的结果
+type disconnectResult struct {
+	ConnectionID string `json:"connection_id"`
+	Account      string `json:"account,omitempty"`
+	Revoked      bool   `json:"revoked"`
+	Deleted      bool   `json:"deleted"`
+	// RevokeSkipped 未在平台侧撤销的原因（授权仍被其他连接使用）
+	RevokeSkipped string   `json:"revoke_skipped,omitempty"`
+	Errors        []string `json:"errors,omitempty"`
+}
+
+// Disconnect 断开连接：先在 provider 侧撤销授权，再删除本地token
+// 指定 connection_id 时只断开该连接，否则断开该平台的所有连接；
+// 撤销失败时仍会删除本地token，并以 207 返回失败原因
+func (ah *AuthHandler) Disconnect(c *gin.Context) {
+	ref := middleware.Connection(c)
+	provider := c.Param("provider")
 	if !IsSupportedProvider(provider) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("不支持的平台: %s", provider)})
 		return
 	}
 
-	ah.tokenManager.DeleteToken(userID, provider)
-	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("已断开与 %s 的连接", provider)})
+	var conns []*utils.TokenInfo
+	if ref.ConnectionID != "" {
+		conn, err := ah.tokenManager.ResolveConnection(ref, provider)
+		if err != nil {
+			c.JSON(connectionErrorStatus(err), gin.H{"error": fmt.Sprintf("获取连接失败: %v", err)})
+			return
+		}
+		conns = append(conns, conn)
+	} else {
+		var err error
+		if conns, err = ah.tokenManager.ListConnections(ref.UserID, provider); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("读取连接失败: %v", err)})
+			return
+		}
+	}
+
+	// 汇总结果：所有连接都撤销/删除成功时 revoked/deleted 才为 true
+	revoked, deleted := len(conns) > 0, true
+	anyDone := len(conns) == 0
+	errs := []string{}
+	results := make([]disconnectResult, 0, len(conns))
+	for _, conn := range conns {
+		result := ah.disconnectOne(ref.UserID, provider, conn)
+		results = append(results, result)
+		revoked = revoked && result.Revoked
+		deleted = deleted && result.Deleted
+		anyDone = anyDone || result.Revoked || result.Deleted
+		errs = append(errs, result.Errors...)
+	}
+
+	status := http.StatusOK
+	switch {
+	case !anyDone:
+		status = http.StatusInternalServerError
+	case len(errs) > 0:
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"message":     fmt.Sprintf("已断开与 %s 的连接", provider),
+		"revoked":     revoked,
+		"deleted":     deleted,
+		"errors":      errs,
+		"connections": results,
+	})
+}
+
+// disconnectOne 撤销并删除单个连接
+func (ah *AuthHandler) disconnectOne(userID, provider string, conn *utils.TokenInfo) disconnectResult {
+	result := disconnectResult{ConnectionID: conn.ConnectionID, Account: conn.Account}
+	if sibling := ah.sharedGrantConnection(userID, provider, conn); sibling != nil {
+		result.RevokeSkipped = fmt.Sprintf("%s 连接 %s 仍在使用同一授权，未在平台侧撤销", sibling.Provider, sibling.ConnectionID)
+	} else if revoker, ok := GetRevoker(provider); ok {
+		if err := revoker.Revoke(conn.AccessToken, conn.RefreshToken); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("撤销授权失败(%s): %v", conn.ConnectionID, err))
+		} else {
+			result.Revoked = true
+		}
+	} else {
+		result.Errors = append(result.Errors, fmt.Sprintf("%s 不支持撤销授权", provider))
+	}
+
+	if err := ah.tokenManager.DeleteToken(userID, conn.ConnectionID); err != nil {
+		result.Errors = append(result.Errors, fmt.Sprintf("删除本地token失败(%s): %v", conn.ConnectionID, err))
+	} else {
+		result.Deleted = true
+	}
+	return result
+}
+
+// sharedGrantConnection 查找与 conn 共用平台授权的其他连接，没有时返回 nil
+// 账号ID未知时无法判断是否同一账号，按共用处理，宁可不撤销也不误伤其他连接
+func (ah *AuthHandler) sharedGrantConnection(userID, provider string, conn *utils.TokenInfo) *utils.TokenInfo {
+	for _, sibling := range sharedGrantProviders[provider] {
+		others, err := ah.tokenManager.ListConnections(userID, sibling)
+		if err != nil {
+			log.Printf("读取 %s 连接失败: %v", sibling, err)
+			continue
+		}
+		for _, other := range others {
+			if conn.AccountID == "" || other.AccountID == "" || other.AccountID == conn.AccountID {
+				return other
+			}
+		}
+	}
+	return nil
+}
+
+// connectionErrorStatus 连接不存在时返回 404，其余为 500
+func connectionErrorStatus(err error) int {
+	if errors.Is(err, utils.ErrTokenNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// completeAuth 恢复授权会话并用回调参数完成授权码交换
+func completeAuth(providerName, session string, params goth.Params) (goth.User, error) {
+	provider, err := GetProvider(providerName)
+	if err != nil {
+		return goth.User{}, err
+	}
+	sess, err := provider.UnmarshalSession(session)
+	if err != nil {
+		return goth.User{}, fmt.Errorf("恢复授权会话失败: %v", err)
+	}
+	if _, err := sess.Authorize(provider, params); err != nil {
+		return goth.User{}, err
+	}
+	return provider.FetchUser(sess)
+}
+
+// accountLabel 返回 provider 侧账号的可读标识
+func accountLabel(user goth.User) string {
+	for _, v := range []string{user.Email, user.NickName, user.Name, user.UserID} {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
-// addProviderToContext 将provider添加到请求上下文中
-func addProviderToContext(r *http.Request, provider string) *http.Request {
-	q := r.URL.Query()
-	q.Add("provider", provider)
-	r.URL.RawQuery = q.Encode()
-	return r
+// connectionMetadata 提取 provider 特有的连接信息
+func connectionMetadata(provider string, user goth.User) map[string]string {
+	metadata := make(map[string]string)
+	switch provider {
+	case ProviderConfluence:
+		for _, key := range []string{"cloud_id", "site_name", "site_url"} {
+			if v, ok := user.RawData[key].(string); ok && v != "" {
+				metadata[key] = v
+			}
+		}
+	}
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
+}
+
+// connectionSites 提取连接可访问的站点，目前只有 Confluence 会返回多个站点
+func connectionSites(provider string, user goth.User) []utils.ConnectionSite {
+	if provider != ProviderConfluence {
+		return nil
+	}
+	sites, _ := user.RawData["sites"].([]confluence.Site)
+	result := make([]utils.ConnectionSite, 0, len(sites))
+	for _, site := range sites {
+		result = append(result, utils.ConnectionSite{
+			ID:     site.ID,
+			Name:   site.Name,
+			URL:    site.URL,
+			Scopes: site.Scopes,
+		})
+	}
+	return result
 }
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connector-demo/config"
+	"connector-demo/middleware"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetTokensRedactsSecrets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tm := utils.NewTokenManager()
+	tm.SaveToken("u1", ProviderSlack, &utils.TokenInfo{AccessToken: "xoxp-secret", RefreshToken: "xoxe-secret", Account: "alice@example.com"})
+	handler := NewAuthHandler(tm, []byte("test-secret"))
+
+	r := gin.New()
+	r.Use(asUser("u1"))
+	r.GET("/tokens/list", handler.GetTokens)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tokens/list", nil))
+
+	body := w.Body.String()
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, body)
+	}
+	if strings.Contains(body, "xoxp-secret") || strings.Contains(body, "xoxe-secret") {
+		t.Fatalf("response leaks token secrets: %s", body)
+	}
+	if !strings.Contains(body, utils.TokenFingerprint("xoxp-secret")) || !strings.Contains(body, "alice@example.com") {
+		t.Fatalf("response is missing connection metadata: %s", body)
+	}
+	if strings.Contains(body, `"expiry"`) {
+		t.Fatalf("zero expiry should be omitted: %s", body)
+	}
+}
+
+// asUser 模拟认证中间件，把请求视为指定用户发起
+func asUser(userID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		middleware.SetPrincipal(c, &middleware.Principal{Kind: middleware.PrincipalUser, UserID: userID})
+	}
+}
+
+var configForTest = config.Config{
+	GoogleClientID:     "google-client",
+	GoogleClientSecret: "google-secret",
+	RedirectURL:        "http://localhost:6767",
+}
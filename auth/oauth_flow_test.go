@@ -0,0 +1,233 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"connector-demo/auth/providers/confluence"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/markbates/goth"
+)
+
+// fakeAuthServer 模拟 Atlassian 授权服务器：签发授权码，并在换取token时校验 PKCE
+type fakeAuthServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	codes map[string]string // 授权码 -> code_challenge
+	next  int
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	s := &fakeAuthServer{codes: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "missing PKCE challenge", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.next++
+		code := fmt.Sprintf("code-%d", s.next)
+		s.codes[code] = q.Get("code_challenge")
+		s.mu.Unlock()
+
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		s.mu.Lock()
+		challenge, ok := s.codes[r.PostForm.Get("code")]
+		delete(s.codes, r.PostForm.Get("code"))
+		s.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"atl-access","refresh_token":"atl-refresh","token_type":"Bearer","expires_in":3600}`))
+	})
+	mux.HandleFunc("/accessible-resources", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer atl-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"id":"cloud-1","name":"Acme","url":"https://acme.atlassian.net","scopes":["read:page:confluence"]},{"id":"cloud-2","name":"Globex","url":"https://globex.atlassian.net"}]`))
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer atl-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"account_id":"atl-account-1","email":"alice@acme.example","name":"Alice"}`))
+	})
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newOAuthFlowRouter 创建使用假授权服务器的 Confluence provider 和授权路由，发起授权的用户固定为 app-user-1
+func newOAuthFlowRouter(t *testing.T) (*gin.Engine, *utils.TokenManager, *fakeAuthServer) {
+	gin.SetMode(gin.TestMode)
+	as := newFakeAuthServer(t)
+	provider := confluence.NewCustomisedURL("client-id", "client-secret", "http://app.local/auth/confluence/callback",
+		as.URL+"/authorize", as.URL+"/oauth/token", as.URL+"/accessible-resources", "read:page:confluence")
+	provider.HTTPClient = as.Client()
+	provider.MeURL = as.URL + "/me"
+	goth.UseProviders(provider)
+
+	tm := utils.NewTokenManager()
+	handler := NewAuthHandler(tm, []byte("test-secret"))
+	r := gin.New()
+	r.GET("/auth/:provider", asUser("app-user-1"), handler.Connect)
+	r.GET("/auth/:provider/callback", handler.Callback)
+	return r, tm, as
+}
+
+// startAuthorization 发起授权并在授权服务器同意，返回回调地址和发起授权时写入的 cookie
+func startAuthorization(t *testing.T, r *gin.Engine, as *fakeAuthServer) (string, []*http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/confluence?response=json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("connect: expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		AuthURL string `json:"auth_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	client := as.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(body.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: expected 302, got %d", resp.StatusCode)
+	}
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	return callback.Path + "?" + callback.RawQuery, w.Result().Cookies()
+}
+
+func callbackRequest(path string, cookies []*http.Cookie) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestOAuthFlowWithPKCE(t *testing.T) {
+	r, tm, as := newOAuthFlowRouter(t)
+
+	// 1. 发起授权，用户在授权服务器同意授权，被重定向回回调地址
+	callbackPath, cookies := startAuthorization(t, r, as)
+	if len(cookies) != 1 {
+		t.Fatalf("expected binding cookie, got %+v", cookies)
+	}
+	if c := cookies[0]; c.Name != bindingCookie || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/auth/confluence/callback" {
+		t.Fatalf("unexpected binding cookie: %+v", c)
+	}
+
+	// 3. 回调完成授权码交换（携带 code_verifier）并保存连接
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, callbackRequest(callbackPath, cookies))
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback: expected 302, got %d: %s", w.Code, w.Body.String())
+	}
+	token, err := tm.GetValidToken("app-user-1", ProviderConfluence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "atl-access" || token.RefreshToken != "atl-refresh" || token.Metadata["cloud_id"] != "cloud-1" {
+		t.Fatalf("unexpected connection: %+v", token)
+	}
+	// 账号ID取自 /me，而不是站点的 cloud ID
+	if token.AccountID != "atl-account-1" || token.AccountEmail != "alice@acme.example" {
+		t.Fatalf("unexpected account: %+v", token)
+	}
+	if len(token.Sites) != 2 || token.Sites[1].ID != "cloud-2" || token.Sites[0].Scopes[0] != "read:page:confluence" {
+		t.Fatalf("unexpected sites: %+v", token.Sites)
+	}
+
+	// 4. 重放同一个回调被拒绝
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, callbackRequest(callbackPath, cookies))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("replayed callback: expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestCallbackRejectsOtherBrowser(t *testing.T) {
+	r, tm, as := newOAuthFlowRouter(t)
+
+	// app-user-1 发起授权后把授权链接发给别人，对方在自己的浏览器里同意授权，回调时没有 app-user-1 的 cookie
+	callbackPath, _ := startAuthorization(t, r, as)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, callbackRequest(callbackPath, nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// 另一个授权流程的 cookie 同样无效
+	callbackPath, _ = startAuthorization(t, r, as)
+	_, otherCookies := startAuthorization(t, r, as)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, callbackRequest(callbackPath, otherCookies))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 with another flow's cookie, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := tm.GetValidToken("app-user-1", ProviderConfluence); err == nil {
+		t.Fatal("connection must not be saved without the binding cookie")
+	}
+}
+
+func TestCallbackRejectsStateNotIssuedByServer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewAuthHandler(utils.NewTokenManager(), []byte("test-secret"))
+	r := gin.New()
+	r.GET("/auth/:provider/callback", handler.Callback)
+
+	// 签名有效但服务端没有对应的授权会话（例如另一实例签发或已被清理）
+	state, _ := signState([]byte("test-secret"), "app-user-1", ProviderConfluence)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/confluence/callback?code=x&state="+url.QueryEscape(state), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestMemoryStateStore_SingleUseAndExpiry(t *testing.T) {
+	store := NewMemoryStateStore()
+	store.Put("s1", &PendingAuth{UserID: "u1", ExpiresAt: time.Now().Add(time.Minute)})
+	store.Put("s2", &PendingAuth{UserID: "u1", ExpiresAt: time.Now().Add(-time.Second)})
+
+	if _, ok := store.Take("s1"); !ok {
+		t.Fatal("expected pending auth")
+	}
+	if _, ok := store.Take("s1"); ok {
+		t.Fatal("state must be single use")
+	}
+	if _, ok := store.Take("s2"); ok {
+		t.Fatal("expired state must be rejected")
+	}
+}
@@ -20,6 +20,52 @@ const (
 
 var SupportedProviders = []string{ProviderGmail, ProviderGoogleDrive, ProviderSlack, ProviderConfluence}
 
+// sharedGrantProviders 共用同一个 OAuth 客户端的平台。Google 的授权按客户端和账号记录，
+// 撤销其中任意一个token会让同一账号在其他平台的连接一起失效
+var sharedGrantProviders = map[string][]string{
+	ProviderGmail:       {ProviderGoogleDrive},
+	ProviderGoogleDrive: {ProviderGmail},
+}
+
+// providerScopes 各平台申请的授权范围，用于记录到连接信息中
+var providerScopes = map[string][]string{
+	ProviderGmail: {
+		"openid",
+		"email",
+		"profile",
+		"https://www.googleapis.com/auth/gmail.readonly",
+	},
+	ProviderGoogleDrive: {
+		"openid",
+		"email",
+		"profile",
+		"https://www.googleapis.com/auth/drive.readonly",
+	},
+	ProviderSlack: {
+		"channels:read",
+		"groups:read",
+		"im:read",
+		"mpim:read",
+		"channels:history",
+		"groups:history",
+		"im:history",
+		"mpim:history",
+		"users:read",
+	},
+	ProviderConfluence: {
+		"read:page:confluence",
+		"read:space:confluence",
+		"read:space.permission:confluence",
+		"read:content-details:confluence",
+		"read:content:confluence",
+		"read:space-details:confluence",
+		"read:attachment:confluence",
+		"read:content.metadata:confluence",
+		"read:me",
+		"offline_access",
+	},
+}
+
 // SetupProviders 配置OAuth2提供者
 func SetupProviders(cfg *config.Config) error {
 	providers := []goth.Provider{}
@@ -30,10 +76,7 @@ func SetupProviders(cfg *config.Config) error {
 			cfg.GoogleClientID,
 			cfg.GoogleClientSecret,
 			fmt.Sprintf("%s/auth/gmail/callback", cfg.RedirectURL),
-			"openid",
-			"email",
-			"profile",
-			"https://www.googleapis.com/auth/gmail.readonly",
+			providerScopes[ProviderGmail]...,
 		)
 		gmailProvider.SetName(ProviderGmail)
 		gmailProvider.SetAccessType("offline")
@@ -44,15 +87,15 @@ func SetupProviders(cfg *config.Config) error {
 			cfg.GoogleClientID,
 			cfg.GoogleClientSecret,
 			fmt.Sprintf("%s/auth/google-drive/callback", cfg.RedirectURL),
-			"openid",
-			"email",
-			"profile",
-			"https://www.googleapis.com/auth/drive.readonly",
+			providerScopes[ProviderGoogleDrive]...,
 		)
 		googleDriveProvider.SetName(ProviderGoogleDrive)
 		googleDriveProvider.SetAccessType("offline")
 		googleDriveProvider.SetPrompt("consent")
 		providers = append(providers, googleDriveProvider)
+
+		RegisterRevoker(ProviderGmail, NewGoogleRevoker())
+		RegisterRevoker(ProviderGoogleDrive, NewGoogleRevoker())
 	}
 
 	// 配置Slack提供者
@@ -61,17 +104,10 @@ func SetupProviders(cfg *config.Config) error {
 			cfg.SlackClientID,
 			cfg.SlackClientSecret,
 			fmt.Sprintf("%s/auth/slack/callback", cfg.RedirectURL),
-			"channels:read",
-			"groups:read",
-			"im:read",
-			"mpim:read",
-			"channels:history",
-			"groups:history",
-			"im:history",
-			"mpim:history",
-			"users:read",
+			providerScopes[ProviderSlack]...,
 		)
 		providers = append(providers, slackProvider)
+		RegisterRevoker(ProviderSlack, NewSlackRevoker())
 	}
 
 	if cfg.ConfluenceClientID != "" && cfg.ConfluenceClientSecret != "" {
@@ -79,6 +115,7 @@ func SetupProviders(cfg *config.Config) error {
 			cfg.ConfluenceClientID,
 			cfg.ConfluenceClientSecret,
 			fmt.Sprintf("%s/auth/confluence/callback", cfg.RedirectURL),
+			providerScopes[ProviderConfluence]...,
 		)
 		providers = append(providers, confluenceProvider)
 	}
@@ -105,6 +142,11 @@ func GetSupportedProviders() []string {
 	return SupportedProviders
 }
 
+// GetProviderScopes 获取指定平台申请的授权范围
+func GetProviderScopes(platform string) []string {
+	return providerScopes[platform]
+}
+
 // IsSupportedProvider 检查是否支持指定平台
 func IsSupportedProvider(platform string) bool {
 	supported := GetSupportedProviders()
@@ -5,8 +5,10 @@ package confluence
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 
 	"github.com/markbates/goth"
 	"golang.org/x/oauth2"
@@ -14,26 +16,37 @@ import (
 
 const (
 	endpointProfile string = "https://api.atlassian.com/oauth/token/accessible-resources"
+	endpointMe      string = "https://api.atlassian.com/me"
 	authURL         string = "https://auth.atlassian.com/authorize"
 	tokenURL        string = "https://auth.atlassian.com/oauth/token"
+	revokeURL       string = "https://auth.atlassian.com/oauth/revoke"
 )
 
 // New creates a new Google provider, and sets up important connection details.
 // You should always call `google.New` to get a new Provider. Never try to create
 // one manually.
 func New(clientKey, secret, callbackURL string, scopes ...string) *Provider {
+	return NewCustomisedURL(clientKey, secret, callbackURL, authURL, tokenURL, endpointProfile, scopes...)
+}
+
+// NewCustomisedURL is similar to New(...) but can be used to set custom URLs to connect to,
+// e.g. a fake authorization server in tests.
+func NewCustomisedURL(clientKey, secret, callbackURL, authURL, tokenURL, profileURL string, scopes ...string) *Provider {
 	p := &Provider{
 		ClientKey:    clientKey,
 		Secret:       secret,
 		CallbackURL:  callbackURL,
 		providerName: "confluence",
 		HTTPClient:   &http.Client{},
+		RevokeURL:    revokeURL,
+		ProfileURL:   profileURL,
+		MeURL:        endpointMe,
 		authCodeOption: []oauth2.AuthCodeOption{
 			oauth2.SetAuthURLParam("audience", "api.atlassian.com"),
 			oauth2.SetAuthURLParam("prompt", "consent"),
 		},
 	}
-	p.config = newConfig(p, scopes)
+	p.config = newConfig(p, authURL, tokenURL, scopes)
 	return p
 }
 
@@ -43,6 +56,9 @@ type Provider struct {
 	Secret         string
 	CallbackURL    string
 	HTTPClient     *http.Client
+	RevokeURL      string
+	ProfileURL     string
+	MeURL          string
 	config         *oauth2.Config
 	providerName   string
 	authCodeOption []oauth2.AuthCodeOption
@@ -66,16 +82,19 @@ func (p *Provider) Client() *http.Client {
 // Debug is a no-op for the google package.
 func (p *Provider) Debug(debug bool) {}
 
-// BeginAuth asks Google for an authentication endpoint.
+// BeginAuth asks Atlassian for an authentication endpoint. Every session gets
+// its own PKCE (S256) code verifier, which is sent back on the code exchange.
 func (p *Provider) BeginAuth(state string) (goth.Session, error) {
+	verifier := oauth2.GenerateVerifier()
+	opts := append([]oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}, p.authCodeOption...)
 	return &Session{
-		AuthURL: p.config.AuthCodeURL(state, p.authCodeOption...),
+		AuthURL:      p.config.AuthCodeURL(state, opts...),
+		CodeVerifier: verifier,
 	}, nil
 }
 
-type confluenceClouds []confluenceCloud
-
-type confluenceCloud struct {
+// Site is an Atlassian site (cloud) the user granted access to.
+type Site struct {
 	ID        string   `json:"id"`
 	Name      string   `json:"name"`
 	URL       string   `json:"url"`
@@ -98,43 +117,73 @@ func (p *Provider) FetchUser(session goth.Session) (goth.User, error) {
 		return user, fmt.Errorf("%s cannot get user information without accessToken", p.providerName)
 	}
 
-	// Get the userID, Slack needs userID in order to get user profile info
-	req, _ := http.NewRequest("GET", endpointProfile, nil)
-	req.Header.Add("Authorization", "Bearer "+sess.AccessToken)
+	// The accessible sites describe what the grant can reach; the account itself
+	// comes from /me, so that two accounts sharing a site stay distinct.
+	var us []Site
+	if err := p.getJSON(p.ProfileURL, sess.AccessToken, &us); err != nil {
+		return user, err
+	}
+	if len(us) == 0 {
+		return user, fmt.Errorf("no accessible confluence clouds found")
+	}
+	var me Me
+	if err := p.getJSON(p.MeURL, sess.AccessToken, &me); err != nil {
+		return user, err
+	}
+	if me.AccountID == "" {
+		return user, fmt.Errorf("%s returned no account_id", p.providerName)
+	}
+
+	u := us[0] // the first site is the default one
+	user.UserID = me.AccountID
+	user.Email = me.Email
+	user.Name = me.Name
+	user.NickName = me.Nickname
+	user.AvatarURL = me.Picture
+	user.RawData = map[string]interface{}{
+		"account_id": me.AccountID,
+		"cloud_id":   u.ID,
+		"site_name":  u.Name,
+		"site_url":   u.URL,
+		"sites":      us,
+	}
+
+	return user, nil
+}
+
+// Me is the Atlassian account behind the access token.
+type Me struct {
+	AccountID string `json:"account_id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	Nickname  string `json:"nickname"`
+	Picture   string `json:"picture"`
+}
+
+// getJSON fetches url with the access token and decodes the JSON response into v.
+func (p *Provider) getJSON(url, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
 	response, err := p.Client().Do(req)
 	if err != nil {
-		return user, err
+		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return user, fmt.Errorf("%s responded with a %d trying to fetch user information", p.providerName, response.StatusCode)
+		return fmt.Errorf("%s responded with a %d trying to fetch user information", p.providerName, response.StatusCode)
 	}
 	responseBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		return user, err
-	}
-
-	var us confluenceClouds
-	if err := json.Unmarshal(responseBytes, &us); err != nil {
-		return user, err
+		return err
 	}
-	if len(us) == 0 {
-		return user, fmt.Errorf("no accessible confluence clouds found")
-	}
-	u := us[0] // take the first cloud
-	user.Name = u.Name
-	user.FirstName = u.Name
-	user.LastName = u.Name
-	user.NickName = u.Name
-	//user.Email = u.Email
-	user.AvatarURL = u.AvatarURL
-	user.UserID = u.ID
-
-	return user, nil
+	return json.Unmarshal(responseBytes, v)
 }
 
-func newConfig(provider *Provider, scopes []string) *oauth2.Config {
+func newConfig(provider *Provider, authURL, tokenURL string, scopes []string) *oauth2.Config {
 	c := &oauth2.Config{
 		ClientID:     provider.ClientKey,
 		ClientSecret: provider.Secret,
@@ -168,3 +217,29 @@ func (p *Provider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
 	}
 	return newToken, err
 }
+
+// Revoke revokes the grant at Atlassian. Revoking the refresh token also
+// invalidates the access tokens issued from it.
+func (p *Provider) Revoke(accessToken, refreshToken string) error {
+	token, hint := refreshToken, "refresh_token"
+	if token == "" {
+		token, hint = accessToken, "access_token"
+	}
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {hint},
+		"client_id":       {p.ClientKey},
+		"client_secret":   {p.Secret},
+	}
+	response, err := p.Client().PostForm(p.RevokeURL, form)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("%s responded with a %d trying to revoke token: %s", p.providerName, response.StatusCode, body)
+	}
+	return nil
+}
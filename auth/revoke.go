@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/markbates/goth"
+)
+
+const (
+	googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+	slackRevokeURL  = "https://slack.com/api/auth.revoke"
+)
+
+// Revoker 可选能力：在 provider 侧撤销授权
+// 自定义 provider 直接实现该接口即可，goth 内置 provider 通过 RegisterRevoker 注册适配器
+type Revoker interface {
+	Revoke(accessToken, refreshToken string) error
+}
+
+var revokers = make(map[string]Revoker)
+
+// RegisterRevoker 为指定平台注册撤销授权的实现
+func RegisterRevoker(platform string, r Revoker) {
+	revokers[platform] = r
+}
+
+// GetRevoker 获取指定平台的撤销授权实现，不支持时返回 false
+func GetRevoker(platform string) (Revoker, bool) {
+	if r, ok := revokers[platform]; ok {
+		return r, true
+	}
+	provider, err := goth.GetProvider(platform)
+	if err != nil {
+		return nil, false
+	}
+	r, ok := provider.(Revoker)
+	return r, ok
+}
+
+// GoogleRevoker 调用 Google oauth2/revoke 撤销授权
+type GoogleRevoker struct {
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+// NewGoogleRevoker 创建 Google 撤销授权实现
+func NewGoogleRevoker() *GoogleRevoker {
+	return &GoogleRevoker{Endpoint: googleRevokeURL, HTTPClient: &http.Client{}}
+}
+
+// Revoke 撤销 refresh token 会同时使其签发的 access token 失效，因此优先撤销 refresh token
+func (r *GoogleRevoker) Revoke(accessToken, refreshToken string) error {
+	token := refreshToken
+	if token == "" {
+		token = accessToken
+	}
+	form := url.Values{"token": {token}}
+	resp, err := r.HTTPClient.Post(r.Endpoint, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	// token 已失效或已被撤销时视为成功
+	if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "invalid_token") {
+		return nil
+	}
+	return fmt.Errorf("google responded with a %d trying to revoke token: %s", resp.StatusCode, body)
+}
+
+// SlackRevoker 调用 Slack auth.revoke 撤销授权
+type SlackRevoker struct {
+	Endpoint   string
+	HTTPClient *http.Client
+}
+
+// NewSlackRevoker 创建 Slack 撤销授权实现
+func NewSlackRevoker() *SlackRevoker {
+	return &SlackRevoker{Endpoint: slackRevokeURL, HTTPClient: &http.Client{}}
+}
+
+func (r *SlackRevoker) Revoke(accessToken, refreshToken string) error {
+	req, err := http.NewRequest(http.MethodPost, r.Endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack responded with a %d trying to revoke token", resp.StatusCode)
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.OK {
+		return nil
+	}
+	// token 已失效或已被撤销时视为成功
+	switch result.Error {
+	case "invalid_auth", "token_revoked", "account_inactive":
+		return nil
+	}
+	return fmt.Errorf("slack auth.revoke failed: %s", result.Error)
+}
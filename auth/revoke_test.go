@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"connector-demo/auth/providers/confluence"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGoogleRevoker(t *testing.T) {
+	var gotToken string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		gotToken = r.PostForm.Get("token")
+		if gotToken == "already-revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_token"}`))
+			return
+		}
+		if gotToken == "server-error" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}))
+	defer srv.Close()
+
+	r := &GoogleRevoker{Endpoint: srv.URL, HTTPClient: srv.Client()}
+	if err := r.Revoke("access", "refresh"); err != nil || gotToken != "refresh" {
+		t.Fatalf("expected refresh token to be revoked, got token=%q err=%v", gotToken, err)
+	}
+	if err := r.Revoke("access", ""); err != nil || gotToken != "access" {
+		t.Fatalf("expected access token to be revoked, got token=%q err=%v", gotToken, err)
+	}
+	if err := r.Revoke("", "already-revoked"); err != nil {
+		t.Fatalf("already revoked token should not fail: %v", err)
+	}
+	if err := r.Revoke("", "server-error"); err == nil {
+		t.Fatal("expected error on 500")
+	}
+}
+
+func TestSlackRevoker(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Authorization") {
+		case "Bearer good":
+			w.Write([]byte(`{"ok":true,"revoked":true}`))
+		case "Bearer gone":
+			w.Write([]byte(`{"ok":false,"error":"token_revoked"}`))
+		default:
+			w.Write([]byte(`{"ok":false,"error":"ratelimited"}`))
+		}
+	}))
+	defer srv.Close()
+
+	r := &SlackRevoker{Endpoint: srv.URL, HTTPClient: srv.Client()}
+	if err := r.Revoke("good", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Revoke("gone", ""); err != nil {
+		t.Fatalf("revoked token should not fail: %v", err)
+	}
+	if err := r.Revoke("other", ""); err == nil {
+		t.Fatal("expected error for ratelimited")
+	}
+}
+
+func TestConfluenceProviderRevoke(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("token") != "refresh" || r.PostForm.Get("token_type_hint") != "refresh_token" || r.PostForm.Get("client_id") != "client" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	p := confluence.New("client", "secret", "http://localhost/auth/confluence/callback")
+	p.RevokeURL = srv.URL
+	var _ Revoker = p
+	if err := p.Revoke("access", "refresh"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Revoke("access", ""); err == nil {
+		t.Fatal("expected error when the endpoint rejects the request")
+	}
+}
+
+func TestDisconnectReportsPartialFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	RegisterRevoker(ProviderGmail, &GoogleRevoker{Endpoint: srv.URL, HTTPClient: srv.Client()})
+	defer delete(revokers, ProviderGmail)
+
+	tm := utils.NewTokenManager()
+	tm.SaveToken("u1", ProviderGmail, &utils.TokenInfo{AccessToken: "a", RefreshToken: "r"})
+	handler := NewAuthHandler(tm, []byte("test-secret"))
+
+	r := gin.New()
+	r.Use(asUser("u1"))
+	r.DELETE("/tokens/disconnect/:provider", handler.Disconnect)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/tokens/disconnect/gmail", nil))
+
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("expected 207, got %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Revoked bool     `json:"revoked"`
+		Deleted bool     `json:"deleted"`
+		Errors  []string `json:"errors"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Revoked || !body.Deleted || len(body.Errors) != 1 {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+	if _, ok := tm.GetToken("u1", ProviderGmail); ok {
+		t.Fatal("token should be deleted locally even if revocation failed")
+	}
+}
+
+func TestDisconnectSingleConnection(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true,"revoked":true}`))
+	}))
+	defer srv.Close()
+	RegisterRevoker(ProviderSlack, &SlackRevoker{Endpoint: srv.URL, HTTPClient: srv.Client()})
+	defer delete(revokers, ProviderSlack)
+
+	tm := utils.NewTokenManager()
+	first, _ := tm.SaveConnection("u1", &utils.TokenInfo{Provider: ProviderSlack, AccessToken: "a1", AccountID: "T1"})
+	second, _ := tm.SaveConnection("u1", &utils.TokenInfo{Provider: ProviderSlack, AccessToken: "a2", AccountID: "T2"})
+	handler := NewAuthHandler(tm, []byte("test-secret"))
+
+	r := gin.New()
+	r.Use(asUser("u1"))
+	r.DELETE("/tokens/disconnect/:provider", handler.Disconnect)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/tokens/disconnect/slack?connection_id="+second.ConnectionID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if _, err := tm.LookupToken("u1", second.ConnectionID); !errors.Is(err, utils.ErrTokenNotFound) {
+		t.Fatalf("selected connection should be deleted, got %v", err)
+	}
+	if token, ok := tm.GetToken("u1", ProviderSlack); !ok || token.ConnectionID != first.ConnectionID {
+		t.Fatalf("other connection should remain, got %+v", token)
+	}
+}
+
+func TestDisconnectKeepsSharedGoogleGrant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var revokeCalls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revokeCalls++
+	}))
+	defer srv.Close()
+	RegisterRevoker(ProviderGmail, &GoogleRevoker{Endpoint: srv.URL, HTTPClient: srv.Client()})
+	RegisterRevoker(ProviderGoogleDrive, &GoogleRevoker{Endpoint: srv.URL, HTTPClient: srv.Client()})
+	defer delete(revokers, ProviderGmail)
+	defer delete(revokers, ProviderGoogleDrive)
+
+	tm := utils.NewTokenManager()
+	tm.SaveConnection("u1", &utils.TokenInfo{Provider: ProviderGmail, AccessToken: "g", RefreshToken: "rg", AccountID: "sub-1"})
+	drive, _ := tm.SaveConnection("u1", &utils.TokenInfo{Provider: ProviderGoogleDrive, AccessToken: "d", RefreshToken: "rd", AccountID: "sub-1"})
+	handler := NewAuthHandler(tm, []byte("test-secret"))
+
+	r := gin.New()
+	r.Use(asUser("u1"))
+	r.DELETE("/tokens/disconnect/:provider", handler.Disconnect)
+
+	// Drive 连接仍在使用同一Google授权，断开 Gmail 时不能在 Google 侧撤销
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/tokens/disconnect/gmail", nil))
+	if w.Code != http.StatusOK || revokeCalls != 0 || !strings.Contains(w.Body.String(), drive.ConnectionID) {
+		t.Fatalf("expected revoke to be skipped, got %d calls=%d: %s", w.Code, revokeCalls, w.Body.String())
+	}
+	if _, err := tm.LookupToken("u1", drive.ConnectionID); err != nil {
+		t.Fatalf("drive connection should remain: %v", err)
+	}
+
+	// 最后一个Google连接断开时才撤销授权
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/tokens/disconnect/google-drive", nil))
+	if w.Code != http.StatusOK || revokeCalls != 1 {
+		t.Fatalf("expected revoke on last connection, got %d calls=%d: %s", w.Code, revokeCalls, w.Body.String())
+	}
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// stateTTL OAuth 授权流程的最长耗时
+const stateTTL = 10 * time.Minute
+
+// oauthState 随 OAuth state 参数往返的授权上下文，用于在回调时找回发起授权的应用用户
+type oauthState struct {
+	UserID    string `json:"uid"`
+	Provider  string `json:"p"`
+	Nonce     string `json:"n"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// signState 生成带 HMAC 签名的 state：base64(payload).base64(signature)
+func signState(secret []byte, userID, provider string) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(oauthState{
+		UserID:    userID,
+		Provider:  provider,
+		Nonce:     hex.EncodeToString(nonce),
+		ExpiresAt: time.Now().Add(stateTTL).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(stateMAC(secret, encoded)), nil
+}
+
+// verifyState 校验 state 签名、有效期和 provider，返回授权上下文
+func verifyState(secret []byte, raw, provider string) (*oauthState, error) {
+	encoded, sig, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, errors.New("state格式错误")
+	}
+	gotMAC, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(gotMAC, stateMAC(secret, encoded)) {
+		return nil, errors.New("state签名无效")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("state格式错误")
+	}
+
+	var st oauthState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return nil, errors.New("state格式错误")
+	}
+	if time.Now().Unix() > st.ExpiresAt {
+		return nil, errors.New("state已过期")
+	}
+	if st.Provider != provider {
+		return nil, errors.New("state与provider不匹配")
+	}
+	if st.UserID == "" {
+		return nil, errors.New("state缺少用户")
+	}
+	return &st, nil
+}
+
+// bindingCookie 把授权流程绑定到发起授权的浏览器的 cookie，只在回调路径上发送
+const bindingCookie = "oauth_binding"
+
+// newBinding 生成绑定 cookie 的随机值，返回值和存入授权会话的哈希
+func newBinding() (string, string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(nonce)
+	return value, bindingHash(value), nil
+}
+
+func bindingHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// bindingMatches 校验回调请求携带的绑定 cookie 与授权会话一致
+func bindingMatches(value, hash string) bool {
+	return value != "" && hash != "" && hmac.Equal([]byte(bindingHash(value)), []byte(hash))
+}
+
+func stateMAC(secret []byte, data string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// PendingAuth 服务端保存的进行中授权，回调时按 state 取出
+type PendingAuth struct {
+	UserID   string
+	Provider string
+	Session  string // provider 的 goth.Session 序列化结果，包含 PKCE code_verifier
+	// BindingHash 发起授权的浏览器持有的绑定 cookie 的 SHA-256，回调时校验，
+	// 防止把授权链接发给他人、把对方的平台账号连接到自己名下
+	BindingHash string
+	ExpiresAt   time.Time
+}
+
+// StateStore 保存进行中授权的服务端存储
+// Take 必须是一次性的：取出后即删除，保证同一个 state 不能被重放
+type StateStore interface {
+	Put(state string, pending *PendingAuth) error
+	Take(state string) (*PendingAuth, bool)
+}
+
+// MemoryStateStore 进程内的 state 存储，过期记录在写入时顺带清理
+// 多实例部署时需要替换为共享存储
+type MemoryStateStore struct {
+	mu      sync.Mutex
+	pending map[string]*PendingAuth
+}
+
+// NewMemoryStateStore 创建内存 state 存储
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{pending: make(map[string]*PendingAuth)}
+}
+
+func (s *MemoryStateStore) Put(state string, pending *PendingAuth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range s.pending {
+		if now.After(v.ExpiresAt) {
+			delete(s.pending, k)
+		}
+	}
+	s.pending[state] = pending
+	return nil
+}
+
+func (s *MemoryStateStore) Take(state string) (*PendingAuth, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, ok := s.pending[state]
+	if !ok {
+		return nil, false
+	}
+	delete(s.pending, state)
+	if time.Now().After(pending.ExpiresAt) {
+		return nil, false
+	}
+	return pending, true
+}
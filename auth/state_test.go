@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignAndVerifyState(t *testing.T) {
+	secret := []byte("test-secret")
+	state, err := signState(secret, "app-user-1", ProviderGmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := verifyState(secret, state, ProviderGmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.UserID != "app-user-1" {
+		t.Fatalf("unexpected user: %q", st.UserID)
+	}
+
+	if _, err := verifyState(secret, state, ProviderSlack); err == nil {
+		t.Fatal("state must be bound to its provider")
+	}
+	if _, err := verifyState([]byte("other-secret"), state, ProviderGmail); err == nil {
+		t.Fatal("state signed with another secret must be rejected")
+	}
+	payload, sig, _ := strings.Cut(state, ".")
+	if _, err := verifyState(secret, payload+"x."+sig, ProviderGmail); err == nil {
+		t.Fatal("tampered state must be rejected")
+	}
+}
+
+func TestConnectEmbedsAppUserInState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	SetupProviders(&configForTest)
+	handler := NewAuthHandler(utils.NewTokenManager(), []byte("test-secret"))
+
+	r := gin.New()
+	r.Use(asUser("app-user-1"))
+	r.GET("/auth/:provider", handler.Connect)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/gmail?response=json", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var body struct {
+		AuthURL string `json:"auth_url"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	authURL, err := url.Parse(body.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := verifyState([]byte("test-secret"), authURL.Query().Get("state"), ProviderGmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.UserID != "app-user-1" {
+		t.Fatalf("state carries wrong user: %q", st.UserID)
+	}
+}
+
+func TestCallbackRejectsInvalidState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewAuthHandler(utils.NewTokenManager(), []byte("test-secret"))
+
+	r := gin.New()
+	r.GET("/auth/:provider/callback", handler.Callback)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/auth/gmail/callback?state=forged&code=abc", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -17,6 +19,26 @@ type Config struct {
 	ConfluenceClientSecret string
 	RedirectURL            string
 	ServerPort             string
+	TokenStoreDriver       string        // memory 或 bolt
+	TokenStorePath         string        // bolt 存储文件路径
+	TokenEncryptionKey     string        // 当前token加密密钥（base64，32字节）
+	TokenEncryptionOldKeys string        // 轮换下来的旧密钥，逗号分隔，仅用于解密
+	TokenEncryptionKeyFile string        // 密钥文件，优先于上面两个配置
+	TokenRefreshSkew       time.Duration // token到期前多久开始刷新
+	TokenRefreshInterval   time.Duration // 后台刷新扫描间隔
+	TokenRefreshAhead      time.Duration // 后台刷新提前量
+	JWTSecret              string        // HS256 JWT 密钥
+	JWTJWKSFile            string        // RS256 JWT 公钥（JWKS 文件）
+	JWTIssuer              string        // 校验 JWT iss，为空时不校验
+	JWTAudience            string        // 校验 JWT aud，为空时不校验
+	APIKeys                string        // 服务间调用的API key，格式 name:key，逗号分隔
+	DebugMode              bool          // 调试模式，开启后挂载 /debug 路由
+	SessionSecret          string        // 签名 OAuth state 等会话数据的密钥
+	GmailAttachmentMaxSize int64         // 允许下载的Gmail附件大小上限（字节），<= 0 表示不限制
+	GmailPubSubTopic       string        // 接收Gmail推送的Pub/Sub topic，为空时不支持订阅推送
+	GmailPushAudience      string        // Pub/Sub推送订阅配置的audience，与服务账号都配置时才挂载推送接口
+	GmailPushAccount       string        // Pub/Sub推送使用的服务账号邮箱，必须配置
+	GmailWatchRenewAhead   time.Duration // Gmail推送订阅到期前多久续订
 }
 
 // LoadConfig 从环境变量加载配置，支持.env文件
@@ -35,6 +57,26 @@ func LoadConfig() *Config {
 		ConfluenceClientSecret: GetEnv("CONFLUENCE_CLIENT_SECRET", ""),
 		RedirectURL:            GetEnv("REDIRECT_URL", "http://localhost:6767"),
 		ServerPort:             GetEnv("PORT", "6767"),
+		TokenStoreDriver:       GetEnv("TOKEN_STORE", "memory"),
+		TokenStorePath:         GetEnv("TOKEN_STORE_PATH", "data/tokens.db"),
+		TokenEncryptionKey:     GetEnv("TOKEN_ENCRYPTION_KEY", ""),
+		TokenEncryptionOldKeys: GetEnv("TOKEN_ENCRYPTION_OLD_KEYS", ""),
+		TokenEncryptionKeyFile: GetEnv("TOKEN_ENCRYPTION_KEY_FILE", ""),
+		TokenRefreshSkew:       GetEnvDuration("TOKEN_REFRESH_SKEW", 2*time.Minute),
+		TokenRefreshInterval:   GetEnvDuration("TOKEN_REFRESH_INTERVAL", time.Minute),
+		TokenRefreshAhead:      GetEnvDuration("TOKEN_REFRESH_AHEAD", 10*time.Minute),
+		JWTSecret:              GetEnv("JWT_SECRET", ""),
+		JWTJWKSFile:            GetEnv("JWT_JWKS_FILE", ""),
+		JWTIssuer:              GetEnv("JWT_ISSUER", ""),
+		JWTAudience:            GetEnv("JWT_AUDIENCE", ""),
+		APIKeys:                GetEnv("API_KEYS", ""),
+		DebugMode:              GetEnv("DEBUG_MODE", "false") == "true",
+		SessionSecret:          GetEnv("SESSION_SECRET", ""),
+		GmailAttachmentMaxSize: GetEnvInt64("GMAIL_ATTACHMENT_MAX_SIZE", 25<<20),
+		GmailPubSubTopic:       GetEnv("GMAIL_PUBSUB_TOPIC", ""),
+		GmailPushAudience:      GetEnv("GMAIL_PUSH_AUDIENCE", ""),
+		GmailPushAccount:       GetEnv("GMAIL_PUSH_SERVICE_ACCOUNT", ""),
+		GmailWatchRenewAhead:   GetEnvDuration("GMAIL_WATCH_RENEW_AHEAD", 24*time.Hour),
 	}
 }
 
@@ -45,3 +87,31 @@ func GetEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// GetEnvDuration 获取时长类型的环境变量（如 30s、5m），不存在或格式错误时返回默认值
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("环境变量 %s 格式错误(%v)，使用默认值 %s", key, err, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// GetEnvInt64 获取整数类型的环境变量，不存在或格式错误时返回默认值
+func GetEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("环境变量 %s 格式错误(%v)，使用默认值 %d", key, err, defaultValue)
+		return defaultValue
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package confluence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"connector-demo/utils"
+
+	"github.com/ctreminiom/go-atlassian/v2/pkg/infra/models"
+)
+
+// ErrRangeNotSatisfiable 请求的 Range 超出附件大小
+var ErrRangeNotSatisfiable = errors.New("请求的Range无法满足")
+
+// attachmentIDPattern 附件ID，如 att123456
+var attachmentIDPattern = regexp.MustCompile(`^(att)?\d+$`)
+
+// AttachmentList 一页附件，NextCursor 为空表示没有更多
+type AttachmentList struct {
+	Attachments []*models.AttachmentScheme `json:"attachments"`
+	NextCursor  string                     `json:"next_cursor,omitempty"`
+}
+
+// AttachmentDownload 附件下载流，调用方负责关闭 Body
+type AttachmentDownload struct {
+	Attachment *models.AttachmentScheme
+	// StatusCode 200，带 Range 请求时为 206
+	StatusCode    int
+	ContentType   string
+	ContentLength int64
+	// Header 需要透传给客户端的响应头（Content-Range、Accept-Ranges、ETag、Last-Modified）
+	Header http.Header
+	Body   io.ReadCloser
+}
+
+// passthroughHeaders 下载时透传的上游响应头
+var passthroughHeaders = []string{"Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// ListAttachments 获取页面的一页附件
+func (cc *ConfluenceConnector) ListAttachments(ref utils.ConnectionRef, site, pageID, cursor string, limit int) (*AttachmentList, error) {
+	id, err := strconv.Atoi(pageID)
+	if err != nil {
+		return nil, fmt.Errorf("无效的页面ID: %s", pageID)
+	}
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	chunk, _, err := client.Attachment.Gets(context.Background(), id, "pages", nil, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("获取附件列表失败: %v", err)
+	}
+
+	list := &AttachmentList{Attachments: chunk.Results}
+	if list.Attachments == nil {
+		list.Attachments = []*models.AttachmentScheme{}
+	}
+	if chunk.Links != nil {
+		list.NextCursor = nextCursor(chunk.Links.Next)
+	}
+	return list, nil
+}
+
+// DownloadAttachment 用用户的 token 从 Confluence 下载附件并返回响应流，rangeHeader 原样转发给上游
+func (cc *ConfluenceConnector) DownloadAttachment(ref utils.ConnectionRef, site, attachmentID, rangeHeader string) (*AttachmentDownload, error) {
+	if !attachmentIDPattern.MatchString(attachmentID) {
+		return nil, fmt.Errorf("无效的附件ID: %s", attachmentID)
+	}
+	token, selected, err := cc.getSiteToken(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	client, err := cc.newClient(token, selected)
+	if err != nil {
+		return nil, err
+	}
+	attachment, _, err := client.Attachment.Get(context.Background(), attachmentID, 0, false)
+	if err != nil {
+		return nil, fmt.Errorf("获取附件信息失败: %v", err)
+	}
+	containerID := attachment.PageID
+	if containerID == "" {
+		containerID = attachment.BlogPostID
+	}
+	if containerID == "" {
+		return nil, fmt.Errorf("附件不属于页面或博客，无法下载: %s", attachmentID)
+	}
+
+	// 下载接口会重定向到 Atlassian 媒体服务，跨域重定向时 http.Client 不会转发 Authorization
+	downloadURL := fmt.Sprintf("%s%s/wiki/rest/api/content/%s/child/attachment/%s/download", cc.BaseURL, selected.ID, containerID, attachmentID)
+	req, err := http.NewRequest(http.MethodGet, downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := cc.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载附件失败: %v", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrRangeNotSatisfiable, rangeHeader)
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("下载附件失败: 状态码 %d: %s", resp.StatusCode, body)
+	}
+
+	contentType := attachment.MediaType
+	if contentType == "" {
+		contentType = resp.Header.Get("Content-Type")
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(http.Header)
+	for _, key := range passthroughHeaders {
+		if v := resp.Header.Get(key); v != "" {
+			header.Set(key, v)
+		}
+	}
+	return &AttachmentDownload{
+		Attachment:    attachment,
+		StatusCode:    resp.StatusCode,
+		ContentType:   contentType,
+		ContentLength: resp.ContentLength,
+		Header:        header,
+		Body:          resp.Body,
+	}, nil
+}
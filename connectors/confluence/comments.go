@@ -0,0 +1,322 @@
+package confluence
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"connector-demo/utils"
+
+	confulence "github.com/ctreminiom/go-atlassian/v2/confluence/v2"
+)
+
+// 评论类型
+const (
+	CommentFooter = "footer"
+	CommentInline = "inline"
+)
+
+// Comment 页面评论，Replies 为回复（按时间顺序）
+type Comment struct {
+	ID         string `json:"id"`
+	Kind       string `json:"kind"`
+	Status     string `json:"status,omitempty"`
+	AuthorID   string `json:"author_id,omitempty"`
+	AuthorName string `json:"author_name,omitempty"`
+	CreatedAt  string `json:"created_at,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	// Body 评论正文，格式由请求的 format 决定（storage、markdown、text）
+	Body string `json:"body"`
+	// ResolutionStatus 行内评论的处理状态：open、resolved、reopened、dangling
+	ResolutionStatus string `json:"resolution_status,omitempty"`
+	// Selection 行内评论选中的原文
+	Selection string     `json:"selection,omitempty"`
+	Replies   []*Comment `json:"replies"`
+}
+
+// PageComments 页面的页脚评论和行内评论
+type PageComments struct {
+	Footer []*Comment `json:"footer"`
+	Inline []*Comment `json:"inline"`
+}
+
+// commentScheme v1 content/{id}/child/comment 返回的评论。v2 接口只能逐条调用 children 获取回复，
+// v1 的 depth=all 一次返回全部层级，通过 ancestors 组装回复树
+type commentScheme struct {
+	ID      string `json:"id"`
+	Status  string `json:"status"`
+	Version *struct {
+		Number int    `json:"number"`
+		When   string `json:"when"`
+		By     *struct {
+			AccountID string `json:"accountId"`
+		} `json:"by"`
+	} `json:"version"`
+	Body *struct {
+		Storage *struct {
+			Value string `json:"value"`
+		} `json:"storage"`
+	} `json:"body"`
+	// Ancestors 上级评论，从根评论到直接上级
+	Ancestors []struct {
+		ID string `json:"id"`
+	} `json:"ancestors"`
+	Extensions *struct {
+		Location         string `json:"location"`
+		InlineProperties *struct {
+			OriginalSelection string `json:"originalSelection"`
+		} `json:"inlineProperties"`
+		Resolution *struct {
+			Status string `json:"status"`
+		} `json:"resolution"`
+	} `json:"extensions"`
+}
+
+// commentChunk v1 评论列表的一页
+type commentChunk struct {
+	Results []commentScheme `json:"results"`
+	Links   struct {
+		Next string `json:"next"`
+	} `json:"_links"`
+}
+
+// commentExpand 评论列表需要展开的字段
+const commentExpand = "body.storage,version,ancestors,extensions.inlineProperties,extensions.resolution"
+
+// listChunk v2 列表接口的通用响应
+type listChunk[T any] struct {
+	Results []T `json:"results"`
+	Links   struct {
+		Next string `json:"next"`
+	} `json:"_links"`
+}
+
+// getList 读取一页 v2 列表接口，返回结果和下一页游标
+func getList[T any](client *confulence.Client, endpoint string, query url.Values) ([]T, string, error) {
+	req, err := client.NewRequest(context.Background(), http.MethodGet, endpoint+"?"+query.Encode(), "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	chunk := new(listChunk[T])
+	if _, err := client.Call(req, chunk); err != nil {
+		return nil, "", err
+	}
+	return chunk.Results, nextCursor(chunk.Links.Next), nil
+}
+
+// getAll 沿游标读取 v2 列表接口的全部结果
+func getAll[T any](client *confulence.Client, endpoint string, query url.Values) ([]T, error) {
+	var all []T
+	cursor := ""
+	for {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("limit", strconv.Itoa(maxPageLimit))
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		results, next, err := getList[T](client, endpoint, q)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, results...)
+		if next == "" || next == cursor {
+			return all, nil
+		}
+		cursor = next
+	}
+}
+
+// GetComments 获取页面评论并组装成回复树，kind 为 footer、inline 或空（全部）；
+// format 为 markdown 或 text 时转换评论正文，否则返回 storage 原文
+func (cc *ConfluenceConnector) GetComments(ref utils.ConnectionRef, site, pageID, kind, format string) (*PageComments, error) {
+	if _, err := strconv.Atoi(pageID); err != nil {
+		return nil, fmt.Errorf("无效的页面ID: %s", pageID)
+	}
+	if kind != "" && kind != CommentFooter && kind != CommentInline {
+		return nil, fmt.Errorf("不支持的评论类型: %s", kind)
+	}
+	client, selected, err := cc.getSiteClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := []string{CommentFooter, CommentInline}
+	if kind != "" {
+		kinds = []string{kind}
+	}
+	schemes, err := listComments(client, pageID, kinds)
+	if err != nil {
+		return nil, fmt.Errorf("获取评论失败: %v", err)
+	}
+	comments := &PageComments{Footer: []*Comment{}, Inline: []*Comment{}}
+	for _, c := range buildCommentTree(schemes) {
+		if c.Kind == CommentInline {
+			comments.Inline = append(comments.Inline, c)
+		} else {
+			comments.Footer = append(comments.Footer, c)
+		}
+	}
+
+	// 解析作者名称，转换正文格式
+	var all []*Comment
+	var collect func([]*Comment)
+	collect = func(list []*Comment) {
+		for _, c := range list {
+			all = append(all, c)
+			collect(c.Replies)
+		}
+	}
+	collect(comments.Footer)
+	collect(comments.Inline)
+
+	var authorIDs []string
+	for _, c := range all {
+		authorIDs = append(authorIDs, c.AuthorID)
+	}
+	names := cc.resolveUserNames(client, authorIDs)
+	opts := ConvertOptions{SiteURL: selected.URL, PageID: pageID}
+	for _, c := range all {
+		c.AuthorName = names[c.AuthorID]
+		if format == FormatMarkdown || format == FormatText {
+			convert := StorageToText
+			if format == FormatMarkdown {
+				convert = StorageToMarkdown
+			}
+			if body, err := convert(c.Body, opts); err == nil {
+				c.Body = body
+			}
+		}
+	}
+	return comments, nil
+}
+
+// listComments 读取页面全部层级的评论，kinds 为需要的评论位置
+func listComments(client *confulence.Client, pageID string, kinds []string) ([]commentScheme, error) {
+	var all []commentScheme
+	start := 0
+	for {
+		query := url.Values{
+			"depth":    {"all"},
+			"location": kinds,
+			"expand":   {commentExpand},
+			"limit":    {strconv.Itoa(maxPageLimit)},
+			"start":    {strconv.Itoa(start)},
+		}
+		endpoint := fmt.Sprintf("wiki/rest/api/content/%s/child/comment?%s", pageID, query.Encode())
+		req, err := client.NewRequest(context.Background(), http.MethodGet, endpoint, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		chunk := new(commentChunk)
+		if _, err := client.Call(req, chunk); err != nil {
+			return nil, err
+		}
+		all = append(all, chunk.Results...)
+		if chunk.Links.Next == "" || len(chunk.Results) == 0 {
+			return all, nil
+		}
+		start += len(chunk.Results)
+	}
+}
+
+// buildCommentTree 按直接上级把评论挂到回复树上，回复按创建时间排序；上级不在结果中的回复作为根评论
+func buildCommentTree(schemes []commentScheme) []*Comment {
+	byID := make(map[string]*Comment, len(schemes))
+	for _, s := range schemes {
+		byID[s.ID] = toComment(s)
+	}
+
+	var roots []*Comment
+	for _, s := range schemes {
+		c := byID[s.ID]
+		if n := len(s.Ancestors); n > 0 {
+			if parent, ok := byID[s.Ancestors[n-1].ID]; ok {
+				parent.Replies = append(parent.Replies, c)
+				continue
+			}
+		}
+		roots = append(roots, c)
+	}
+	for _, c := range byID {
+		sort.SliceStable(c.Replies, func(i, j int) bool { return c.Replies[i].CreatedAt < c.Replies[j].CreatedAt })
+	}
+	return roots
+}
+
+func toComment(s commentScheme) *Comment {
+	c := &Comment{ID: s.ID, Kind: CommentFooter, Status: s.Status, Replies: []*Comment{}}
+	if s.Version != nil {
+		c.CreatedAt = s.Version.When
+		c.Version = s.Version.Number
+		if s.Version.By != nil {
+			c.AuthorID = s.Version.By.AccountID
+		}
+	}
+	if s.Body != nil && s.Body.Storage != nil {
+		c.Body = s.Body.Storage.Value
+	}
+	if ext := s.Extensions; ext != nil {
+		if ext.Location == CommentInline {
+			c.Kind = CommentInline
+		}
+		if ext.InlineProperties != nil {
+			c.Selection = ext.InlineProperties.OriginalSelection
+		}
+		if ext.Resolution != nil {
+			c.ResolutionStatus = ext.Resolution.Status
+		}
+	}
+	return c
+}
+
+// resolveUserNames 批量查询用户显示名称，查询失败时返回已解析的部分，调用方保留账号ID
+func (cc *ConfluenceConnector) resolveUserNames(client *confulence.Client, accountIDs []string) map[string]string {
+	names := make(map[string]string)
+	seen := make(map[string]bool)
+	var ids []string
+	for _, id := range accountIDs {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	const batchSize = 100
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		req, err := client.NewRequest(context.Background(), http.MethodPost, "wiki/api/v2/users-bulk", "", map[string][]string{"accountIds": ids[start:end]})
+		if err != nil {
+			log.Printf("查询Confluence用户失败: %v", err)
+			return names
+		}
+		var result struct {
+			Results []struct {
+				AccountID   string `json:"accountId"`
+				DisplayName string `json:"displayName"`
+				PublicName  string `json:"publicName"`
+			} `json:"results"`
+		}
+		if _, err := client.Call(req, &result); err != nil {
+			log.Printf("查询Confluence用户失败: %v", err)
+			return names
+		}
+		for _, u := range result.Results {
+			name := u.DisplayName
+			if name == "" {
+				name = u.PublicName
+			}
+			names[u.AccountID] = name
+		}
+	}
+	return names
+}
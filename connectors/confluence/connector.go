@@ -2,8 +2,12 @@ package confluence
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"connector-demo/auth"
 	"connector-demo/utils"
@@ -12,32 +16,432 @@ import (
 	"github.com/ctreminiom/go-atlassian/v2/pkg/infra/models"
 )
 
+// maxPageLimit Confluence v2 API 单页最大条数
+const maxPageLimit = 250
+
+// DefaultBaseURL Confluence Cloud 的 OAuth API 网关，站点地址为 BaseURL + cloudID
+const DefaultBaseURL = "https://api.atlassian.com/ex/confluence/"
+
+// SiteParam 选择站点的查询参数，可传 cloud ID、站点名或站点域名，不传时使用连接的默认站点
+const SiteParam = "site"
+
+// ErrSiteNotAccessible 连接无权访问指定的站点
+var ErrSiteNotAccessible = errors.New("连接无权访问该Confluence站点")
+
 // ConfluenceConnector 处理Confluence API调用
 type ConfluenceConnector struct {
 	tokenManager *utils.TokenManager
+	// BaseURL API网关地址，测试时可指向本地的假服务
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// ConfluenceUser 当前授权用户的信息
+type ConfluenceUser struct {
+	AccountID   string `json:"accountId"`
+	AccountType string `json:"accountType,omitempty"`
+	Email       string `json:"email,omitempty"`
+	PublicName  string `json:"publicName,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
 }
 
 func NewConfluenceConnector(tm *utils.TokenManager) *ConfluenceConnector {
-	return &ConfluenceConnector{tokenManager: tm}
+	return &ConfluenceConnector{
+		tokenManager: tm,
+		BaseURL:      DefaultBaseURL,
+		HTTPClient:   http.DefaultClient,
+	}
 }
 
-// 获取Confluence客户端
-func (sc *ConfluenceConnector) GetPages(cloudID string) (*models.PageChunkScheme, *models.ResponseScheme, error) {
-	token, exists := sc.tokenManager.GetToken(cloudID, auth.ProviderConfluence)
-	if !exists {
-		return nil, nil, fmt.Errorf("未找到用户的Confluence token")
+// Site 连接可访问的站点
+type Site struct {
+	utils.ConnectionSite
+	Default bool `json:"default"`
+}
+
+// getClient 创建指向所选站点的 Confluence 客户端，site 为空时使用连接的默认站点
+func (cc *ConfluenceConnector) getClient(ref utils.ConnectionRef, site string) (*confulence.Client, error) {
+	client, _, err := cc.getSiteClient(ref, site)
+	return client, err
+}
+
+// getSiteToken 获取有效的 token 和所选站点
+func (cc *ConfluenceConnector) getSiteToken(ref utils.ConnectionRef, site string) (*utils.TokenInfo, *utils.ConnectionSite, error) {
+	token, err := cc.tokenManager.GetConnectionToken(ref, auth.ProviderConfluence)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取用户的Confluence token失败: %w", err)
+	}
+	selected, err := selectSite(token, site)
+	if err != nil {
+		return nil, nil, err
 	}
-	site := "https://api.atlassian.com/ex/confluence/" + cloudID + "/"
+	return token, selected, nil
+}
 
-	client, err := confulence.New(
-		http.DefaultClient,
-		site,
-	)
+// getSiteClient 同 getClient，同时返回所选站点
+func (cc *ConfluenceConnector) getSiteClient(ref utils.ConnectionRef, site string) (*confulence.Client, *utils.ConnectionSite, error) {
+	token, selected, err := cc.getSiteToken(ref, site)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := cc.newClient(token, selected)
 	if err != nil {
 		return nil, nil, err
 	}
+	return client, selected, nil
+}
 
+// newClient 用 token 创建指向站点的客户端
+func (cc *ConfluenceConnector) newClient(token *utils.TokenInfo, site *utils.ConnectionSite) (*confulence.Client, error) {
+	client, err := confulence.New(cc.HTTPClient, cc.BaseURL+site.ID+"/")
+	if err != nil {
+		return nil, fmt.Errorf("创建Confluence客户端失败: %v", err)
+	}
 	client.Auth.SetBearerToken(token.AccessToken)
-	// 示例：获取Confluence页面列表
-	return client.Page.Gets(context.Background(), nil, "", 10)
+	return client, nil
+}
+
+// ListSites 获取连接可访问的所有站点
+func (cc *ConfluenceConnector) ListSites(ref utils.ConnectionRef) ([]Site, error) {
+	token, err := cc.tokenManager.ResolveConnection(ref, auth.ProviderConfluence)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户的Confluence连接失败: %w", err)
+	}
+	defaultSite, _ := selectSite(token, "")
+
+	var sites []Site
+	for _, s := range connectionSites(token) {
+		sites = append(sites, Site{ConnectionSite: s, Default: defaultSite != nil && s.ID == defaultSite.ID})
+	}
+	return sites, nil
+}
+
+// SetDefaultSite 设置连接的默认站点
+func (cc *ConfluenceConnector) SetDefaultSite(ref utils.ConnectionRef, site string) (*Site, error) {
+	token, err := cc.tokenManager.ResolveConnection(ref, auth.ProviderConfluence)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户的Confluence连接失败: %w", err)
+	}
+	selected, err := selectSite(token, site)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cc.tokenManager.UpdateConnection(ref.UserID, token.ConnectionID, func(t *utils.TokenInfo) {
+		metadata := make(map[string]string, len(t.Metadata)+3)
+		for k, v := range t.Metadata {
+			metadata[k] = v
+		}
+		metadata["cloud_id"] = selected.ID
+		metadata["site_name"] = selected.Name
+		metadata["site_url"] = selected.URL
+		t.Metadata = metadata
+	})
+	if err != nil {
+		return nil, fmt.Errorf("设置默认站点失败: %v", err)
+	}
+	return &Site{ConnectionSite: *selected, Default: true}, nil
+}
+
+// connectionSites 返回连接可访问的站点，旧连接只记录了默认站点
+func connectionSites(token *utils.TokenInfo) []utils.ConnectionSite {
+	if len(token.Sites) > 0 {
+		return token.Sites
+	}
+	if cloudID := token.Metadata["cloud_id"]; cloudID != "" {
+		return []utils.ConnectionSite{{ID: cloudID, Name: token.Metadata["site_name"], URL: token.Metadata["site_url"]}}
+	}
+	return nil
+}
+
+// selectSite 按 cloud ID、站点名或站点域名选择站点，site 为空时返回默认站点
+func selectSite(token *utils.TokenInfo, site string) (*utils.ConnectionSite, error) {
+	sites := connectionSites(token)
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("Confluence连接缺少站点信息，请重新授权")
+	}
+	if site == "" {
+		site = token.Metadata["cloud_id"]
+		if site == "" {
+			return &sites[0], nil
+		}
+	}
+
+	for i := range sites {
+		s := &sites[i]
+		if s.ID == site || strings.EqualFold(s.Name, site) || strings.EqualFold(siteHost(s.URL), siteHost(site)) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSiteNotAccessible, site)
+}
+
+// siteHost 返回站点地址的域名，支持不带协议的写法（acme.atlassian.net）
+func siteHost(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// GetUserInfo 获取当前授权用户的信息
+func (cc *ConfluenceConnector) GetUserInfo(ref utils.ConnectionRef, site string) (*ConfluenceUser, error) {
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	req, err := client.NewRequest(context.Background(), http.MethodGet, "wiki/rest/api/user/current", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	user := new(ConfluenceUser)
+	if _, err := client.Call(req, user); err != nil {
+		return nil, fmt.Errorf("获取Confluence用户信息失败: %v", err)
+	}
+	return user, nil
+}
+
+// SpaceList 一页空间，NextCursor 为空表示没有更多
+type SpaceList struct {
+	Spaces     []*models.SpaceSchemeV2 `json:"spaces"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+// PageList 一页页面，NextCursor 为空表示没有更多
+type PageList struct {
+	Pages      []*models.PageScheme `json:"pages"`
+	NextCursor string               `json:"next_cursor,omitempty"`
+}
+
+// ChildPageList 一页子页面，NextCursor 为空表示没有更多
+type ChildPageList struct {
+	Children   []*models.ChildPageScheme `json:"children"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+// Ancestor 页面的祖先节点
+type Ancestor struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+}
+
+// ListSpaces 获取一页空间列表，cursor 为上一页返回的 NextCursor
+func (cc *ConfluenceConnector) ListSpaces(ref utils.ConnectionRef, site, cursor string, limit int) (*SpaceList, error) {
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	chunk, _, err := client.Space.Bulk(context.Background(), nil, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("获取空间列表失败: %v", err)
+	}
+	return &SpaceList{Spaces: chunk.Results, NextCursor: nextCursor(chunk.Links.Next)}, nil
+}
+
+// ListPages 获取一页页面列表，spaceID 为空时返回整个站点的页面
+func (cc *ConfluenceConnector) ListPages(ref utils.ConnectionRef, site, spaceID, cursor string, limit int) (*PageList, error) {
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunk *models.PageChunkScheme
+	if spaceID != "" {
+		id, convErr := strconv.Atoi(spaceID)
+		if convErr != nil {
+			return nil, fmt.Errorf("无效的空间ID: %s", spaceID)
+		}
+		chunk, _, err = client.Page.GetsBySpace(context.Background(), id, cursor, limit)
+	} else {
+		chunk, _, err = client.Page.Gets(context.Background(), nil, cursor, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("获取页面列表失败: %v", err)
+	}
+
+	list := &PageList{Pages: chunk.Results}
+	if chunk.Links != nil {
+		list.NextCursor = nextCursor(chunk.Links.Next)
+	}
+	return list, nil
+}
+
+// ListChildren 获取一页直接子页面
+func (cc *ConfluenceConnector) ListChildren(ref utils.ConnectionRef, site, pageID, cursor string, limit int) (*ChildPageList, error) {
+	id, err := strconv.Atoi(pageID)
+	if err != nil {
+		return nil, fmt.Errorf("无效的页面ID: %s", pageID)
+	}
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	chunk, _, err := client.Page.GetsByParent(context.Background(), id, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("获取子页面失败: %v", err)
+	}
+
+	list := &ChildPageList{Children: chunk.Results}
+	if chunk.Links != nil {
+		list.NextCursor = nextCursor(chunk.Links.Next)
+	}
+	return list, nil
+}
+
+// GetAncestors 获取页面的祖先，从空间根页面到直接父页面排列
+func (cc *ConfluenceConnector) GetAncestors(ref utils.ConnectionRef, site, pageID string) ([]*Ancestor, error) {
+	if _, err := strconv.Atoi(pageID); err != nil {
+		return nil, fmt.Errorf("无效的页面ID: %s", pageID)
+	}
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("wiki/api/v2/pages/%s/ancestors?limit=250", pageID)
+	req, err := client.NewRequest(context.Background(), http.MethodGet, endpoint, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Results []*Ancestor `json:"results"`
+	}
+	if _, err := client.Call(req, &result); err != nil {
+		return nil, fmt.Errorf("获取页面祖先失败: %v", err)
+	}
+	return result.Results, nil
+}
+
+// GetPageTree 沿游标读取空间内的全部页面，按父子关系组装成树
+func (cc *ConfluenceConnector) GetPageTree(ref utils.ConnectionRef, site, spaceID string) (*PageTree, error) {
+	if _, err := strconv.Atoi(spaceID); err != nil {
+		return nil, fmt.Errorf("无效的空间ID: %s", spaceID)
+	}
+
+	var pages []*models.PageScheme
+	cursor := ""
+	for {
+		list, err := cc.ListPages(ref, site, spaceID, cursor, maxPageLimit)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, list.Pages...)
+		if list.NextCursor == "" || list.NextCursor == cursor {
+			break
+		}
+		cursor = list.NextCursor
+	}
+	return buildPageTree(spaceID, pages), nil
+}
+
+// nextCursor 从 _links.next（如 /wiki/api/v2/pages?cursor=xxx&limit=25）中取出游标
+func nextCursor(next string) string {
+	if next == "" {
+		return ""
+	}
+	u, err := url.Parse(next)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("cursor")
+}
+
+// GetPage 获取单个页面，format 为正文格式（storage、atlas_doc_format 等），为空时不返回正文
+func (cc *ConfluenceConnector) GetPage(ref utils.ConnectionRef, site, pageID, format string) (*models.PageScheme, error) {
+	id, err := strconv.Atoi(pageID)
+	if err != nil {
+		return nil, fmt.Errorf("无效的页面ID: %s", pageID)
+	}
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	page, _, err := client.Page.Get(context.Background(), id, format, false, 0)
+	if err != nil {
+		return nil, fmt.Errorf("获取页面详情失败: %v", err)
+	}
+	return page, nil
+}
+
+// PageContent 转换为 Markdown 或纯文本的页面正文
+type PageContent struct {
+	Page    *models.PageScheme `json:"page"`
+	Format  string             `json:"format"`
+	Content string             `json:"content"`
+}
+
+// GetPageContent 获取页面并把 storage 格式正文转换为 Markdown 或纯文本
+func (cc *ConfluenceConnector) GetPageContent(ref utils.ConnectionRef, site, pageID, format string) (*PageContent, error) {
+	if format != FormatMarkdown && format != FormatText {
+		return nil, fmt.Errorf("不支持的正文格式: %s", format)
+	}
+	id, err := strconv.Atoi(pageID)
+	if err != nil {
+		return nil, fmt.Errorf("无效的页面ID: %s", pageID)
+	}
+	client, selected, err := cc.getSiteClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+	page, _, err := client.Page.Get(context.Background(), id, FormatStorage, false, 0)
+	if err != nil {
+		return nil, fmt.Errorf("获取页面详情失败: %v", err)
+	}
+
+	opts := ConvertOptions{SiteURL: selected.URL, PageID: page.ID}
+	// 同空间的页面链接不带空间 key，需要当前页面的空间 key 生成地址；查询失败时这些链接只保留文字
+	if spaceID, convErr := strconv.Atoi(page.SpaceID); convErr == nil {
+		if space, _, spaceErr := client.Space.Get(context.Background(), spaceID, ""); spaceErr == nil {
+			opts.SpaceKey = space.Key
+		}
+	}
+
+	storage := ""
+	if page.Body != nil && page.Body.Storage != nil {
+		storage = page.Body.Storage.Value
+	}
+	convert := StorageToText
+	if format == FormatMarkdown {
+		convert = StorageToMarkdown
+	}
+	content, err := convert(storage, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 正文已转换，不再重复返回 storage 原文
+	page.Body = nil
+	return &PageContent{Page: page, Format: format, Content: content}, nil
+}
+
+// Search 用 CQL 搜索内容，cursor 为上一页返回的 NextCursor
+func (cc *ConfluenceConnector) Search(ref utils.ConnectionRef, site, cql, cursor string, limit int) (*SearchResults, error) {
+	client, err := cc.getClient(ref, site)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("cql", cql)
+	query.Set("limit", strconv.Itoa(limit))
+	query.Set("excerpt", "highlight_unescaped")
+	query.Set("expand", "content.space")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	req, err := client.NewRequest(context.Background(), http.MethodGet, "wiki/rest/api/search?"+query.Encode(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(searchResponse)
+	if _, err := client.Call(req, resp); err != nil {
+		return nil, fmt.Errorf("搜索Confluence内容失败: %v", err)
+	}
+	return toSearchResults(cql, resp), nil
 }
@@ -0,0 +1,650 @@
+package confluence
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// 页面正文输出格式
+const (
+	FormatStorage  = "storage"
+	FormatMarkdown = "markdown"
+	FormatText     = "text"
+)
+
+// ConvertOptions 生成链接所需的页面上下文，缺少时链接只保留文字
+type ConvertOptions struct {
+	// SiteURL 站点地址，如 https://acme.atlassian.net
+	SiteURL string
+	// SpaceKey 当前页面所在空间，用于解析未指定空间的页面链接
+	SpaceKey string
+	// PageID 当前页面ID，用于生成附件下载链接
+	PageID string
+}
+
+// StorageToMarkdown 把 storage 格式（带 ac:/ri: 宏的 XHTML）转换为 Markdown
+func StorageToMarkdown(storage string, opts ConvertOptions) (string, error) {
+	return convertStorage(storage, &renderer{opts: opts, markdown: true})
+}
+
+// StorageToText 把 storage 格式转换为纯文本，用于索引
+func StorageToText(storage string, opts ConvertOptions) (string, error) {
+	return convertStorage(storage, &renderer{opts: opts})
+}
+
+func convertStorage(storage string, r *renderer) (string, error) {
+	root, err := parseStorage(storage)
+	if err != nil {
+		return "", fmt.Errorf("解析storage格式失败: %v", err)
+	}
+	return r.blocks(root.children), nil
+}
+
+// node storage 文档中的元素或文本，name 带命名空间前缀（如 ac:structured-macro），文本节点的 name 为空
+type node struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*node
+}
+
+func (n *node) attr(name string) string {
+	return n.attrs[name]
+}
+
+// child 返回第一个指定名称的子元素
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// rawText 返回所有文本，保留原始空白（用于代码块）
+func (n *node) rawText() string {
+	if n.name == "" {
+		return n.text
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteString(c.rawText())
+	}
+	return sb.String()
+}
+
+// parseStorage 用非严格模式的 encoding/xml 解析：storage 格式没有声明命名空间，且包含 &nbsp; 等 HTML 实体
+func parseStorage(storage string) (*node, error) {
+	dec := xml.NewDecoder(strings.NewReader(storage))
+	dec.Strict = false
+	dec.AutoClose = storageAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	root := &node{name: "root"}
+	stack := []*node{root}
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: qualifiedName(t.Name), attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				n.attrs[qualifiedName(a.Name)] = a.Value
+			}
+			parent.children = append(parent.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 1 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			parent.children = append(parent.children, &node{text: string(t)})
+		}
+	}
+	return root, nil
+}
+
+// storageAutoClose 可以不闭合的 HTML 空元素。AutoClose 只比较本地名，
+// xml.HTMLAutoClose 里的 link 会误伤 ac:link，所以去掉
+var storageAutoClose = func() []string {
+	var names []string
+	for _, name := range xml.HTMLAutoClose {
+		if name != "link" {
+			names = append(names, name)
+		}
+	}
+	return names
+}()
+
+func qualifiedName(n xml.Name) string {
+	if n.Space != "" {
+		return strings.ToLower(n.Space + ":" + n.Local)
+	}
+	return strings.ToLower(n.Local)
+}
+
+// renderer 把节点树渲染为 Markdown（markdown=true）或纯文本
+type renderer struct {
+	opts     ConvertOptions
+	markdown bool
+}
+
+var (
+	spaceRun     = regexp.MustCompile(`[ \t\r\n\x{00a0}]+`)
+	blankLineRun = regexp.MustCompile(`\n{3,}`)
+)
+
+// blockElements 会开始新段落的元素
+var blockElements = map[string]bool{
+	"p": true, "div": true, "section": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "table": true, "pre": true, "blockquote": true, "hr": true,
+	"ac:task-list": true, "ac:layout": true, "ac:layout-section": true, "ac:layout-cell": true,
+}
+
+// inlineMacros 出现在行内的宏，其余宏按块处理
+var inlineMacros = map[string]bool{"status": true, "anchor": true, "jira": true}
+
+// panelMacros 提示框类宏及其标签
+var panelMacros = map[string]string{"info": "Info", "note": "Note", "warning": "Warning", "tip": "Tip", "panel": ""}
+
+func isBlock(n *node) bool {
+	if n.name == "ac:structured-macro" {
+		return !inlineMacros[n.attr("ac:name")]
+	}
+	return blockElements[n.name]
+}
+
+// blocks 渲染一组节点，连续的行内内容合并为一个段落，块之间空一行
+func (r *renderer) blocks(nodes []*node) string {
+	return r.joinBlocks(nodes, "\n\n")
+}
+
+// joinBlocks 同 blocks，块之间用 sep 分隔；只清理段落文字的空白，代码块保持原样
+func (r *renderer) joinBlocks(nodes []*node, sep string) string {
+	var out []string
+	var inline strings.Builder
+	flush := func() {
+		if text := cleanLines(inline.String()); text != "" {
+			out = append(out, text)
+		}
+		inline.Reset()
+	}
+	for _, n := range nodes {
+		if !isBlock(n) {
+			inline.WriteString(r.inline(n))
+			continue
+		}
+		flush()
+		if text := r.block(n); text != "" {
+			out = append(out, text)
+		}
+	}
+	flush()
+	return blankLineRun.ReplaceAllString(strings.Join(out, sep), "\n\n")
+}
+
+func (r *renderer) block(n *node) string {
+	switch n.name {
+	case "p":
+		return cleanLines(r.inlines(n.children))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		text := strings.TrimSpace(spaceRun.ReplaceAllString(r.inlines(n.children), " "))
+		if text == "" || !r.markdown {
+			return text
+		}
+		return strings.Repeat("#", int(n.name[1]-'0')) + " " + text
+	case "ul", "ol":
+		return strings.Join(r.list(n, ""), "\n")
+	case "table":
+		return r.table(n)
+	case "pre":
+		return r.code(n.rawText(), "")
+	case "blockquote":
+		return r.quote("", r.blocks(n.children))
+	case "hr":
+		if r.markdown {
+			return "---"
+		}
+		return ""
+	case "ac:structured-macro":
+		return r.macro(n)
+	case "ac:task-list":
+		return r.tasks(n)
+	default:
+		return r.blocks(n.children)
+	}
+}
+
+func (r *renderer) inlines(nodes []*node) string {
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(r.inline(n))
+	}
+	return sb.String()
+}
+
+func (r *renderer) inline(n *node) string {
+	if n.name == "" {
+		return spaceRun.ReplaceAllString(n.text, " ")
+	}
+	if isBlock(n) {
+		return "\n" + r.block(n) + "\n"
+	}
+	switch n.name {
+	case "strong", "b":
+		return r.wrap(r.inlines(n.children), "**")
+	case "em", "i":
+		return r.wrap(r.inlines(n.children), "_")
+	case "s", "del":
+		return r.wrap(r.inlines(n.children), "~~")
+	case "code":
+		return r.wrap(n.rawText(), "`")
+	case "br":
+		return "\n"
+	case "a":
+		text := strings.TrimSpace(r.inlines(n.children))
+		return r.link(text, n.attr("href"))
+	case "img":
+		return r.imageLink(n.attr("alt"), n.attr("src"))
+	case "ac:link":
+		return r.acLink(n)
+	case "ac:image":
+		return r.acImage(n)
+	case "ac:emoticon":
+		if emoji := n.attr("ac:emoji-fallback"); emoji != "" {
+			return emoji
+		}
+		return ":" + n.attr("ac:name") + ":"
+	case "time":
+		return n.attr("datetime")
+	case "ac:structured-macro":
+		return r.inlineMacro(n)
+	case "ac:placeholder", "ac:parameter":
+		return ""
+	default:
+		return r.inlines(n.children)
+	}
+}
+
+// wrap 给行内文本加 Markdown 标记，保留两侧空白以免和相邻文字粘连
+func (r *renderer) wrap(text, marker string) string {
+	trimmed := strings.TrimSpace(text)
+	if !r.markdown || trimmed == "" {
+		return text
+	}
+	lead := text[:len(text)-len(strings.TrimLeft(text, " "))]
+	trail := text[len(strings.TrimRight(text, " ")):]
+	return lead + marker + trimmed + marker + trail
+}
+
+func (r *renderer) link(text, target string) string {
+	if text == "" {
+		text = target
+	}
+	if !r.markdown || target == "" {
+		return text
+	}
+	return "[" + text + "](" + target + ")"
+}
+
+func (r *renderer) imageLink(alt, src string) string {
+	if !r.markdown {
+		return alt
+	}
+	if src == "" {
+		return alt
+	}
+	return "![" + alt + "](" + src + ")"
+}
+
+// acLink 处理 ac:link：用户提及、页面链接、附件链接、空间链接和页内锚点
+func (r *renderer) acLink(n *node) string {
+	text := ""
+	if body := n.child("ac:link-body"); body != nil {
+		text = strings.TrimSpace(r.inlines(body.children))
+	} else if body := n.child("ac:plain-text-link-body"); body != nil {
+		text = strings.TrimSpace(body.rawText())
+	}
+
+	if user := n.child("ri:user"); user != nil {
+		if text == "" {
+			text = user.attr("ri:account-id")
+		}
+		return "@" + strings.TrimPrefix(text, "@")
+	}
+	if page := n.child("ri:page"); page != nil {
+		title := page.attr("ri:content-title")
+		if text == "" {
+			text = title
+		}
+		return r.link(text, r.pageURL(page.attr("ri:space-key"), title, n.attr("ac:anchor")))
+	}
+	if attachment := n.child("ri:attachment"); attachment != nil {
+		filename := attachment.attr("ri:filename")
+		if text == "" {
+			text = filename
+		}
+		return r.link(text, r.attachmentURL(attachment))
+	}
+	if space := n.child("ri:space"); space != nil {
+		key := space.attr("ri:space-key")
+		if text == "" {
+			text = key
+		}
+		return r.link(text, r.spaceURL(key))
+	}
+	if anchor := n.attr("ac:anchor"); anchor != "" {
+		if text == "" {
+			text = anchor
+		}
+		return r.link(text, "#"+anchor)
+	}
+	return text
+}
+
+// acImage 处理 ac:image，图片来自附件或外部地址
+func (r *renderer) acImage(n *node) string {
+	alt := n.attr("ac:alt")
+	if attachment := n.child("ri:attachment"); attachment != nil {
+		if alt == "" {
+			alt = attachment.attr("ri:filename")
+		}
+		return r.imageLink(alt, r.attachmentURL(attachment))
+	}
+	if u := n.child("ri:url"); u != nil {
+		return r.imageLink(alt, u.attr("ri:value"))
+	}
+	return alt
+}
+
+func (r *renderer) pageURL(spaceKey, title, anchor string) string {
+	if spaceKey == "" {
+		spaceKey = r.opts.SpaceKey
+	}
+	if r.opts.SiteURL == "" || spaceKey == "" || title == "" {
+		return ""
+	}
+	target := r.siteURL() + "/wiki/display/" + url.PathEscape(spaceKey) + "/" + url.QueryEscape(title)
+	if anchor != "" {
+		target += "#" + anchor
+	}
+	return target
+}
+
+// attachmentURL 只能为当前页面的附件生成下载地址（引用其他页面的附件时没有页面ID）
+func (r *renderer) attachmentURL(attachment *node) string {
+	if r.opts.SiteURL == "" || r.opts.PageID == "" || attachment.child("ri:page") != nil || attachment.child("ri:blog-post") != nil {
+		return ""
+	}
+	return r.siteURL() + "/wiki/download/attachments/" + r.opts.PageID + "/" + url.PathEscape(attachment.attr("ri:filename"))
+}
+
+func (r *renderer) spaceURL(key string) string {
+	if r.opts.SiteURL == "" || key == "" {
+		return ""
+	}
+	return r.siteURL() + "/wiki/spaces/" + url.PathEscape(key)
+}
+
+func (r *renderer) siteURL() string {
+	return strings.TrimSuffix(r.opts.SiteURL, "/")
+}
+
+// macroParams 读取宏参数，未命名参数（ac:name 为空）以空字符串为键
+func macroParams(n *node) map[string]string {
+	params := make(map[string]string)
+	for _, c := range n.children {
+		if c.name == "ac:parameter" {
+			params[c.attr("ac:name")] = strings.TrimSpace(c.rawText())
+		}
+	}
+	return params
+}
+
+// macro 处理块级宏：代码、提示框、折叠块，其余宏只保留正文
+func (r *renderer) macro(n *node) string {
+	name := n.attr("ac:name")
+	params := macroParams(n)
+	richBody := ""
+	if body := n.child("ac:rich-text-body"); body != nil {
+		richBody = r.blocks(body.children)
+	}
+
+	switch name {
+	case "code", "noformat":
+		body := ""
+		if plain := n.child("ac:plain-text-body"); plain != nil {
+			body = plain.rawText()
+		}
+		return r.code(body, params["language"])
+	case "info", "note", "warning", "tip", "panel":
+		label := panelMacros[name]
+		if title := params["title"]; title != "" {
+			if label != "" {
+				label += ": "
+			}
+			label += title
+		}
+		return r.quote(label, richBody)
+	case "expand":
+		title := params["title"]
+		if title == "" {
+			title = "Click here to expand..."
+		}
+		return strings.TrimSpace(r.wrap(title, "**") + "\n\n" + richBody)
+	case "toc", "children", "pagetree", "recently-updated", "contentbylabel":
+		// 动态生成的目录类宏没有静态内容
+		return ""
+	}
+	if richBody != "" {
+		return richBody
+	}
+	if plain := n.child("ac:plain-text-body"); plain != nil {
+		return r.code(plain.rawText(), "")
+	}
+	return ""
+}
+
+// inlineMacro 处理行内宏，状态标签保留标题
+func (r *renderer) inlineMacro(n *node) string {
+	params := macroParams(n)
+	switch n.attr("ac:name") {
+	case "status":
+		title := params["title"]
+		if title == "" {
+			title = params["colour"]
+		}
+		if r.markdown && title != "" {
+			return "`" + title + "`"
+		}
+		return title
+	case "jira":
+		return params["key"]
+	}
+	return ""
+}
+
+func (r *renderer) code(body, language string) string {
+	body = strings.Trim(body, "\n")
+	if !r.markdown {
+		return body
+	}
+	fence := "```"
+	for strings.Contains(body, fence) {
+		fence += "`"
+	}
+	return fence + language + "\n" + body + "\n" + fence
+}
+
+// quote 渲染引用和提示框，label 非空时作为首行
+func (r *renderer) quote(label, body string) string {
+	if !r.markdown {
+		if label == "" {
+			return body
+		}
+		return strings.TrimSpace(label + "\n" + body)
+	}
+	var lines []string
+	if label != "" {
+		lines = append(lines, "**"+label+"**")
+		if body != "" {
+			lines = append(lines, "")
+		}
+	}
+	if body != "" {
+		lines = append(lines, strings.Split(body, "\n")...)
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight("> "+line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// list 渲染列表，嵌套列表按父项标记宽度缩进
+func (r *renderer) list(n *node, indent string) []string {
+	var lines []string
+	index := 1
+	if start := n.attr("start"); start != "" {
+		fmt.Sscanf(start, "%d", &index)
+	}
+	for _, item := range n.children {
+		if item.name != "li" {
+			continue
+		}
+		marker := "- "
+		if n.name == "ol" {
+			marker = fmt.Sprintf("%d. ", index)
+			index++
+		}
+		continuation := indent + strings.Repeat(" ", len(marker))
+
+		var content []*node
+		var nested []*node
+		for _, c := range item.children {
+			if c.name == "ul" || c.name == "ol" {
+				nested = append(nested, c)
+			} else {
+				content = append(content, c)
+			}
+		}
+		text := r.joinBlocks(content, "\n")
+		for i, line := range strings.Split(text, "\n") {
+			if i == 0 {
+				lines = append(lines, indent+marker+line)
+			} else if line != "" {
+				lines = append(lines, continuation+line)
+			}
+		}
+		for _, sub := range nested {
+			lines = append(lines, r.list(sub, continuation)...)
+		}
+	}
+	return lines
+}
+
+// tasks 渲染任务列表，Markdown 中使用复选框
+func (r *renderer) tasks(n *node) string {
+	var lines []string
+	for _, task := range n.children {
+		if task.name != "ac:task" {
+			continue
+		}
+		done := false
+		if status := task.child("ac:task-status"); status != nil {
+			done = strings.TrimSpace(status.rawText()) == "complete"
+		}
+		body := ""
+		if b := task.child("ac:task-body"); b != nil {
+			body = flatten(r.inlines(b.children))
+		}
+		box := "[ ] "
+		if done {
+			box = "[x] "
+		}
+		if r.markdown {
+			box = "- " + box
+		}
+		lines = append(lines, box+body)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// table 渲染表格。Markdown 表格必须有表头，首行不是表头时也作为表头；纯文本用制表符分隔单元格
+func (r *renderer) table(n *node) string {
+	var rows [][]string
+	var collect func(*node)
+	collect = func(n *node) {
+		for _, c := range n.children {
+			switch c.name {
+			case "tr":
+				var row []string
+				for _, cell := range c.children {
+					if cell.name == "th" || cell.name == "td" {
+						row = append(row, flatten(r.blocks(cell.children)))
+					}
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot":
+				collect(c)
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	if !r.markdown {
+		lines := make([]string, len(rows))
+		for i, row := range rows {
+			lines[i] = strings.Join(row, "\t")
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	columns := 0
+	for _, row := range rows {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	formatRow := func(row []string) string {
+		cells := make([]string, columns)
+		for i := range cells {
+			if i < len(row) {
+				cells[i] = strings.ReplaceAll(row[i], "|", `\|`)
+			}
+		}
+		return strings.TrimRight("| "+strings.Join(cells, " | ")+" |", " ")
+	}
+	lines := []string{formatRow(rows[0]), "|" + strings.Repeat(" --- |", columns)}
+	for _, row := range rows[1:] {
+		lines = append(lines, formatRow(row))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cleanLines 去掉每行首尾空白和多余空行
+func cleanLines(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaceRun.ReplaceAllString(line, " "))
+	}
+	return strings.TrimSpace(blankLineRun.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// flatten 把多行内容压成一行（表格单元格、任务）
+func flatten(text string) string {
+	return strings.TrimSpace(spaceRun.ReplaceAllString(text, " "))
+}
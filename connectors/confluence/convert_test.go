@@ -0,0 +1,66 @@
+package confluence
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "重新生成 testdata/storage 下的 golden 文件")
+
+// TestStorageConversionGolden 把 testdata/storage/*.xml 转换后与同名 .md/.txt 比较，
+// 修改转换逻辑后用 go test -run Golden -update 重新生成
+func TestStorageConversionGolden(t *testing.T) {
+	opts := ConvertOptions{SiteURL: "https://acme.atlassian.net/", SpaceKey: "ENG", PageID: "98765"}
+	inputs, err := filepath.Glob(filepath.Join("testdata", "storage", "*.xml"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("no golden inputs: %v", err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(input, ".xml")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			storage, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ext, convert := range map[string]func(string, ConvertOptions) (string, error){
+				".md":  StorageToMarkdown,
+				".txt": StorageToText,
+			} {
+				got, err := convert(string(storage), opts)
+				if err != nil {
+					t.Fatalf("%s: %v", ext, err)
+				}
+				golden := name + ext
+				if *update {
+					if err := os.WriteFile(golden, []byte(got+"\n"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("read golden (run with -update to create): %v", err)
+				}
+				if got+"\n" != string(want) {
+					t.Errorf("%s mismatch\n--- got ---\n%s\n--- want ---\n%s", golden, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStorageToMarkdown_LinksWithoutContext(t *testing.T) {
+	storage := `<p><ac:link><ri:page ri:content-title="Runbook" /></ac:link> and <ac:link><ri:attachment ri:filename="a.pdf" /></ac:link></p>`
+	got, err := StorageToMarkdown(storage, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 没有站点地址时无法生成链接，只保留文字
+	if got != "Runbook and a.pdf" {
+		t.Fatalf("unexpected markdown: %q", got)
+	}
+}
@@ -1,7 +1,12 @@
 package confluence
 
 import (
+	"connector-demo/middleware"
 	"connector-demo/routes"
+	"connector-demo/utils"
+	"errors"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,7 +18,349 @@ func SetConfluenceService(s *ConfluenceService) {
 }
 
 func RegisterRoutes(rg *gin.RouterGroup) {
+	confluenceGroup := rg.Group("/confluence")
 
+	// 列出连接可访问的站点
+	confluenceGroup.GET("/sites", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		sites, err := confluenceService.ListSites(ref)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"sites": sites})
+	})
+
+	// 设置默认站点，site 可为 cloud ID、站点名或站点域名
+	confluenceGroup.PUT("/sites/default", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		site := c.Query(SiteParam)
+		if site == "" {
+			c.JSON(400, gin.H{"error": "缺少site参数"})
+			return
+		}
+		selected, err := confluenceService.SetDefaultSite(ref, site)
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"site": selected})
+	})
+
+	confluenceGroup.GET("/user-info", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		info, err := confluenceService.GetUserInfo(ref, c.Query(SiteParam))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"user_info": info})
+	})
+
+	confluenceGroup.GET("/test", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		if !confluenceService.TestConnection(ref, c.Query(SiteParam)) {
+			c.JSON(500, gin.H{"error": "Confluence连接测试失败"})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Confluence连接测试成功"})
+	})
+
+	// 获取空间列表，cursor 为上一页返回的 next_cursor
+	confluenceGroup.GET("/spaces", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		spaces, err := confluenceService.ListSpaces(ref, c.Query(SiteParam), c.Query("cursor"), queryLimit(c, 25))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, spaces)
+	})
+
+	// 获取空间的页面树
+	confluenceGroup.GET("/spaces/:id/tree", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		spaceID := c.Param("id")
+		if _, err := strconv.Atoi(spaceID); err != nil {
+			c.JSON(400, gin.H{"error": "无效的空间ID"})
+			return
+		}
+		tree, err := confluenceService.GetPageTree(ref, c.Query(SiteParam), spaceID)
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"tree": tree})
+	})
+
+	// 搜索内容：cql 直接使用 CQL，或用 text、space、label、type、modified_after 组合条件（不能同时使用）
+	confluenceGroup.GET("/search", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		cql := strings.TrimSpace(c.Query("cql"))
+		filter := SearchFilter{
+			Text:          c.Query("text"),
+			Spaces:        queryList(c, "space"),
+			Labels:        queryList(c, "label"),
+			Type:          c.Query("type"),
+			ModifiedAfter: c.Query("modified_after"),
+		}
+		if cql == "" && filter.IsEmpty() {
+			c.JSON(400, gin.H{"error": "缺少查询条件：cql 或 text、space、label、type、modified_after"})
+			return
+		}
+		if cql != "" && !filter.IsEmpty() {
+			c.JSON(400, gin.H{"error": "cql 不能与简化条件同时使用"})
+			return
+		}
+		if cql == "" {
+			if _, err := filter.CQL(); err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		results, err := confluenceService.Search(ref, c.Query(SiteParam), cql, filter, c.Query("cursor"), queryLimit(c, 25))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, results)
+	})
+
+	// 增量同步空间页面，返回自上次同步以来的变更事件；full=true 时全量同步并检测已清除的页面
+	confluenceGroup.POST("/spaces/:id/sync", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		spaceID := c.Param("id")
+		if _, err := strconv.Atoi(spaceID); err != nil {
+			c.JSON(400, gin.H{"error": "无效的空间ID"})
+			return
+		}
+		result, err := confluenceService.SyncSpace(ref, c.Query(SiteParam), spaceID, c.Query("full") == "true")
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
+	})
+
+	// 获取页面列表，space_id 可选，cursor 为上一页返回的 next_cursor
+	confluenceGroup.GET("/pages", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		spaceID := c.Query("space_id")
+		if _, err := strconv.Atoi(spaceID); spaceID != "" && err != nil {
+			c.JSON(400, gin.H{"error": "无效的空间ID"})
+			return
+		}
+		pages, err := confluenceService.ListPages(ref, c.Query(SiteParam), spaceID, c.Query("cursor"), queryLimit(c, 25))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, pages)
+	})
+
+	// 获取直接子页面
+	confluenceGroup.GET("/pages/:id/children", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+		children, err := confluenceService.ListChildren(ref, c.Query(SiteParam), pageID, c.Query("cursor"), queryLimit(c, 25))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, children)
+	})
+
+	// 获取页面附件
+	confluenceGroup.GET("/pages/:id/attachments", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+		attachments, err := confluenceService.ListAttachments(ref, c.Query(SiteParam), pageID, c.Query("cursor"), queryLimit(c, 25))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, attachments)
+	})
+
+	// 下载附件：服务端用用户的 token 拉取并流式返回，支持 Range；inline=true 时图片、PDF、纯文本在浏览器中直接打开
+	confluenceGroup.GET("/attachments/:id/download", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		attachmentID := c.Param("id")
+		if !attachmentIDPattern.MatchString(attachmentID) {
+			c.JSON(400, gin.H{"error": "无效的附件ID"})
+			return
+		}
+
+		download, err := confluenceService.DownloadAttachment(ref, c.Query(SiteParam), attachmentID, c.GetHeader("Range"))
+		if err != nil {
+			status := siteErrorStatus(err)
+			if errors.Is(err, ErrRangeNotSatisfiable) {
+				status = 416
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		defer download.Body.Close()
+
+		// 附件类型由上传者决定，只有白名单内的类型允许 inline 打开，其余强制下载
+		contentType, headers := utils.AttachmentHeaders(download.Attachment.Title, download.ContentType, c.Query("inline") == "true")
+		for key := range download.Header {
+			headers[key] = download.Header.Get(key)
+		}
+		c.DataFromReader(download.StatusCode, download.ContentLength, contentType, download.Body, headers)
+	})
+
+	// 获取页面评论，按回复组装成树；type 为 footer、inline，缺省返回全部；format 为 storage（默认）、markdown 或 text
+	confluenceGroup.GET("/pages/:id/comments", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+		kind := c.Query("type")
+		if kind != "" && kind != CommentFooter && kind != CommentInline {
+			c.JSON(400, gin.H{"error": "type只支持footer或inline"})
+			return
+		}
+		format := c.DefaultQuery("format", FormatStorage)
+		if format != FormatStorage && format != FormatMarkdown && format != FormatText {
+			c.JSON(400, gin.H{"error": "format只支持markdown、text或storage"})
+			return
+		}
+		comments, err := confluenceService.GetComments(ref, c.Query(SiteParam), pageID, kind, format)
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, comments)
+	})
+
+	// 获取页面标签
+	confluenceGroup.GET("/pages/:id/labels", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+		labels, err := confluenceService.GetLabels(ref, c.Query(SiteParam), pageID)
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"labels": labels})
+	})
+
+	// 获取版本历史，从新到旧
+	confluenceGroup.GET("/pages/:id/versions", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+		versions, err := confluenceService.ListVersions(ref, c.Query(SiteParam), pageID, c.Query("cursor"), queryLimit(c, 25))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, versions)
+	})
+
+	// 获取页面祖先，从根页面到直接父页面
+	confluenceGroup.GET("/pages/:id/ancestors", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+		ancestors, err := confluenceService.GetAncestors(ref, c.Query(SiteParam), pageID)
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ancestors": ancestors})
+	})
+
+	// 获取页面详情。format=markdown|text 返回转换后的正文；
+	// format 缺省或为 storage 时返回原始页面，body_format 可选，默认 storage
+	confluenceGroup.GET("/pages/:id", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		pageID, ok := pageIDParam(c)
+		if !ok {
+			return
+		}
+
+		switch format := c.DefaultQuery("format", FormatStorage); format {
+		case FormatStorage:
+		case FormatMarkdown, FormatText:
+			content, err := confluenceService.GetPageContent(ref, c.Query(SiteParam), pageID, format)
+			if err != nil {
+				c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(200, content)
+			return
+		default:
+			c.JSON(400, gin.H{"error": "format只支持markdown、text或storage"})
+			return
+		}
+
+		page, err := confluenceService.GetPage(ref, c.Query(SiteParam), pageID, c.DefaultQuery("body_format", "storage"))
+		if err != nil {
+			c.JSON(siteErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"page": page})
+	})
+}
+
+// pageIDParam 读取并校验路径中的页面ID，非法时直接返回 400
+func pageIDParam(c *gin.Context) (string, bool) {
+	pageID := c.Param("id")
+	if _, err := strconv.Atoi(pageID); err != nil {
+		c.JSON(400, gin.H{"error": "无效的页面ID"})
+		return "", false
+	}
+	return pageID, true
+}
+
+// siteErrorStatus 无权访问所选站点返回 403，其余错误返回 500
+func siteErrorStatus(err error) int {
+	if errors.Is(err, ErrSiteNotAccessible) {
+		return 403
+	}
+	return 500
+}
+
+// queryList 读取可重复、可逗号分隔的参数，如 space=ENG&space=OPS 或 space=ENG,OPS
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, v := range c.QueryArray(key) {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// queryLimit 读取 limit 参数，非法或缺省时使用默认值，最大 250（Confluence v2 API 上限）
+func queryLimit(c *gin.Context, def int) int {
+	limit := def
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit
 }
 
 // 自动注册到 routes 模块
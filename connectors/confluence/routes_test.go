@@ -0,0 +1,547 @@
+package confluence
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"connector-demo/auth"
+	"connector-demo/middleware"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newFakeConfluence 模拟 Confluence Cloud API 网关，响应 cloud-1、cloud-2 两个站点且要求正确的 Bearer token
+func newFakeConfluence(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cloud-1/wiki/rest/api/user/current", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"accountId":"acc-1","email":"alice@example.com","displayName":"Alice"}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/spaces", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cursor") == "spaces-2" {
+			w.Write([]byte(`{"results":[{"id":"101","key":"HR","name":"People"}],"_links":{}}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{"id":"100","key":"ENG","name":"Engineering"}],"_links":{"next":"/wiki/api/v2/spaces?cursor=spaces-2&limit=25"}}`))
+	})
+	mux.HandleFunc("/cloud-2/wiki/api/v2/spaces", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"id":"300","key":"OPS","name":"Operations"}],"_links":{}}`))
+	})
+	// 空间 100 的页面分两页返回：Runbook > Deploy > Rollback，Archive 为另一个顶层页面
+	mux.HandleFunc("/cloud-1/wiki/api/v2/spaces/100/pages", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cursor") == "pages-2" {
+			w.Write([]byte(`{"results":[
+				{"id":"203","title":"Rollback","spaceId":"100","parentId":"202","parentType":"page","position":0},
+				{"id":"202","title":"Deploy","spaceId":"100","parentId":"200","parentType":"page","position":0},
+				{"id":"204","title":"Archive","spaceId":"100","position":0}
+			],"_links":{}}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{"id":"200","title":"Runbook","spaceId":"100","position":1}],"_links":{"next":"/wiki/api/v2/spaces/100/pages?cursor=pages-2"}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages/200/children", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"id":"202","title":"Deploy","spaceId":"100","childPosition":0}],"_links":{}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages/203/ancestors", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"id":"200","type":"page"},{"id":"202","type":"page"}],"_links":{}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"id":"200","title":"Runbook","spaceId":"100"},{"id":"201","title":"Roadmap","spaceId":"101"}],"_links":{}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages/200", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("body-format") != "storage" {
+			http.Error(w, "unexpected body-format", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"id":"200","title":"Runbook","spaceId":"100","body":{"storage":{"value":"<p>hello <ac:link><ri:page ri:content-title=\"Deploy Guide\" /></ac:link></p>","representation":"storage"}}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/rest/api/search", func(w http.ResponseWriter, r *http.Request) {
+		cql := r.URL.Query().Get("cql")
+		if r.URL.Query().Get("cursor") == "search-2" {
+			w.Write([]byte(`{"results":[],"totalSize":2,"_links":{"base":"https://acme.atlassian.net/wiki"}}`))
+			return
+		}
+		if cql != `type in (page, blogpost) AND text ~ "deploy" AND space = "ENG" ORDER BY lastmodified DESC` && cql != "title = Runbook" {
+			http.Error(w, "unexpected cql: "+cql, http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"results":[{"content":{"id":"200","type":"page","title":"Runbook","space":{"key":"ENG","name":"Engineering"}},
+			"title":"@@@hl@@@Runbook@@@endhl@@@","excerpt":"How to @@@hl@@@deploy@@@endhl@@@ the API","url":"/spaces/ENG/pages/200/Runbook",
+			"entityType":"content","lastModified":"2024-05-01T10:00:00.000Z"}],
+			"totalSize":2,"_links":{"base":"https://acme.atlassian.net/wiki","next":"/rest/api/search?cql=x&cursor=search-2&limit=1"}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages/200/attachments", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"id":"att300","title":"报告 2024.pdf","mediaType":"application/pdf","fileSize":10,"pageId":"200"}],"_links":{}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/attachments/att300", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"att300","title":"报告 2024.pdf","mediaType":"application/pdf","fileSize":10,"pageId":"200"}`))
+	})
+	// 下载接口重定向到媒体服务，由媒体服务处理 Range
+	mux.HandleFunc("/cloud-1/wiki/rest/api/content/200/child/attachment/att300/download", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/cloud-1/media/att300", http.StatusFound)
+	})
+	// 上传者声明为 text/html 的附件，不能在我们的域名下直接打开
+	mux.HandleFunc("/cloud-1/wiki/api/v2/attachments/att301", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"att301","title":"page.html","mediaType":"text/html","fileSize":26,"pageId":"200"}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/rest/api/content/200/child/attachment/att301/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<script>alert(1)</script>`))
+	})
+	mux.HandleFunc("/cloud-1/media/att300", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), strings.NewReader("0123456789"))
+	})
+	// 评论：页脚评论 400 有一条回复 401，401 又有回复 402；行内评论 410 已解决。depth=all 一次返回全部层级，分两页
+	mux.HandleFunc("/cloud-1/wiki/rest/api/content/200/child/comment", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("depth") != "all" || !strings.Contains(q.Get("expand"), "ancestors") {
+			http.Error(w, "unexpected query "+r.URL.RawQuery, http.StatusBadRequest)
+			return
+		}
+		comments := []string{
+			`{"id":"402","status":"current","version":{"number":1,"when":"2024-05-02T01:00:00Z","by":{"accountId":"acc-1"}},"body":{"storage":{"value":"<p>谢谢</p>"}},"ancestors":[{"id":"400"},{"id":"401"}],"extensions":{"location":"footer"}}`,
+			`{"id":"400","status":"current","version":{"number":2,"when":"2024-05-01T00:00:00Z","by":{"accountId":"acc-1"}},"body":{"storage":{"value":"<p>请看 <strong>第二节</strong></p>"}},"ancestors":[],"extensions":{"location":"footer"}}`,
+			`{"id":"410","status":"current","version":{"number":1,"when":"2024-05-04T00:00:00Z","by":{"accountId":"acc-3"}},"body":{"storage":{"value":"<p>拼写错误</p>"}},"ancestors":[],"extensions":{"location":"inline","inlineProperties":{"originalSelection":"Confluense"},"resolution":{"status":"resolved"}}}`,
+			`{"id":"401","status":"current","version":{"number":1,"when":"2024-05-02T00:00:00Z","by":{"accountId":"acc-2"}},"body":{"storage":{"value":"<p>已修改</p>"}},"ancestors":[{"id":"400"}],"extensions":{"location":"footer"}}`,
+			`{"id":"403","status":"current","version":{"number":1,"when":"2024-05-03T00:00:00Z","by":{"accountId":"acc-2"}},"body":{"storage":{"value":"<p>第二页</p>"}},"ancestors":[],"extensions":{"location":"footer"}}`,
+		}
+		var matched []string
+		for _, c := range comments {
+			for _, loc := range q["location"] {
+				if strings.Contains(c, `"location":"`+loc+`"`) {
+					matched = append(matched, c)
+				}
+			}
+		}
+		start, _ := strconv.Atoi(q.Get("start"))
+		end := min(start+3, len(matched))
+		next := ""
+		if end < len(matched) {
+			next = fmt.Sprintf(`"next":"/rest/api/content/200/child/comment?start=%d"`, end)
+		}
+		fmt.Fprintf(w, `{"results":[%s],"_links":{%s}}`, strings.Join(matched[min(start, end):end], ","), next)
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/users-bulk", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			AccountIDs []string `json:"accountIds"`
+		}
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		known := map[string]string{"acc-1": "Alice", "acc-2": "Bob"}
+		var results []map[string]string
+		for _, id := range body.AccountIDs {
+			if name, ok := known[id]; ok {
+				results = append(results, map[string]string{"accountId": id, "displayName": name})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"results": results})
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages/200/labels", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[{"id":"l1","name":"runbook","prefix":"global"},{"id":"l2","name":"oncall","prefix":"global"}],"_links":{}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/pages/200/versions", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("cursor") == "v-2" {
+			w.Write([]byte(`{"results":[{"number":1,"message":"","minorEdit":false,"authorId":"acc-1","createdAt":"2024-04-01T00:00:00Z"}],"_links":{}}`))
+			return
+		}
+		w.Write([]byte(`{"results":[{"number":3,"message":"修正拼写","minorEdit":true,"authorId":"acc-2","createdAt":"2024-04-03T00:00:00Z"},{"number":2,"message":"补充步骤","minorEdit":false,"authorId":"acc-1","createdAt":"2024-04-02T00:00:00Z"}],"_links":{"next":"/wiki/api/v2/pages/200/versions?cursor=v-2"}}`))
+	})
+	mux.HandleFunc("/cloud-1/wiki/api/v2/spaces/100", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"100","key":"ENG","name":"Engineering"}`))
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer confluence-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	srv := newFakeConfluence(t)
+
+	tm := utils.NewTokenManager()
+	tm.SaveToken("u1", auth.ProviderConfluence, &utils.TokenInfo{
+		AccessToken: "confluence-access",
+		Metadata:    map[string]string{"cloud_id": "cloud-1"},
+		Sites: []utils.ConnectionSite{
+			{ID: "cloud-1", Name: "Acme", URL: "https://acme.atlassian.net"},
+			{ID: "cloud-2", Name: "Globex", URL: "https://globex.atlassian.net"},
+		},
+	})
+	connector := NewConfluenceConnector(tm)
+	connector.BaseURL = srv.URL + "/"
+	connector.HTTPClient = srv.Client()
+	SetConfluenceService(NewConfluenceServiceWithConnector(connector))
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if userID := c.GetHeader("X-Test-User"); userID != "" {
+			middleware.SetPrincipal(c, &middleware.Principal{Kind: middleware.PrincipalUser, UserID: userID})
+		}
+	})
+	RegisterRoutes(r.Group("/api"))
+	return r
+}
+
+func get(r *gin.Engine, path, userID string) *httptest.ResponseRecorder {
+	return do(r, http.MethodGet, path, userID)
+}
+
+func do(r *gin.Engine, method, path, userID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Test-User", userID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutes_SpacesAndPages(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := get(r, "/api/confluence/spaces", "u1")
+	var spaces struct {
+		Spaces []struct {
+			ID  string `json:"id"`
+			Key string `json:"key"`
+		} `json:"spaces"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &spaces)
+	if w.Code != http.StatusOK || len(spaces.Spaces) != 1 || spaces.Spaces[0].Key != "ENG" {
+		t.Fatalf("spaces: %d %s", w.Code, w.Body.String())
+	}
+
+	var pages struct {
+		Pages []struct {
+			ID string `json:"id"`
+		} `json:"pages"`
+	}
+	w = get(r, "/api/confluence/pages", "u1")
+	json.Unmarshal(w.Body.Bytes(), &pages)
+	if w.Code != http.StatusOK || len(pages.Pages) != 2 {
+		t.Fatalf("pages: %d %s", w.Code, w.Body.String())
+	}
+	w = get(r, "/api/confluence/pages?space_id=100", "u1")
+	json.Unmarshal(w.Body.Bytes(), &pages)
+	if w.Code != http.StatusOK || len(pages.Pages) != 1 || pages.Pages[0].ID != "200" {
+		t.Fatalf("pages in space: %d %s", w.Code, w.Body.String())
+	}
+
+	w = get(r, "/api/confluence/pages/200", "u1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `\u003cp\u003ehello`) {
+		t.Fatalf("page detail: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/confluence/pages/not-a-number", "u1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid page id: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRoutes_UserInfoAndTest(t *testing.T) {
+	r := newTestRouter(t)
+
+	w := get(r, "/api/confluence/user-info", "u1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "alice@example.com") {
+		t.Fatalf("user-info: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/confluence/test", "u1"); w.Code != http.StatusOK {
+		t.Fatalf("test: expected 200, got %d", w.Code)
+	}
+
+	// 没有 Confluence 连接的用户
+	if w := get(r, "/api/confluence/test", "u2"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("test without connection: expected 500, got %d", w.Code)
+	}
+	if w := get(r, "/api/confluence/spaces", "u2"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("spaces without connection: expected 500, got %d", w.Code)
+	}
+}
+
+func TestRoutes_SiteSelection(t *testing.T) {
+	r := newTestRouter(t)
+
+	var sites struct {
+		Sites []Site `json:"sites"`
+	}
+	w := get(r, "/api/confluence/sites", "u1")
+	json.Unmarshal(w.Body.Bytes(), &sites)
+	if w.Code != http.StatusOK || len(sites.Sites) != 2 || !sites.Sites[0].Default || sites.Sites[1].Default {
+		t.Fatalf("sites: %d %s", w.Code, w.Body.String())
+	}
+
+	// 按 cloud ID、站点名、站点域名选择站点
+	for _, site := range []string{"cloud-2", "Globex", "globex.atlassian.net"} {
+		w = get(r, "/api/confluence/spaces?site="+site, "u1")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "OPS") {
+			t.Fatalf("spaces on %s: %d %s", site, w.Code, w.Body.String())
+		}
+	}
+	if w := get(r, "/api/confluence/spaces?site=initech", "u1"); w.Code != http.StatusForbidden {
+		t.Fatalf("inaccessible site: expected 403, got %d", w.Code)
+	}
+
+	// 切换默认站点后，不带 site 的请求指向新站点
+	if w := do(r, http.MethodPut, "/api/confluence/sites/default?site=cloud-2", "u1"); w.Code != http.StatusOK {
+		t.Fatalf("set default site: %d %s", w.Code, w.Body.String())
+	}
+	w = get(r, "/api/confluence/spaces", "u1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "OPS") {
+		t.Fatalf("spaces on default site: %d %s", w.Code, w.Body.String())
+	}
+	if w := do(r, http.MethodPut, "/api/confluence/sites/default?site=initech", "u1"); w.Code != http.StatusForbidden {
+		t.Fatalf("set inaccessible default: expected 403, got %d", w.Code)
+	}
+	if w := do(r, http.MethodPut, "/api/confluence/sites/default", "u1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("set default without site: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRoutes_CursorPagination(t *testing.T) {
+	r := newTestRouter(t)
+
+	var spaces SpaceList
+	w := get(r, "/api/confluence/spaces", "u1")
+	json.Unmarshal(w.Body.Bytes(), &spaces)
+	if w.Code != http.StatusOK || spaces.NextCursor != "spaces-2" {
+		t.Fatalf("spaces first page: %d %s", w.Code, w.Body.String())
+	}
+	cursor := spaces.NextCursor
+	spaces = SpaceList{}
+	w = get(r, "/api/confluence/spaces?cursor="+cursor, "u1")
+	json.Unmarshal(w.Body.Bytes(), &spaces)
+	if w.Code != http.StatusOK || len(spaces.Spaces) != 1 || spaces.Spaces[0].Key != "HR" || spaces.NextCursor != "" {
+		t.Fatalf("spaces second page: %d %s", w.Code, w.Body.String())
+	}
+
+	var pages PageList
+	w = get(r, "/api/confluence/pages?space_id=100&cursor=pages-2", "u1")
+	json.Unmarshal(w.Body.Bytes(), &pages)
+	if w.Code != http.StatusOK || len(pages.Pages) != 3 || pages.NextCursor != "" {
+		t.Fatalf("pages second page: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/confluence/pages?space_id=abc", "u1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid space id: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRoutes_PageTree(t *testing.T) {
+	r := newTestRouter(t)
+
+	var body struct {
+		Tree PageTree `json:"tree"`
+	}
+	w := get(r, "/api/confluence/spaces/100/tree", "u1")
+	json.Unmarshal(w.Body.Bytes(), &body)
+	tree := body.Tree
+	if w.Code != http.StatusOK || tree.Total != 4 || len(tree.Roots) != 2 {
+		t.Fatalf("tree: %d %s", w.Code, w.Body.String())
+	}
+	// 顶层按 position 排序：Archive(0) 在 Runbook(1) 之前
+	if tree.Roots[0].ID != "204" || tree.Roots[1].ID != "200" {
+		t.Fatalf("unexpected roots order: %s", w.Body.String())
+	}
+	runbook := tree.Roots[1]
+	if len(runbook.Children) != 1 || runbook.Children[0].ID != "202" {
+		t.Fatalf("unexpected children: %s", w.Body.String())
+	}
+	rollback := runbook.Children[0].Children[0]
+	if rollback.ID != "203" || rollback.ParentID != "202" || strings.Join(rollback.Ancestors, ",") != "200,202" {
+		t.Fatalf("unexpected leaf: %+v", rollback)
+	}
+
+	w = get(r, "/api/confluence/pages/200/children", "u1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"202"`) {
+		t.Fatalf("children: %d %s", w.Code, w.Body.String())
+	}
+	var ancestors struct {
+		Ancestors []Ancestor `json:"ancestors"`
+	}
+	w = get(r, "/api/confluence/pages/203/ancestors", "u1")
+	json.Unmarshal(w.Body.Bytes(), &ancestors)
+	if w.Code != http.StatusOK || len(ancestors.Ancestors) != 2 || ancestors.Ancestors[1].ID != "202" {
+		t.Fatalf("ancestors: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRoutes_PageFormats(t *testing.T) {
+	r := newTestRouter(t)
+
+	var content PageContent
+	w := get(r, "/api/confluence/pages/200?format=markdown", "u1")
+	json.Unmarshal(w.Body.Bytes(), &content)
+	want := "hello [Deploy Guide](https://acme.atlassian.net/wiki/display/ENG/Deploy+Guide)"
+	if w.Code != http.StatusOK || content.Format != FormatMarkdown || content.Content != want || content.Page.Body != nil {
+		t.Fatalf("markdown: %d %s", w.Code, w.Body.String())
+	}
+
+	content = PageContent{}
+	w = get(r, "/api/confluence/pages/200?format=text", "u1")
+	json.Unmarshal(w.Body.Bytes(), &content)
+	if w.Code != http.StatusOK || content.Content != "hello Deploy Guide" {
+		t.Fatalf("text: %d %s", w.Code, w.Body.String())
+	}
+
+	if w := get(r, "/api/confluence/pages/200?format=storage", "u1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `\u003cp\u003ehello`) {
+		t.Fatalf("storage: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/confluence/pages/200?format=html", "u1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("unsupported format: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRoutes_Search(t *testing.T) {
+	r := newTestRouter(t)
+
+	var results SearchResults
+	w := get(r, "/api/confluence/search?text=deploy&space=ENG&limit=1", "u1")
+	json.Unmarshal(w.Body.Bytes(), &results)
+	if w.Code != http.StatusOK || len(results.Results) != 1 || results.NextCursor != "search-2" || results.TotalSize != 2 {
+		t.Fatalf("search: %d %s", w.Code, w.Body.String())
+	}
+	got := results.Results[0]
+	if got.ID != "200" || got.Type != "page" || got.SpaceKey != "ENG" || got.Excerpt != "How to deploy the API" ||
+		got.URL != "https://acme.atlassian.net/wiki/spaces/ENG/pages/200/Runbook" || got.LastModified == "" {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+
+	if w := get(r, "/api/confluence/search?cql=title+%3D+Runbook", "u1"); w.Code != http.StatusOK {
+		t.Fatalf("raw cql: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/confluence/search?cql=title+%3D+Runbook&cursor=search-2", "u1"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"results":[]`) {
+		t.Fatalf("second page: %d %s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{
+		"/api/confluence/search",
+		"/api/confluence/search?cql=type%3Dpage&text=deploy",
+		"/api/confluence/search?modified_after=yesterday",
+	} {
+		if w := get(r, path, "u1"); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestRoutes_Attachments(t *testing.T) {
+	r := newTestRouter(t)
+
+	var list AttachmentList
+	w := get(r, "/api/confluence/pages/200/attachments", "u1")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list.Attachments) != 1 || list.Attachments[0].ID != "att300" {
+		t.Fatalf("attachments: %d %s", w.Code, w.Body.String())
+	}
+
+	w = get(r, "/api/confluence/attachments/att300/download", "u1")
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Fatalf("download: %d %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "attachment; filename*=utf-8''%E6%8A%A5%E5%91%8A%202024.pdf" {
+		t.Fatalf("unexpected Content-Disposition: %s", cd)
+	}
+
+	// Range 请求原样转发，返回 206 和 Content-Range
+	req := httptest.NewRequest(http.MethodGet, "/api/confluence/attachments/att300/download?inline=true", nil)
+	req.Header.Set("X-Test-User", "u1")
+	req.Header.Set("Range", "bytes=2-5")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "2345" || w.Header().Get("Content-Range") != "bytes 2-5/10" {
+		t.Fatalf("range: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "inline;") || w.Header().Get("Content-Length") != "4" {
+		t.Fatalf("unexpected headers: %v", w.Header())
+	}
+
+	// 非白名单类型即使要求 inline 也强制下载
+	w = get(r, "/api/confluence/attachments/att301/download?inline=true", "u1")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" ||
+		!strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") || w.Header().Get("Content-Security-Policy") != "sandbox" {
+		t.Fatalf("html attachment must be forced to download: %d %v", w.Code, w.Header())
+	}
+
+	req.Header.Set("Range", "bytes=50-60")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unsatisfiable range: expected 416, got %d", w.Code)
+	}
+	if w := get(r, "/api/confluence/attachments/abc/download", "u1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid attachment id: expected 400, got %d", w.Code)
+	}
+}
+
+func TestRoutes_CommentsLabelsVersions(t *testing.T) {
+	r := newTestRouter(t)
+
+	var comments PageComments
+	w := get(r, "/api/confluence/pages/200/comments?format=markdown", "u1")
+	json.Unmarshal(w.Body.Bytes(), &comments)
+	if w.Code != http.StatusOK || len(comments.Footer) != 2 || len(comments.Inline) != 1 {
+		t.Fatalf("comments: %d %s", w.Code, w.Body.String())
+	}
+	root := comments.Footer[0]
+	if root.ID != "400" || root.AuthorName != "Alice" || root.Body != "请看 **第二节**" || root.Version != 2 {
+		t.Fatalf("unexpected root comment: %+v", root)
+	}
+	if len(root.Replies) != 1 || root.Replies[0].AuthorName != "Bob" || len(root.Replies[0].Replies) != 1 || root.Replies[0].Replies[0].ID != "402" {
+		t.Fatalf("unexpected replies: %s", w.Body.String())
+	}
+	// 无法解析的作者保留账号ID
+	inline := comments.Inline[0]
+	if inline.AuthorID != "acc-3" || inline.AuthorName != "" || inline.ResolutionStatus != "resolved" || inline.Selection != "Confluense" {
+		t.Fatalf("unexpected inline comment: %+v", inline)
+	}
+
+	comments = PageComments{}
+	w = get(r, "/api/confluence/pages/200/comments?type=inline", "u1")
+	json.Unmarshal(w.Body.Bytes(), &comments)
+	if w.Code != http.StatusOK || len(comments.Footer) != 0 || len(comments.Inline) != 1 || comments.Inline[0].Body != "<p>拼写错误</p>" {
+		t.Fatalf("inline comments: %d %s", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/confluence/pages/200/comments?type=page", "u1"); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid type: expected 400, got %d", w.Code)
+	}
+
+	var labels struct {
+		Labels []Label `json:"labels"`
+	}
+	w = get(r, "/api/confluence/pages/200/labels", "u1")
+	json.Unmarshal(w.Body.Bytes(), &labels)
+	if w.Code != http.StatusOK || len(labels.Labels) != 2 || labels.Labels[0].Name != "runbook" {
+		t.Fatalf("labels: %d %s", w.Code, w.Body.String())
+	}
+
+	var versions VersionList
+	w = get(r, "/api/confluence/pages/200/versions?limit=2", "u1")
+	json.Unmarshal(w.Body.Bytes(), &versions)
+	if w.Code != http.StatusOK || len(versions.Versions) != 2 || versions.NextCursor != "v-2" {
+		t.Fatalf("versions: %d %s", w.Code, w.Body.String())
+	}
+	if v := versions.Versions[0]; v.Number != 3 || v.AuthorName != "Bob" || !v.MinorEdit || v.Message != "修正拼写" {
+		t.Fatalf("unexpected version: %+v", v)
+	}
+	versions = VersionList{}
+	w = get(r, "/api/confluence/pages/200/versions?cursor=v-2", "u1")
+	json.Unmarshal(w.Body.Bytes(), &versions)
+	if len(versions.Versions) != 1 || versions.Versions[0].AuthorName != "Alice" || versions.NextCursor != "" {
+		t.Fatalf("versions page 2: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,180 @@
+package confluence
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SearchFilter 简化的搜索条件，转换为 CQL 后调用搜索接口
+type SearchFilter struct {
+	// Text 全文搜索关键字
+	Text string
+	// Spaces 空间 key，多个时匹配任意一个
+	Spaces []string
+	// Labels 标签，多个时匹配任意一个
+	Labels []string
+	// Type 内容类型：page、blogpost、attachment、comment，为空时搜索页面和博客
+	Type string
+	// ModifiedAfter 最后修改时间下限，绝对日期（2024-05-01）或相对时间（7d 天、2w 周、3m 月、1y 年）
+	ModifiedAfter string
+}
+
+// SearchResult 一条搜索结果
+type SearchResult struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	Excerpt      string `json:"excerpt,omitempty"`
+	SpaceKey     string `json:"space_key,omitempty"`
+	SpaceName    string `json:"space_name,omitempty"`
+	URL          string `json:"url,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// SearchResults 一页搜索结果，NextCursor 为空表示没有更多
+type SearchResults struct {
+	CQL        string         `json:"cql"`
+	Results    []SearchResult `json:"results"`
+	TotalSize  int            `json:"total_size"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// searchResponse wiki/rest/api/search 的响应
+type searchResponse struct {
+	Results []struct {
+		Content *struct {
+			ID    string `json:"id"`
+			Type  string `json:"type"`
+			Title string `json:"title"`
+			Space *struct {
+				Key  string `json:"key"`
+				Name string `json:"name"`
+			} `json:"space"`
+		} `json:"content"`
+		Title                 string `json:"title"`
+		Excerpt               string `json:"excerpt"`
+		URL                   string `json:"url"`
+		EntityType            string `json:"entityType"`
+		LastModified          string `json:"lastModified"`
+		ResultGlobalContainer *struct {
+			Title      string `json:"title"`
+			DisplayURL string `json:"displayUrl"`
+		} `json:"resultGlobalContainer"`
+	} `json:"results"`
+	TotalSize int `json:"totalSize"`
+	Links     struct {
+		Base string `json:"base"`
+		Next string `json:"next"`
+	} `json:"_links"`
+}
+
+var (
+	searchTypes      = map[string]bool{"page": true, "blogpost": true, "attachment": true, "comment": true}
+	absoluteDate     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	relativeDuration = regexp.MustCompile(`^(\d+)([dwmy])$`)
+	spaceKeyPattern  = regexp.MustCompile(`^~?[A-Za-z0-9_-]+$`)
+	highlightMarkers = strings.NewReplacer("@@@hl@@@", "", "@@@endhl@@@", "")
+	// cqlUnits 相对时间单位到 CQL 单位的映射，CQL 中 m 是分钟，月份为 M
+	cqlUnits = map[string]string{"d": "d", "w": "w", "m": "M", "y": "y"}
+)
+
+// IsEmpty 没有任何搜索条件
+func (f SearchFilter) IsEmpty() bool {
+	return f.Text == "" && len(f.Spaces) == 0 && len(f.Labels) == 0 && f.Type == "" && f.ModifiedAfter == ""
+}
+
+// CQL 把简化条件转换为 CQL，按最后修改时间倒序
+func (f SearchFilter) CQL() (string, error) {
+	var clauses []string
+
+	if f.Type != "" {
+		if !searchTypes[f.Type] {
+			return "", fmt.Errorf("不支持的内容类型: %s", f.Type)
+		}
+		clauses = append(clauses, "type = "+f.Type)
+	} else {
+		clauses = append(clauses, "type in (page, blogpost)")
+	}
+	if text := strings.TrimSpace(f.Text); text != "" {
+		clauses = append(clauses, "text ~ "+cqlString(text))
+	}
+	if len(f.Spaces) > 0 {
+		for _, key := range f.Spaces {
+			if !spaceKeyPattern.MatchString(key) {
+				return "", fmt.Errorf("无效的空间key: %s", key)
+			}
+		}
+		clauses = append(clauses, cqlIn("space", f.Spaces))
+	}
+	if len(f.Labels) > 0 {
+		clauses = append(clauses, cqlIn("label", f.Labels))
+	}
+	if f.ModifiedAfter != "" {
+		switch {
+		case absoluteDate.MatchString(f.ModifiedAfter):
+			clauses = append(clauses, "lastmodified >= "+cqlString(f.ModifiedAfter))
+		case relativeDuration.MatchString(f.ModifiedAfter):
+			m := relativeDuration.FindStringSubmatch(f.ModifiedAfter)
+			clauses = append(clauses, "lastmodified >= now("+cqlString("-"+m[1]+cqlUnits[m[2]])+")")
+		default:
+			return "", fmt.Errorf("无效的修改时间: %s（支持 2024-05-01 或 7d、2w、3m、1y）", f.ModifiedAfter)
+		}
+	}
+	return strings.Join(clauses, " AND ") + " ORDER BY lastmodified DESC", nil
+}
+
+// cqlString 生成带转义的 CQL 字符串字面量
+func cqlString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+func cqlIn(field string, values []string) string {
+	if len(values) == 1 {
+		return field + " = " + cqlString(values[0])
+	}
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = cqlString(v)
+	}
+	return field + " in (" + strings.Join(quoted, ", ") + ")"
+}
+
+// toSearchResults 把接口响应转换为搜索结果，链接补全为站点绝对地址
+func toSearchResults(cql string, resp *searchResponse) *SearchResults {
+	results := &SearchResults{
+		CQL:        cql,
+		Results:    []SearchResult{},
+		TotalSize:  resp.TotalSize,
+		NextCursor: nextCursor(resp.Links.Next),
+	}
+	for _, r := range resp.Results {
+		result := SearchResult{
+			Type:         r.EntityType,
+			Title:        highlightMarkers.Replace(r.Title),
+			Excerpt:      strings.TrimSpace(highlightMarkers.Replace(r.Excerpt)),
+			LastModified: r.LastModified,
+		}
+		if r.URL != "" {
+			result.URL = resp.Links.Base + r.URL
+		}
+		if c := r.Content; c != nil {
+			result.ID = c.ID
+			result.Type = c.Type
+			if c.Title != "" {
+				result.Title = c.Title
+			}
+			if c.Space != nil {
+				result.SpaceKey = c.Space.Key
+				result.SpaceName = c.Space.Name
+			}
+		}
+		if result.SpaceName == "" && r.ResultGlobalContainer != nil {
+			result.SpaceName = r.ResultGlobalContainer.Title
+		}
+		results.Results = append(results.Results, result)
+	}
+	return results
+}
@@ -0,0 +1,63 @@
+package confluence
+
+import "testing"
+
+func TestSearchFilter_CQL(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  SearchFilter
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "text only",
+			filter: SearchFilter{Text: "deploy guide"},
+			want:   `type in (page, blogpost) AND text ~ "deploy guide" ORDER BY lastmodified DESC`,
+		},
+		{
+			name:   "escape quotes",
+			filter: SearchFilter{Text: `say "hi" \o/`},
+			want:   `type in (page, blogpost) AND text ~ "say \"hi\" \\o/" ORDER BY lastmodified DESC`,
+		},
+		{
+			name:   "spaces labels type",
+			filter: SearchFilter{Spaces: []string{"ENG", "~alice"}, Labels: []string{"runbook"}, Type: "page"},
+			want:   `type = page AND space in ("ENG", "~alice") AND label = "runbook" ORDER BY lastmodified DESC`,
+		},
+		{
+			name:   "absolute date",
+			filter: SearchFilter{ModifiedAfter: "2024-05-01"},
+			want:   `type in (page, blogpost) AND lastmodified >= "2024-05-01" ORDER BY lastmodified DESC`,
+		},
+		{
+			name:   "relative date",
+			filter: SearchFilter{Text: "oncall", ModifiedAfter: "2w"},
+			want:   `type in (page, blogpost) AND text ~ "oncall" AND lastmodified >= now("-2w") ORDER BY lastmodified DESC`,
+		},
+		{
+			name:   "relative months",
+			filter: SearchFilter{ModifiedAfter: "3m"},
+			want:   `type in (page, blogpost) AND lastmodified >= now("-3M") ORDER BY lastmodified DESC`,
+		},
+		{
+			name:   "relative days and years",
+			filter: SearchFilter{Spaces: []string{"ENG"}, ModifiedAfter: "1y"},
+			want:   `type in (page, blogpost) AND space = "ENG" AND lastmodified >= now("-1y") ORDER BY lastmodified DESC`,
+		},
+		{name: "bad type", filter: SearchFilter{Type: "user"}, wantErr: true},
+		{name: "bad space key", filter: SearchFilter{Spaces: []string{`ENG" OR space != "X`}}, wantErr: true},
+		{name: "bad date", filter: SearchFilter{ModifiedAfter: "last week"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.CQL()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
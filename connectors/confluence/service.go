@@ -2,14 +2,129 @@ package confluence
 
 import (
 	"connector-demo/utils"
+	"log"
+
+	"github.com/ctreminiom/go-atlassian/v2/pkg/infra/models"
 )
 
-// ConfluenceService 负责封装业务逻辑，调用 SlackConnector
+// ConfluenceService 负责封装业务逻辑，调用 ConfluenceConnector
 type ConfluenceService struct {
 	connector *ConfluenceConnector
+	syncer    *Syncer
 }
 
 func NewConfluenceService(tokenManager *utils.TokenManager) *ConfluenceService {
-	slackConnector := NewConfluenceConnector(tokenManager)
-	return &ConfluenceService{connector: slackConnector}
+	return NewConfluenceServiceWithConnector(NewConfluenceConnector(tokenManager))
+}
+
+// NewConfluenceServiceWithConnector 使用指定的连接器创建服务
+func NewConfluenceServiceWithConnector(connector *ConfluenceConnector) *ConfluenceService {
+	return &ConfluenceService{connector: connector, syncer: NewSyncer(connector, NewMemorySyncStateStore())}
+}
+
+// SetSyncStateStore 使用指定的同步进度存储（默认内存存储）
+func (s *ConfluenceService) SetSyncStateStore(store SyncStateStore) {
+	s.syncer = NewSyncer(s.connector, store)
+}
+
+// 获取连接可访问的站点
+func (s *ConfluenceService) ListSites(ref utils.ConnectionRef) ([]Site, error) {
+	return s.connector.ListSites(ref)
+}
+
+// 设置默认站点
+func (s *ConfluenceService) SetDefaultSite(ref utils.ConnectionRef, site string) (*Site, error) {
+	return s.connector.SetDefaultSite(ref, site)
+}
+
+// 获取用户信息
+func (s *ConfluenceService) GetUserInfo(ref utils.ConnectionRef, site string) (*ConfluenceUser, error) {
+	return s.connector.GetUserInfo(ref, site)
+}
+
+// 获取空间列表
+func (s *ConfluenceService) ListSpaces(ref utils.ConnectionRef, site, cursor string, limit int) (*SpaceList, error) {
+	return s.connector.ListSpaces(ref, site, cursor, limit)
+}
+
+// 获取页面列表
+func (s *ConfluenceService) ListPages(ref utils.ConnectionRef, site, spaceID, cursor string, limit int) (*PageList, error) {
+	return s.connector.ListPages(ref, site, spaceID, cursor, limit)
+}
+
+// 获取子页面
+func (s *ConfluenceService) ListChildren(ref utils.ConnectionRef, site, pageID, cursor string, limit int) (*ChildPageList, error) {
+	return s.connector.ListChildren(ref, site, pageID, cursor, limit)
+}
+
+// 获取页面祖先
+func (s *ConfluenceService) GetAncestors(ref utils.ConnectionRef, site, pageID string) ([]*Ancestor, error) {
+	return s.connector.GetAncestors(ref, site, pageID)
+}
+
+// 获取空间页面树
+func (s *ConfluenceService) GetPageTree(ref utils.ConnectionRef, site, spaceID string) (*PageTree, error) {
+	return s.connector.GetPageTree(ref, site, spaceID)
+}
+
+// 获取页面详情
+func (s *ConfluenceService) GetPage(ref utils.ConnectionRef, site, pageID, format string) (*models.PageScheme, error) {
+	return s.connector.GetPage(ref, site, pageID, format)
+}
+
+// 获取转换为 Markdown 或纯文本的页面正文
+func (s *ConfluenceService) GetPageContent(ref utils.ConnectionRef, site, pageID, format string) (*PageContent, error) {
+	return s.connector.GetPageContent(ref, site, pageID, format)
+}
+
+// 搜索内容，cql 为空时由 filter 生成
+func (s *ConfluenceService) Search(ref utils.ConnectionRef, site, cql string, filter SearchFilter, cursor string, limit int) (*SearchResults, error) {
+	if cql == "" {
+		var err error
+		if cql, err = filter.CQL(); err != nil {
+			return nil, err
+		}
+	}
+	return s.connector.Search(ref, site, cql, cursor, limit)
+}
+
+// 获取页面附件
+func (s *ConfluenceService) ListAttachments(ref utils.ConnectionRef, site, pageID, cursor string, limit int) (*AttachmentList, error) {
+	return s.connector.ListAttachments(ref, site, pageID, cursor, limit)
+}
+
+// 下载附件，调用方负责关闭返回的 Body
+func (s *ConfluenceService) DownloadAttachment(ref utils.ConnectionRef, site, attachmentID, rangeHeader string) (*AttachmentDownload, error) {
+	return s.connector.DownloadAttachment(ref, site, attachmentID, rangeHeader)
+}
+
+// 获取页面评论（按回复组装）
+func (s *ConfluenceService) GetComments(ref utils.ConnectionRef, site, pageID, kind, format string) (*PageComments, error) {
+	return s.connector.GetComments(ref, site, pageID, kind, format)
+}
+
+// 获取页面标签
+func (s *ConfluenceService) GetLabels(ref utils.ConnectionRef, site, pageID string) ([]Label, error) {
+	return s.connector.GetLabels(ref, site, pageID)
+}
+
+// 获取版本历史
+func (s *ConfluenceService) ListVersions(ref utils.ConnectionRef, site, pageID, cursor string, limit int) (*VersionList, error) {
+	return s.connector.ListVersions(ref, site, pageID, cursor, limit)
+}
+
+// 增量同步空间页面，返回变更事件
+func (s *ConfluenceService) SyncSpace(ref utils.ConnectionRef, site, spaceID string, full bool) (*SyncResult, error) {
+	return s.syncer.SyncSpace(ref, site, spaceID, full)
+}
+
+// 测试连接，返回bool
+func (s *ConfluenceService) TestConnection(ref utils.ConnectionRef, site string) bool {
+	_, err := s.connector.GetUserInfo(ref, site)
+	if err != nil {
+		log.Printf("Confluence连接测试失败: %v", err)
+		return false
+	}
+	log.Printf("Confluence连接测试成功: %s", ref.UserID)
+	return true
 }
@@ -0,0 +1,333 @@
+package confluence
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"connector-demo/utils"
+
+	"github.com/ctreminiom/go-atlassian/v2/pkg/infra/models"
+)
+
+// ChangeType 页面变更类型
+type ChangeType string
+
+const (
+	ChangeCreated ChangeType = "created"
+	ChangeUpdated ChangeType = "updated"
+	ChangeDeleted ChangeType = "deleted"
+)
+
+// ChangeEvent 一次页面变更
+type ChangeEvent struct {
+	Type    ChangeType `json:"type"`
+	PageID  string     `json:"page_id"`
+	SpaceID string     `json:"space_id"`
+	Title   string     `json:"title,omitempty"`
+	// Version 变更后的版本号，删除事件为删除前的最后版本
+	Version      int       `json:"version"`
+	Status       string    `json:"status"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// PageState 已同步页面的版本和状态
+type PageState struct {
+	Version int    `json:"version"`
+	Status  string `json:"status"`
+}
+
+// SyncState 一个空间的同步进度
+type SyncState struct {
+	SpaceID string `json:"space_id"`
+	// LastModified 高水位：已同步页面中最大的最后修改时间，下次只拉取不早于它的页面
+	LastModified time.Time `json:"last_modified"`
+	// Pages 镜像中存在的页面，pageID -> 状态；用于区分新建和更新、检测删除
+	Pages      map[string]PageState `json:"pages"`
+	LastSyncAt time.Time            `json:"last_sync_at"`
+}
+
+// SyncResult 一次同步的结果，Events 按最后修改时间升序，删除事件在最后
+type SyncResult struct {
+	SpaceID      string        `json:"space_id"`
+	Full         bool          `json:"full"`
+	Events       []ChangeEvent `json:"events"`
+	LastModified time.Time     `json:"last_modified"`
+	Pages        int           `json:"pages"`
+}
+
+// SyncStateStore 同步进度存储
+type SyncStateStore interface {
+	// Get 获取同步进度，没有记录时返回 nil, nil
+	Get(key string) (*SyncState, error)
+	// Save 保存同步进度
+	Save(key string, state *SyncState) error
+}
+
+// MemorySyncStateStore 内存同步进度存储，进程重启后下一次同步为全量同步
+type MemorySyncStateStore struct {
+	states map[string]*SyncState
+	mu     sync.RWMutex
+}
+
+func NewMemorySyncStateStore() *MemorySyncStateStore {
+	return &MemorySyncStateStore{states: make(map[string]*SyncState)}
+}
+
+func (s *MemorySyncStateStore) Get(key string) (*SyncState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil, nil
+	}
+	return state.clone(), nil
+}
+
+func (s *MemorySyncStateStore) Save(key string, state *SyncState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = state.clone()
+	return nil
+}
+
+// BoltSyncStateStore 持久化的同步进度存储，进程重启后继续增量同步
+type BoltSyncStateStore struct {
+	bucket *utils.BoltStateBucket
+}
+
+func NewBoltSyncStateStore(bucket *utils.BoltStateBucket) *BoltSyncStateStore {
+	return &BoltSyncStateStore{bucket: bucket}
+}
+
+func (s *BoltSyncStateStore) Get(key string) (*SyncState, error) {
+	state := &SyncState{}
+	found, err := s.bucket.Get(key, state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *BoltSyncStateStore) Save(key string, state *SyncState) error {
+	return s.bucket.Put(key, state)
+}
+
+func (s *SyncState) clone() *SyncState {
+	c := *s
+	c.Pages = make(map[string]PageState, len(s.Pages))
+	for id, p := range s.Pages {
+		c.Pages[id] = p
+	}
+	return &c
+}
+
+// 页面状态：current、archived 为镜像中存在的页面，trashed、deleted 视为删除
+var (
+	liveStatuses    = []string{"current", "archived"}
+	removedStatuses = []string{"trashed", "deleted"}
+)
+
+// Syncer 按最后修改时间增量同步空间页面，生成变更事件
+type Syncer struct {
+	connector *ConfluenceConnector
+	store     SyncStateStore
+	// pageSize 每次请求的页面数
+	pageSize int
+	locks    sync.Map // 同步进度键 -> *sync.Mutex，同一空间的同步串行执行
+}
+
+func NewSyncer(connector *ConfluenceConnector, store SyncStateStore) *Syncer {
+	return &Syncer{connector: connector, store: store, pageSize: maxPageLimit}
+}
+
+// SyncSpace 同步空间页面。没有同步记录或 full=true 时全量同步，全量同步还会检测已被彻底清除的页面
+func (s *Syncer) SyncSpace(ref utils.ConnectionRef, site, spaceID string, full bool) (*SyncResult, error) {
+	if _, err := strconv.Atoi(spaceID); err != nil {
+		return nil, fmt.Errorf("无效的空间ID: %s", spaceID)
+	}
+	token, selected, err := s.connector.getSiteToken(ref, site)
+	if err != nil {
+		return nil, err
+	}
+
+	key := strings.Join([]string{ref.UserID, token.ConnectionID, selected.ID, spaceID}, "/")
+	lock, _ := s.locks.LoadOrStore(key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	state, err := s.store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("读取同步进度失败: %v", err)
+	}
+	if state == nil {
+		state = &SyncState{SpaceID: spaceID, Pages: make(map[string]PageState)}
+		full = true
+	}
+
+	fetcher := &pageFetcher{connector: s.connector, token: token, site: selected, spaceID: spaceID, pageSize: s.pageSize}
+	result := &SyncResult{SpaceID: spaceID, Full: full, Events: []ChangeEvent{}}
+
+	// 1. 按最后修改时间倒序拉取存在的页面，增量同步遇到早于高水位的页面即停止
+	var since time.Time
+	if !full {
+		since = state.LastModified
+	}
+	live, err := fetcher.fetch(liveStatuses, since)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(live))
+	highWater := state.LastModified
+	for i := len(live) - 1; i >= 0; i-- {
+		page := live[i]
+		seen[page.ID] = true
+		modified := pageModified(page)
+		if modified.After(highWater) {
+			highWater = modified
+		}
+
+		current := PageState{Version: pageVersion(page), Status: page.Status}
+		previous, known := state.Pages[page.ID]
+		state.Pages[page.ID] = current
+		switch {
+		case !known:
+			result.Events = append(result.Events, changeEvent(ChangeCreated, spaceID, page, current))
+		case current != previous:
+			result.Events = append(result.Events, changeEvent(ChangeUpdated, spaceID, page, current))
+		}
+	}
+
+	// 2. 移入回收站或删除不一定更新最后修改时间，每次都检查回收站中的页面
+	removed, err := fetcher.fetch(removedStatuses, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	for _, page := range removed {
+		previous, known := state.Pages[page.ID]
+		if !known || seen[page.ID] {
+			continue
+		}
+		delete(state.Pages, page.ID)
+		event := changeEvent(ChangeDeleted, spaceID, page, PageState{Version: previous.Version, Status: page.Status})
+		if v := pageVersion(page); v > 0 {
+			event.Version = v
+		}
+		result.Events = append(result.Events, event)
+	}
+
+	// 3. 全量同步时，镜像中有但空间里已经找不到的页面已被彻底清除
+	if full {
+		var purged []string
+		for id := range state.Pages {
+			if !seen[id] {
+				purged = append(purged, id)
+			}
+		}
+		sort.Strings(purged)
+		for _, id := range purged {
+			previous := state.Pages[id]
+			delete(state.Pages, id)
+			result.Events = append(result.Events, ChangeEvent{Type: ChangeDeleted, PageID: id, SpaceID: spaceID, Version: previous.Version, Status: "purged"})
+		}
+	}
+
+	state.LastModified = highWater
+	state.LastSyncAt = time.Now()
+	if err := s.store.Save(key, state); err != nil {
+		return nil, fmt.Errorf("保存同步进度失败: %v", err)
+	}
+	result.LastModified = highWater
+	result.Pages = len(state.Pages)
+	return result, nil
+}
+
+// pageFetcher 直接调用 v2 接口：go-atlassian 的 GetsBySpace 不支持 sort 和 status 参数
+type pageFetcher struct {
+	connector *ConfluenceConnector
+	token     *utils.TokenInfo
+	site      *utils.ConnectionSite
+	spaceID   string
+	pageSize  int
+}
+
+// fetch 按最后修改时间倒序拉取指定状态的页面，since 非零时遇到早于它的页面即停止
+func (f *pageFetcher) fetch(statuses []string, since time.Time) ([]*models.PageScheme, error) {
+	client, err := f.connector.newClient(f.token, f.site)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []*models.PageScheme
+	cursor := ""
+	for {
+		query := url.Values{}
+		query.Set("sort", "-modified-date")
+		query.Set("limit", strconv.Itoa(f.pageSize))
+		for _, status := range statuses {
+			query.Add("status", status)
+		}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		endpoint := fmt.Sprintf("wiki/api/v2/spaces/%s/pages?%s", f.spaceID, query.Encode())
+		req, err := client.NewRequest(context.Background(), http.MethodGet, endpoint, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		chunk := new(models.PageChunkScheme)
+		if _, err := client.Call(req, chunk); err != nil {
+			return nil, fmt.Errorf("获取空间页面失败: %v", err)
+		}
+
+		for _, page := range chunk.Results {
+			if !since.IsZero() && pageModified(page).Before(since) {
+				return pages, nil
+			}
+			pages = append(pages, page)
+		}
+
+		next := ""
+		if chunk.Links != nil {
+			next = nextCursor(chunk.Links.Next)
+		}
+		if next == "" || next == cursor {
+			return pages, nil
+		}
+		cursor = next
+	}
+}
+
+// pageModified 页面最后修改时间，即当前版本的创建时间
+func pageModified(page *models.PageScheme) time.Time {
+	if page.Version == nil {
+		return time.Time{}
+	}
+	t, _ := time.Parse(time.RFC3339Nano, page.Version.CreatedAt)
+	return t
+}
+
+func pageVersion(page *models.PageScheme) int {
+	if page.Version == nil {
+		return 0
+	}
+	return page.Version.Number
+}
+
+func changeEvent(t ChangeType, spaceID string, page *models.PageScheme, state PageState) ChangeEvent {
+	return ChangeEvent{
+		Type:         t,
+		PageID:       page.ID,
+		SpaceID:      spaceID,
+		Title:        page.Title,
+		Version:      state.Version,
+		Status:       state.Status,
+		LastModified: pageModified(page),
+	}
+}
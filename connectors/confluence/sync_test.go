@@ -0,0 +1,168 @@
+package confluence
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"connector-demo/auth"
+	"connector-demo/utils"
+)
+
+type fakePage struct {
+	ID       string
+	Title    string
+	Status   string
+	Version  int
+	Modified time.Time
+}
+
+// fakeSpace 模拟空间 500 的页面接口：按状态过滤、按最后修改时间倒序、用游标分页
+type fakeSpace struct {
+	mu     sync.Mutex
+	pages  map[string]*fakePage
+	served int // 返回过的页面数，用于确认增量同步提前停止
+}
+
+func (s *fakeSpace) put(p fakePage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pages[p.ID] = &p
+}
+
+func (s *fakeSpace) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := r.URL.Query()
+	if q.Get("sort") != "-modified-date" {
+		http.Error(w, "unexpected sort", http.StatusBadRequest)
+		return
+	}
+	statuses := map[string]bool{}
+	for _, st := range q["status"] {
+		statuses[st] = true
+	}
+	var matched []*fakePage
+	for _, p := range s.pages {
+		if statuses[p.Status] {
+			matched = append(matched, p)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Modified.After(matched[j].Modified) })
+
+	offset, _ := strconv.Atoi(q.Get("cursor"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	end := offset + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	var results []map[string]any
+	for _, p := range matched[offset:end] {
+		results = append(results, map[string]any{
+			"id": p.ID, "title": p.Title, "status": p.Status, "spaceId": "500",
+			"version": map[string]any{"number": p.Version, "createdAt": p.Modified.Format(time.RFC3339Nano)},
+		})
+	}
+	s.served += len(results)
+	links := map[string]string{}
+	if end < len(matched) {
+		links["next"] = fmt.Sprintf("/wiki/api/v2/spaces/500/pages?cursor=%d", end)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"results": results, "_links": links})
+}
+
+func newSyncFixture(t *testing.T) (*fakeSpace, *Syncer) {
+	space := &fakeSpace{pages: make(map[string]*fakePage)}
+	srv := httptest.NewServer(http.StripPrefix("/cloud-1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wiki/api/v2/spaces/500/pages" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		space.ServeHTTP(w, r)
+	})))
+	t.Cleanup(srv.Close)
+
+	tm := utils.NewTokenManager()
+	tm.SaveToken("u1", auth.ProviderConfluence, &utils.TokenInfo{
+		AccessToken: "confluence-access",
+		Metadata:    map[string]string{"cloud_id": "cloud-1"},
+	})
+	connector := NewConfluenceConnector(tm)
+	connector.BaseURL = srv.URL + "/"
+	connector.HTTPClient = srv.Client()
+	syncer := NewSyncer(connector, NewMemorySyncStateStore())
+	syncer.pageSize = 2
+	return space, syncer
+}
+
+func eventSummary(events []ChangeEvent) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, fmt.Sprintf("%s %s v%d", e.Type, e.PageID, e.Version))
+	}
+	return out
+}
+
+func TestSyncer_IncrementalChanges(t *testing.T) {
+	space, syncer := newSyncFixture(t)
+	base := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		space.put(fakePage{ID: strconv.Itoa(i), Title: "Page " + strconv.Itoa(i), Status: "current", Version: 1, Modified: base.Add(time.Duration(i) * time.Hour)})
+	}
+	ref := utils.ConnectionRef{UserID: "u1"}
+
+	// 首次同步为全量同步，按修改时间升序创建
+	result, err := syncer.SyncSpace(ref, "", "500", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(eventSummary(result.Events)); !result.Full || got != "[created 1 v1 created 2 v1 created 3 v1 created 4 v1 created 5 v1]" {
+		t.Fatalf("initial sync: full=%v %s", result.Full, got)
+	}
+
+	// 更新、新建、移入回收站（不改变修改时间）
+	space.put(fakePage{ID: "2", Title: "Page 2", Status: "current", Version: 2, Modified: base.Add(10 * time.Hour)})
+	space.put(fakePage{ID: "6", Title: "Page 6", Status: "current", Version: 1, Modified: base.Add(11 * time.Hour)})
+	space.put(fakePage{ID: "3", Title: "Page 3", Status: "trashed", Version: 1, Modified: base.Add(3 * time.Hour)})
+	space.served = 0
+
+	result, err = syncer.SyncSpace(ref, "", "500", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(eventSummary(result.Events)); result.Full || got != "[updated 2 v2 created 6 v1 deleted 3 v1]" {
+		t.Fatalf("incremental sync: full=%v %s", result.Full, got)
+	}
+	// 读到高水位所在的那一批（6、2 | 5、4）即停止，加上回收站中的 3；不会读到 1
+	if space.served > 5 {
+		t.Fatalf("incremental sync read %d pages, expected to stop at the high-water mark", space.served)
+	}
+	if !result.LastModified.Equal(base.Add(11*time.Hour)) || result.Pages != 5 {
+		t.Fatalf("unexpected state: %+v", result)
+	}
+
+	// 没有变化时没有事件
+	result, err = syncer.SyncSpace(ref, "", "500", false)
+	if err != nil || len(result.Events) != 0 {
+		t.Fatalf("no-op sync: %v %v", err, eventSummary(result.Events))
+	}
+
+	// 彻底清除的页面只有全量同步能发现
+	space.mu.Lock()
+	delete(space.pages, "4")
+	space.mu.Unlock()
+	result, err = syncer.SyncSpace(ref, "", "500", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(eventSummary(result.Events)); got != "[deleted 4 v1]" || result.Events[0].Status != "purged" {
+		t.Fatalf("full sync: %s", got)
+	}
+}
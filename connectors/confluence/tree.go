@@ -0,0 +1,84 @@
+package confluence
+
+import (
+	"sort"
+
+	"github.com/ctreminiom/go-atlassian/v2/pkg/infra/models"
+)
+
+// PageTree 空间的页面层级
+type PageTree struct {
+	SpaceID string          `json:"space_id"`
+	Total   int             `json:"total"`
+	Roots   []*PageTreeNode `json:"roots"`
+}
+
+// PageTreeNode 页面树中的一个页面
+type PageTreeNode struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Status   string `json:"status,omitempty"`
+	ParentID string `json:"parent_id,omitempty"`
+	Position int    `json:"position"`
+	// Ancestors 从根页面到直接父页面的页面ID
+	Ancestors []string        `json:"ancestors"`
+	Children  []*PageTreeNode `json:"children"`
+}
+
+// buildPageTree 按 parentId 组装页面树；父页面不在空间内（顶层页面、父节点为文件夹或无权访问）的页面作为根
+func buildPageTree(spaceID string, pages []*models.PageScheme) *PageTree {
+	nodes := make(map[string]*PageTreeNode, len(pages))
+	var order []*PageTreeNode
+	for _, p := range pages {
+		if _, ok := nodes[p.ID]; ok {
+			continue
+		}
+		node := &PageTreeNode{
+			ID:        p.ID,
+			Title:     p.Title,
+			Status:    p.Status,
+			ParentID:  p.ParentID,
+			Position:  p.Position,
+			Ancestors: []string{},
+			Children:  []*PageTreeNode{},
+		}
+		nodes[p.ID] = node
+		order = append(order, node)
+	}
+
+	tree := &PageTree{SpaceID: spaceID, Total: len(order), Roots: []*PageTreeNode{}}
+	for _, node := range order {
+		if parent, ok := nodes[node.ParentID]; ok && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			tree.Roots = append(tree.Roots, node)
+		}
+	}
+
+	// 从根向下填充祖先，visited 防止异常数据形成环
+	visited := make(map[string]bool, len(order))
+	var walk func(siblings []*PageTreeNode, ancestors []string)
+	walk = func(siblings []*PageTreeNode, ancestors []string) {
+		sortNodes(siblings)
+		for _, node := range siblings {
+			if visited[node.ID] {
+				continue
+			}
+			visited[node.ID] = true
+			node.Ancestors = append([]string{}, ancestors...)
+			walk(node.Children, append(node.Ancestors, node.ID))
+		}
+	}
+	walk(tree.Roots, nil)
+	return tree
+}
+
+// sortNodes 按 Confluence 中的位置排序，位置相同时按标题
+func sortNodes(nodes []*PageTreeNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].Position != nodes[j].Position {
+			return nodes[i].Position < nodes[j].Position
+		}
+		return nodes[i].Title < nodes[j].Title
+	})
+}
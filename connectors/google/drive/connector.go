@@ -73,10 +73,10 @@ func NewDriveConnector(tm *utils.TokenManager) *DriveConnector {
 }
 
 // GetService 获取Drive服务客户端
-func (dc *DriveConnector) GetService(userID string) (*drive.Service, error) {
-	tokenInfo, exists := dc.tokenManager.GetToken(userID, auth.ProviderGoogleDrive)
-	if !exists {
-		return nil, fmt.Errorf("未找到Google访问令牌")
+func (dc *DriveConnector) GetService(ref utils.ConnectionRef) (*drive.Service, error) {
+	tokenInfo, err := dc.tokenManager.GetConnectionToken(ref, auth.ProviderGoogleDrive)
+	if err != nil {
+		return nil, fmt.Errorf("获取Google访问令牌失败: %w", err)
 	}
 
 	// 创建OAuth2客户端
@@ -91,8 +91,8 @@ func (dc *DriveConnector) GetService(userID string) (*drive.Service, error) {
 	return service, nil
 }
 
-func (dc *DriveConnector) GetUserInfo(userID string) (*drive.About, error) {
-	service, err := dc.GetService(userID)
+func (dc *DriveConnector) GetUserInfo(ref utils.ConnectionRef) (*drive.About, error) {
+	service, err := dc.GetService(ref)
 	if err != nil {
 		return nil, err
 	}
@@ -100,8 +100,8 @@ func (dc *DriveConnector) GetUserInfo(userID string) (*drive.About, error) {
 }
 
 // ListFiles 获取文件列表
-func (dc *DriveConnector) ListFiles(userID string, maxResults int64) ([]File, error) {
-	service, err := dc.GetService(userID)
+func (dc *DriveConnector) ListFiles(ref utils.ConnectionRef, maxResults int64) ([]File, error) {
+	service, err := dc.GetService(ref)
 	if err != nil {
 		return nil, err
 	}
@@ -126,8 +126,8 @@ func (dc *DriveConnector) ListFiles(userID string, maxResults int64) ([]File, er
 }
 
 // GetFile 获取单个文件详情
-func (dc *DriveConnector) GetFile(userID string, fileID string) (*File, error) {
-	service, err := dc.GetService(userID)
+func (dc *DriveConnector) GetFile(ref utils.ConnectionRef, fileID string) (*File, error) {
+	service, err := dc.GetService(ref)
 	if err != nil {
 		return nil, err
 	}
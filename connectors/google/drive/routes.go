@@ -1,6 +1,10 @@
 package drive
 
-import "github.com/gin-gonic/gin"
+import (
+	"connector-demo/middleware"
+
+	"github.com/gin-gonic/gin"
+)
 
 var driveService *DriveService
 
@@ -12,8 +16,8 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	driveGroup := rg.Group("/drive")
 
 	driveGroup.GET("/files", func(c *gin.Context) {
-		userID := c.Query("user_id")
-		files, _ := driveService.GetFiles(userID, 10)
+		ref := middleware.Connection(c)
+		files, _ := driveService.GetFiles(ref, 10)
 		c.JSON(200, gin.H{"files": files})
 	})
 }
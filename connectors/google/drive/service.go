@@ -1,6 +1,10 @@
 package drive
 
-import "fmt"
+import (
+	"fmt"
+
+	"connector-demo/utils"
+)
 
 // Service Google Drive数据处理接口
 type DriveService struct {
@@ -15,8 +19,8 @@ func NewService(connector *DriveConnector) *DriveService {
 }
 
 // GetFiles 获取文件列表
-func (s *DriveService) GetFiles(userID string, limit int64) ([]File, error) {
-	files, err := s.connector.ListFiles(userID, limit)
+func (s *DriveService) GetFiles(ref utils.ConnectionRef, limit int64) ([]File, error) {
+	files, err := s.connector.ListFiles(ref, limit)
 	if err != nil {
 		return nil, fmt.Errorf("获取文件列表失败: %v", err)
 	}
@@ -24,8 +28,8 @@ func (s *DriveService) GetFiles(userID string, limit int64) ([]File, error) {
 }
 
 // GetFileDetail 获取文件详情
-func (s *DriveService) GetFileDetail(userID string, fileID string) (*File, error) {
-	file, err := s.connector.GetFile(userID, fileID)
+func (s *DriveService) GetFileDetail(ref utils.ConnectionRef, fileID string) (*File, error) {
+	file, err := s.connector.GetFile(ref, fileID)
 	if err != nil {
 		return nil, fmt.Errorf("获取文件详情失败: %v", err)
 	}
@@ -33,20 +37,20 @@ func (s *DriveService) GetFileDetail(userID string, fileID string) (*File, error
 }
 
 // GetRecentFiles 获取最近修改的文件
-func (s *DriveService) GetRecentFiles(userID string, limit int64) ([]File, error) {
-	return s.GetFiles(userID, limit)
+func (s *DriveService) GetRecentFiles(ref utils.ConnectionRef, limit int64) ([]File, error) {
+	return s.GetFiles(ref, limit)
 }
 
 // GetFilesByType 按类型获取文件
-func (s *DriveService) GetFilesByType(userID string, mimeType string, limit int64) ([]File, error) {
+func (s *DriveService) GetFilesByType(ref utils.ConnectionRef, mimeType string, limit int64) ([]File, error) {
 	// 这里可以实现按类型筛选文件的逻辑
 	// 暂时返回所有文件，后续可以扩展
-	return s.GetFiles(userID, limit)
+	return s.GetFiles(ref, limit)
 }
 
 // TestConnection 测试Drive连接
-func (s *DriveService) TestConnection(userID string) bool {
-	_, err := s.connector.GetUserInfo(userID)
+func (s *DriveService) TestConnection(ref utils.ConnectionRef) bool {
+	_, err := s.connector.GetUserInfo(ref)
 	if err != nil {
 		return false
 	}
@@ -0,0 +1,89 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"connector-demo/utils"
+)
+
+// DefaultMaxAttachmentSize 默认允许下载的附件大小上限，与 Gmail 收发附件的上限一致
+const DefaultMaxAttachmentSize = 25 << 20
+
+// ErrAttachmentTooLarge 附件超过允许下载的大小
+var ErrAttachmentTooLarge = errors.New("附件超过允许下载的大小")
+
+// AttachmentDownload 附件内容，Body 为解码后的字节流
+type AttachmentDownload struct {
+	Attachment    Attachment
+	ContentLength int64
+	Body          io.Reader
+}
+
+// ErrAttachmentNotFound 邮件中没有指定的附件
+var ErrAttachmentNotFound = errors.New("附件不存在")
+
+// GetAttachment 获取邮件附件。先从邮件结构中取文件名、类型和大小，超过 maxSize 时不下载；maxSize <= 0 表示不限制。
+// Gmail 每次返回的 attachmentId 都可能不同，优先按固定不变的 partID 定位附件，partID 为空时按 attachmentID 匹配
+func (gc *GmailConnector) GetAttachment(ref utils.ConnectionRef, messageID, attachmentID, partID string, maxSize int64) (*AttachmentDownload, error) {
+	service, err := gc.GetService(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := service.Users.Messages.Get("me", messageID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取邮件详情失败: %w", err)
+	}
+	attachments := walkMIME(msg.Payload).Attachments
+	attachment, found := findAttachment(attachments, attachmentID, partID)
+	if !found && partID != "" {
+		return nil, fmt.Errorf("%w: part %s", ErrAttachmentNotFound, partID)
+	}
+	if found {
+		if maxSize > 0 && attachment.Size > maxSize {
+			return nil, fmt.Errorf("%w: %d > %d 字节", ErrAttachmentTooLarge, attachment.Size, maxSize)
+		}
+		// 内嵌在邮件中的小附件不需要再请求
+		if attachment.AttachmentID == "" {
+			return newAttachmentDownload(attachment, attachment.data), nil
+		}
+		attachmentID = attachment.AttachmentID
+	}
+
+	body, err := service.Users.Messages.Attachments.Get("me", messageID, attachmentID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取附件失败: %w", err)
+	}
+	if maxSize > 0 && body.Size > maxSize {
+		return nil, fmt.Errorf("%w: %d > %d 字节", ErrAttachmentTooLarge, body.Size, maxSize)
+	}
+	if !found {
+		// 旧的 attachmentId 仍然可以下载，但无法确定对应哪个附件，不能沿用其他附件的文件名和类型，按通用二进制文件下载
+		attachment = Attachment{Filename: "attachment", MimeType: "application/octet-stream", AttachmentID: attachmentID}
+	}
+	attachment.Size = body.Size
+	return newAttachmentDownload(attachment, body.Data), nil
+}
+
+// findAttachment 按 partID 或 attachmentID 查找附件
+func findAttachment(attachments []Attachment, attachmentID, partID string) (Attachment, bool) {
+	for _, att := range attachments {
+		if (partID != "" && att.PartID == partID) || (partID == "" && att.AttachmentID == attachmentID) {
+			return att, true
+		}
+	}
+	return Attachment{}, false
+}
+
+func newAttachmentDownload(attachment Attachment, data string) *AttachmentDownload {
+	data = strings.TrimRight(data, "=")
+	return &AttachmentDownload{
+		Attachment:    attachment,
+		ContentLength: int64(base64.RawURLEncoding.DecodedLen(len(data))),
+		Body:          base64.NewDecoder(base64.RawURLEncoding, strings.NewReader(data)),
+	}
+}
@@ -2,8 +2,10 @@ package gmail
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 
 	"connector-demo/auth"
 	"connector-demo/utils"
@@ -12,9 +14,14 @@ import (
 	"google.golang.org/api/option"
 )
 
+// maxListResults Gmail 列表接口单页最大条数
+const maxListResults = 500
+
 // GmailConnector Gmail API封装
 type GmailConnector struct {
 	tokenManager *utils.TokenManager
+	// BaseURL Gmail API地址，为空时使用默认地址，测试时可指向本地的假服务
+	BaseURL string
 }
 
 // Message Gmail邮件信息
@@ -25,11 +32,18 @@ type Message struct {
 	Snippet      string   `json:"snippet"`
 	Subject      string   `json:"subject"`
 	To           string   `json:"to"`
+	Cc           string   `json:"cc,omitempty"`
 	From         string   `json:"from"`
 	Date         string   `json:"date"`
 	InternalDate string   `json:"internalDate"`
-	AttachmentID string   `json:"attachmentId"`
-	Data         string   `json:"data"`
+	// Text、HTML 纯文本和 HTML 正文，已按 charset 转换为 UTF-8
+	Text        string       `json:"text"`
+	HTML        string       `json:"html"`
+	Attachments []Attachment `json:"attachments"`
+	// AttachmentID 第一个附件的ID，兼容旧字段
+	AttachmentID string `json:"attachmentId"`
+	// Data 正文，有纯文本时为纯文本，否则为 HTML，兼容旧字段
+	Data string `json:"data"`
 }
 
 // NewGmailConnector 创建新的Gmail连接器
@@ -40,22 +54,32 @@ func NewGmailConnector(tm *utils.TokenManager) *GmailConnector {
 }
 
 // GetService 获取Gmail服务客户端
-func (gc *GmailConnector) GetService(userID string) (*gmail.Service, error) {
-	tokenInfo, exists := gc.tokenManager.GetToken(userID, auth.ProviderGmail)
-	if !exists {
-		return nil, fmt.Errorf("未找到Google访问令牌")
+func (gc *GmailConnector) GetService(ref utils.ConnectionRef) (*gmail.Service, error) {
+	_, service, err := gc.getTokenService(ref)
+	return service, err
+}
+
+// getTokenService 获取连接的 token 和对应的Gmail服务客户端
+func (gc *GmailConnector) getTokenService(ref utils.ConnectionRef) (*utils.TokenInfo, *gmail.Service, error) {
+	tokenInfo, err := gc.tokenManager.GetConnectionToken(ref, auth.ProviderGmail)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取Google访问令牌失败: %w", err)
 	}
 
 	// 创建OAuth2客户端
 	client := utils.CreateOAuth2Client(tokenInfo.AccessToken)
 
 	// 创建Gmail服务
-	service, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if gc.BaseURL != "" {
+		opts = append(opts, option.WithEndpoint(gc.BaseURL))
+	}
+	service, err := gmail.NewService(context.Background(), opts...)
 	if err != nil {
-		return nil, fmt.Errorf("创建Gmail服务失败: %v", err)
+		return nil, nil, fmt.Errorf("创建Gmail服务失败: %v", err)
 	}
 
-	return service, nil
+	return tokenInfo, service, nil
 }
 
 // 封装：将 gmail.Message 转换为本地 Message 对象
@@ -66,99 +90,125 @@ func parseGmailMessage(msg *gmail.Message) Message {
 		Snippet:  msg.Snippet,
 		LabelIDs: msg.LabelIds,
 	}
+	if msg.InternalDate != 0 {
+		message.InternalDate = strconv.FormatInt(msg.InternalDate, 10)
+	}
 
 	if msg.Payload != nil {
 		// 提取邮件头信息
 		for _, header := range msg.Payload.Headers {
-			switch header.Name {
+			switch http.CanonicalHeaderKey(header.Name) {
 			case "Subject":
-				message.Subject = header.Value
+				message.Subject = decodeHeaderWord(header.Value)
 			case "From":
-				message.From = header.Value
+				message.From = decodeHeaderWord(header.Value)
 			case "Date":
 				message.Date = header.Value
 			case "To":
-				message.To = header.Value
+				message.To = decodeHeaderWord(header.Value)
+			case "Cc":
+				message.Cc = decodeHeaderWord(header.Value)
 			}
 		}
 
-		// 提取邮件正文（body）和附件ID
-		var bodyData string
-		var attachmentID string
-		payload := msg.Payload
-		if len(payload.Parts) > 0 {
-			for _, part := range payload.Parts {
-				if part.MimeType == "text/plain" || part.MimeType == "text/html" {
-					if part.Body != nil && part.Body.Data != "" {
-						decoded, err := base64.URLEncoding.DecodeString(part.Body.Data)
-						if err == nil {
-							bodyData = string(decoded)
-						} else {
-							bodyData = part.Body.Data // fallback
-						}
-					}
-				}
-				// 提取附件ID
-				if part.Filename != "" && part.Body != nil && part.Body.AttachmentId != "" {
-					attachmentID = part.Body.AttachmentId
-				}
-			}
-		} else if payload.Body != nil {
-			if payload.Body.Data != "" {
-				decoded, err := base64.URLEncoding.DecodeString(payload.Body.Data)
-				if err == nil {
-					bodyData = string(decoded)
-				} else {
-					bodyData = payload.Body.Data // fallback
-				}
-			}
-			if payload.Body.AttachmentId != "" {
-				attachmentID = payload.Body.AttachmentId
+		// 递归遍历 MIME 树，提取正文和附件
+		content := walkMIME(msg.Payload)
+		message.Text = strings.Join(content.Text, "\n")
+		message.HTML = strings.Join(content.HTML, "\n")
+		message.Attachments = content.Attachments
+		message.Data = message.Text
+		if message.Data == "" {
+			message.Data = message.HTML
+		}
+		for _, att := range content.Attachments {
+			if !att.Inline && att.AttachmentID != "" {
+				message.AttachmentID = att.AttachmentID
+				break
 			}
 		}
-		message.Data = bodyData
-		message.AttachmentID = attachmentID
+	}
+	if message.Attachments == nil {
+		message.Attachments = []Attachment{}
 	}
 
 	return message
 }
 
-func (dc *GmailConnector) GetUserInfo(userID string) (*gmail.Profile, error) {
-	service, err := dc.GetService(userID)
+func (dc *GmailConnector) GetUserInfo(ref utils.ConnectionRef) (*gmail.Profile, error) {
+	service, err := dc.GetService(ref)
 	if err != nil {
 		return nil, err
 	}
 	return service.Users.GetProfile("me").Do()
 }
 
-// ListMessages 获取邮件列表
-func (gc *GmailConnector) ListMessages(userID string, maxResults int64) ([]Message, error) {
-	service, err := gc.GetService(userID)
+// ListOptions 邮件列表的查询条件
+type ListOptions struct {
+	// MaxResults 单页条数，最大 500
+	MaxResults int64
+	// PageToken 上一页返回的 NextPageToken，为空时从第一页开始
+	PageToken string
+	// Query Gmail 搜索语法，如 from:x has:attachment after:2024/01/01
+	Query string
+	// LabelIDs 只返回同时带有这些标签的邮件，如 INBOX、UNREAD
+	LabelIDs []string
+	// IncludeSpamTrash 是否包含垃圾邮件和已删除邮件
+	IncludeSpamTrash bool
+}
+
+// MessageList 一页邮件，NextPageToken 为空表示没有更多
+type MessageList struct {
+	Messages           []Message `json:"messages"`
+	NextPageToken      string    `json:"nextPageToken,omitempty"`
+	ResultSizeEstimate int64     `json:"resultSizeEstimate"`
+}
+
+// ListMessages 获取一页邮件
+func (gc *GmailConnector) ListMessages(ref utils.ConnectionRef, opts ListOptions) (*MessageList, error) {
+	service, err := gc.GetService(ref)
 	if err != nil {
 		return nil, err
 	}
 
+	call := service.Users.Messages.List("me").IncludeSpamTrash(opts.IncludeSpamTrash)
+	if opts.MaxResults > 0 {
+		call = call.MaxResults(min(opts.MaxResults, maxListResults))
+	}
+	if opts.PageToken != "" {
+		call = call.PageToken(opts.PageToken)
+	}
+	if opts.Query != "" {
+		call = call.Q(opts.Query)
+	}
+	if len(opts.LabelIDs) > 0 {
+		call = call.LabelIds(opts.LabelIDs...)
+	}
+
 	// 获取邮件列表
-	messages, err := service.Users.Messages.List("me").MaxResults(maxResults).Do()
+	messages, err := call.Do()
 	if err != nil {
 		return nil, fmt.Errorf("获取邮件列表失败: %v", err)
 	}
 
-	var result []Message
+	result := &MessageList{
+		Messages:           []Message{},
+		NextPageToken:      messages.NextPageToken,
+		ResultSizeEstimate: messages.ResultSizeEstimate,
+	}
 	for _, msg := range messages.Messages {
 		fullMsg, err := service.Users.Messages.Get("me", msg.Id).Do()
 		if err != nil {
 			continue // 跳过失败的邮件
 		}
-		result = append(result, parseGmailMessage(fullMsg))
+		result.Messages = append(result.Messages, parseGmailMessage(fullMsg))
 	}
 
 	return result, nil
 }
 
 // GetMessage 获取单封邮件详情
-func (gc *GmailConnector) GetMessage(userID string, messageID string) (*Message, error) {
-	service, err := gc.GetService(userID)
+func (gc *GmailConnector) GetMessage(ref utils.ConnectionRef, messageID string) (*Message, error) {
+	service, err := gc.GetService(ref)
 	if err != nil {
 		return nil, err
 	}
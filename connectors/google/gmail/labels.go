@@ -0,0 +1,161 @@
+package gmail
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"connector-demo/auth"
+	"connector-demo/utils"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultLabelCacheTTL 标签统计的缓存时间，避免看板轮询消耗配额
+const DefaultLabelCacheTTL = 30 * time.Second
+
+// labelFetchConcurrency 并发获取标签统计的请求数
+const labelFetchConcurrency = 8
+
+// Label 标签及其邮件、会话统计
+type Label struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Type           string `json:"type"` // system 或 user
+	MessagesTotal  int64  `json:"messagesTotal"`
+	MessagesUnread int64  `json:"messagesUnread"`
+	ThreadsTotal   int64  `json:"threadsTotal"`
+	ThreadsUnread  int64  `json:"threadsUnread"`
+}
+
+// UnreadCount 标签下的未读数
+type UnreadCount struct {
+	LabelID  string `json:"labelId"`
+	Messages int64  `json:"messages"`
+	Threads  int64  `json:"threads"`
+}
+
+func toLabel(l *gmail.Label) Label {
+	return Label{
+		ID:             l.Id,
+		Name:           l.Name,
+		Type:           l.Type,
+		MessagesTotal:  l.MessagesTotal,
+		MessagesUnread: l.MessagesUnread,
+		ThreadsTotal:   l.ThreadsTotal,
+		ThreadsUnread:  l.ThreadsUnread,
+	}
+}
+
+// connectionKey 连接的唯一键，用于按连接缓存
+func (gc *GmailConnector) connectionKey(ref utils.ConnectionRef) (string, error) {
+	token, err := gc.tokenManager.GetConnectionToken(ref, auth.ProviderGmail)
+	if err != nil {
+		return "", fmt.Errorf("获取Google访问令牌失败: %w", err)
+	}
+	return ref.UserID + "/" + token.ConnectionID, nil
+}
+
+// ListLabels 获取全部标签及统计。labels.list 不返回统计，需要逐个调用 labels.get
+func (gc *GmailConnector) ListLabels(ref utils.ConnectionRef) ([]Label, error) {
+	service, err := gc.GetService(ref)
+	if err != nil {
+		return nil, err
+	}
+	list, err := service.Users.Labels.List("me").Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取标签列表失败: %v", err)
+	}
+
+	labels := make([]Label, len(list.Labels))
+	errs := make([]error, len(list.Labels))
+	sem := make(chan struct{}, labelFetchConcurrency)
+	var wg sync.WaitGroup
+	for i, l := range list.Labels {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			full, err := service.Users.Labels.Get("me", l.Id).Do()
+			if err != nil {
+				errs[i] = fmt.Errorf("获取标签 %s 失败: %v", l.Id, err)
+				return
+			}
+			labels[i] = toLabel(full)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 系统标签在前，各自按名称排序
+	sort.SliceStable(labels, func(i, j int) bool {
+		if (labels[i].Type == "system") != (labels[j].Type == "system") {
+			return labels[i].Type == "system"
+		}
+		return strings.ToLower(labels[i].Name) < strings.ToLower(labels[j].Name)
+	})
+	return labels, nil
+}
+
+// GetLabel 获取单个标签及统计
+func (gc *GmailConnector) GetLabel(ref utils.ConnectionRef, labelID string) (*Label, error) {
+	service, err := gc.GetService(ref)
+	if err != nil {
+		return nil, err
+	}
+	l, err := service.Users.Labels.Get("me", labelID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取标签失败: %w", err)
+	}
+	label := toLabel(l)
+	return &label, nil
+}
+
+// labelCache 按连接短时间缓存标签统计
+type labelCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]labelCacheEntry // 连接键 -> 全部标签；连接键#标签ID -> 单个标签
+}
+
+type labelCacheEntry struct {
+	labels    []Label
+	fetchedAt time.Time
+}
+
+func newLabelCache(ttl time.Duration) *labelCache {
+	return &labelCache{ttl: ttl, entries: make(map[string]labelCacheEntry)}
+}
+
+func (c *labelCache) get(key string) ([]Label, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.fetchedAt) >= c.ttl {
+		return nil, false
+	}
+	return entry.labels, true
+}
+
+func (c *labelCache) put(key string, labels []Label) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	// 顺便清理过期的记录
+	for k, entry := range c.entries {
+		if now.Sub(entry.fetchedAt) >= c.ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = labelCacheEntry{labels: labels, fetchedAt: now}
+}
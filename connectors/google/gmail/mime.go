@@ -0,0 +1,188 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"io"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Attachment 邮件附件，Inline 为正文中通过 cid: 引用的内嵌资源（如内嵌图片）
+type Attachment struct {
+	// PartID MIME 部分的编号（如 1、0.2），同一封邮件中固定不变；AttachmentID 每次获取邮件都可能不同
+	PartID       string `json:"partId"`
+	Filename     string `json:"filename"`
+	MimeType     string `json:"mimeType"`
+	Size         int64  `json:"size"`
+	AttachmentID string `json:"attachmentId,omitempty"`
+	ContentID    string `json:"contentId,omitempty"`
+	Inline       bool   `json:"inline"`
+
+	// data 较小的附件 Gmail 直接内嵌在邮件中，没有 AttachmentID
+	data string
+}
+
+// messageContent 遍历 MIME 树得到的正文和附件
+type messageContent struct {
+	Text        []string
+	HTML        []string
+	Attachments []Attachment
+}
+
+// walkMIME 递归遍历 MIME 树：multipart/* 逐个处理子部分，没有文件名的 text/plain、text/html 作为正文，
+// 其余带内容的部分作为附件。multipart/alternative 中同一类型只取第一个，其他 multipart 中的正文按顺序拼接
+func walkMIME(part *gmail.MessagePart) messageContent {
+	var content messageContent
+	walkPart(part, &content)
+	return content
+}
+
+func walkPart(part *gmail.MessagePart, content *messageContent) {
+	if part == nil {
+		return
+	}
+	mediaType, params := parseContentType(part)
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if mediaType != "multipart/alternative" {
+			for _, child := range part.Parts {
+				walkPart(child, content)
+			}
+			return
+		}
+		// 各子部分是同一内容的不同表示，每种正文只保留第一个
+		var alt messageContent
+		for _, child := range part.Parts {
+			walkPart(child, &alt)
+		}
+		if len(alt.Text) > 0 {
+			content.Text = append(content.Text, alt.Text[0])
+		}
+		if len(alt.HTML) > 0 {
+			content.HTML = append(content.HTML, alt.HTML[0])
+		}
+		content.Attachments = append(content.Attachments, alt.Attachments...)
+		return
+	}
+
+	disposition, dispParams := parseDisposition(part)
+	filename := decodeHeaderWord(part.Filename)
+	if filename == "" {
+		filename = decodeHeaderWord(dispParams["filename"])
+	}
+	if filename == "" {
+		filename = decodeHeaderWord(params["name"])
+	}
+
+	if filename == "" && disposition != "attachment" && (mediaType == "text/plain" || mediaType == "text/html") {
+		body := decodeBody(part.Body, params["charset"])
+		if mediaType == "text/plain" {
+			content.Text = append(content.Text, body)
+		} else {
+			content.HTML = append(content.HTML, body)
+		}
+		return
+	}
+
+	// 没有文件名的 message/rfc822 等容器，Gmail 会把内部结构展开在 Parts 中
+	if filename == "" && len(part.Parts) > 0 {
+		for _, child := range part.Parts {
+			walkPart(child, content)
+		}
+		return
+	}
+
+	if part.Body == nil || (part.Body.AttachmentId == "" && part.Body.Data == "") {
+		return
+	}
+	contentID := strings.Trim(headerValue(part.Headers, "Content-Id"), "<> ")
+	content.Attachments = append(content.Attachments, Attachment{
+		PartID:       part.PartId,
+		Filename:     filename,
+		MimeType:     mediaType,
+		Size:         part.Body.Size,
+		AttachmentID: part.Body.AttachmentId,
+		ContentID:    contentID,
+		Inline:       disposition == "inline" || (disposition == "" && contentID != ""),
+		data:         part.Body.Data,
+	})
+}
+
+// parseContentType 解析 Content-Type 头，没有时使用 part.MimeType
+func parseContentType(part *gmail.MessagePart) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(headerValue(part.Headers, "Content-Type"))
+	if err != nil {
+		mediaType, params = strings.ToLower(part.MimeType), map[string]string{}
+	}
+	if mediaType == "" {
+		mediaType = strings.ToLower(part.MimeType)
+	}
+	return mediaType, params
+}
+
+func parseDisposition(part *gmail.MessagePart) (string, map[string]string) {
+	disposition, params, err := mime.ParseMediaType(headerValue(part.Headers, "Content-Disposition"))
+	if err != nil {
+		return "", map[string]string{}
+	}
+	return disposition, params
+}
+
+// headerValue 按名称查找头，不区分大小写
+func headerValue(headers []*gmail.MessagePartHeader, name string) string {
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// decodeHeaderWord 解码 RFC 2047 编码的头（如 =?GB2312?B?...?=）
+func decodeHeaderWord(s string) string {
+	if !strings.Contains(s, "=?") {
+		return s
+	}
+	decoded, err := headerDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// decodeBody 解码 base64url 正文并按 charset 转换为 UTF-8，无法识别的 charset 原样返回
+func decodeBody(body *gmail.MessagePartBody, charset string) string {
+	if body == nil || body.Data == "" {
+		return ""
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(body.Data, "="))
+	if err != nil {
+		return body.Data // fallback
+	}
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" {
+		return string(raw)
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return string(raw)
+	}
+	decoded, err := enc.NewDecoder().Bytes(raw)
+	if err != nil {
+		return string(raw)
+	}
+	return string(decoded)
+}
@@ -0,0 +1,179 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+func b64(s string) string { return base64.URLEncoding.EncodeToString([]byte(s)) }
+
+func header(name, value string) *gmail.MessagePartHeader {
+	return &gmail.MessagePartHeader{Name: name, Value: value}
+}
+
+func textPart(mimeType, body string, headers ...*gmail.MessagePartHeader) *gmail.MessagePart {
+	return &gmail.MessagePart{MimeType: mimeType, Headers: headers, Body: &gmail.MessagePartBody{Data: b64(body), Size: int64(len(body))}}
+}
+
+func multipart(mimeType string, parts ...*gmail.MessagePart) *gmail.MessagePart {
+	return &gmail.MessagePart{MimeType: mimeType, Body: &gmail.MessagePartBody{}, Parts: parts}
+}
+
+func filePart(filename, mimeType, attachmentID string, size int64, headers ...*gmail.MessagePartHeader) *gmail.MessagePart {
+	return &gmail.MessagePart{Filename: filename, MimeType: mimeType, Headers: headers, Body: &gmail.MessagePartBody{AttachmentId: attachmentID, Size: size}}
+}
+
+func TestParseGmailMessage_MIME(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      *gmail.MessagePart
+		text         string
+		html         string
+		attachments  []Attachment
+		data         string
+		attachmentID string
+	}{
+		{
+			name:    "single text part",
+			payload: textPart("text/plain", "hello"),
+			text:    "hello",
+			data:    "hello",
+		},
+		{
+			name: "alternative keeps text and html regardless of order",
+			payload: multipart("multipart/alternative",
+				textPart("text/html", "<p>hi</p>"),
+				textPart("text/plain", "hi"),
+			),
+			text: "hi",
+			html: "<p>hi</p>",
+			data: "hi",
+		},
+		{
+			name: "html only",
+			payload: multipart("multipart/alternative",
+				textPart("text/html", "<b>only</b>"),
+			),
+			html: "<b>only</b>",
+			data: "<b>only</b>",
+		},
+		{
+			name: "nested mixed with related inline image and attachments",
+			payload: multipart("multipart/mixed",
+				multipart("multipart/alternative",
+					textPart("text/plain", "see invoice"),
+					multipart("multipart/related",
+						textPart("text/html", `<p>see invoice <img src="cid:logo@x"></p>`),
+						filePart("logo.png", "image/png", "att-logo", 120,
+							header("Content-Type", "image/png; name=logo.png"),
+							header("Content-Disposition", "inline; filename=logo.png"),
+							header("Content-ID", "<logo@x>")),
+					),
+				),
+				filePart("invoice.pdf", "application/pdf", "att-pdf", 2048,
+					header("Content-Type", "application/pdf; name=invoice.pdf"),
+					header("Content-Disposition", "attachment; filename=invoice.pdf")),
+				filePart("contract.docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "att-doc", 4096),
+			),
+			text: "see invoice",
+			html: `<p>see invoice <img src="cid:logo@x"></p>`,
+			attachments: []Attachment{
+				{Filename: "logo.png", MimeType: "image/png", Size: 120, AttachmentID: "att-logo", ContentID: "logo@x", Inline: true},
+				{Filename: "invoice.pdf", MimeType: "application/pdf", Size: 2048, AttachmentID: "att-pdf"},
+				{Filename: "contract.docx", MimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Size: 4096, AttachmentID: "att-doc"},
+			},
+			data:         "see invoice",
+			attachmentID: "att-pdf",
+		},
+		{
+			name: "text file attachment is not body",
+			payload: multipart("multipart/mixed",
+				textPart("text/plain", "body"),
+				&gmail.MessagePart{MimeType: "text/plain", Filename: "notes.txt",
+					Headers: []*gmail.MessagePartHeader{header("Content-Disposition", "attachment; filename=notes.txt")},
+					Body:    &gmail.MessagePartBody{AttachmentId: "att-txt", Size: 5}},
+			),
+			text:         "body",
+			attachments:  []Attachment{{Filename: "notes.txt", MimeType: "text/plain", Size: 5, AttachmentID: "att-txt"}},
+			data:         "body",
+			attachmentID: "att-txt",
+		},
+		{
+			name: "mixed text parts are concatenated",
+			payload: multipart("multipart/mixed",
+				textPart("text/plain", "part one"),
+				filePart("a.png", "image/png", "att-a", 10, header("Content-Disposition", "inline; filename=a.png")),
+				textPart("text/plain", "part two"),
+			),
+			text:         "part one\npart two",
+			attachments:  []Attachment{{Filename: "a.png", MimeType: "image/png", Size: 10, AttachmentID: "att-a", Inline: true}},
+			data:         "part one\npart two",
+			attachmentID: "",
+		},
+		{
+			name: "charsets are decoded",
+			payload: multipart("multipart/alternative",
+				textPart("text/plain", "\xc4\xe3\xba\xc3", header("Content-Type", `text/plain; charset="GB2312"`)),
+				textPart("text/html", "<p>caf\xe9</p>", header("Content-Type", "text/html; charset=iso-8859-1")),
+			),
+			text: "你好",
+			html: "<p>café</p>",
+			data: "你好",
+		},
+		{
+			name: "encoded attachment filename",
+			payload: multipart("multipart/mixed",
+				textPart("text/plain", "x"),
+				filePart("", "application/pdf", "att-cn", 1,
+					header("Content-Type", "application/pdf"),
+					header("Content-Disposition", `attachment; filename="=?UTF-8?B?5ZCI5ZCMLnBkZg==?="`)),
+			),
+			text:         "x",
+			attachments:  []Attachment{{Filename: "合同.pdf", MimeType: "application/pdf", Size: 1, AttachmentID: "att-cn"}},
+			data:         "x",
+			attachmentID: "att-cn",
+		},
+		{
+			name:    "unknown charset falls back to raw bytes",
+			payload: textPart("text/plain", "plain", header("Content-Type", "text/plain; charset=x-unknown")),
+			text:    "plain",
+			data:    "plain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := parseGmailMessage(&gmail.Message{Id: "m1", Payload: tt.payload})
+			if msg.Text != tt.text || msg.HTML != tt.html {
+				t.Fatalf("bodies: text=%q html=%q", msg.Text, msg.HTML)
+			}
+			want := tt.attachments
+			if want == nil {
+				want = []Attachment{}
+			}
+			if !reflect.DeepEqual(msg.Attachments, want) {
+				t.Fatalf("attachments:\n got %+v\nwant %+v", msg.Attachments, want)
+			}
+			if msg.Data != tt.data || msg.AttachmentID != tt.attachmentID {
+				t.Fatalf("legacy fields: data=%q attachmentId=%q", msg.Data, msg.AttachmentID)
+			}
+		})
+	}
+}
+
+func TestParseGmailMessage_Headers(t *testing.T) {
+	msg := parseGmailMessage(&gmail.Message{Id: "m1", Payload: &gmail.MessagePart{
+		MimeType: "text/plain",
+		Headers: []*gmail.MessagePartHeader{
+			header("subject", "=?UTF-8?B?5L2g5aW9?="),
+			header("FROM", "Alice <alice@example.com>"),
+		},
+		Body: &gmail.MessagePartBody{},
+	}})
+	if msg.Subject != "你好" || msg.From != "Alice <alice@example.com>" {
+		t.Fatalf("headers: %+v", msg)
+	}
+}
@@ -0,0 +1,229 @@
+package gmail
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"connector-demo/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// GoogleJWKSURL Google 签发 OIDC token 的公钥地址
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// googleIssuers Pub/Sub 推送 token 的签发方
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// jwksRefreshInterval 遇到未知 kid 时最多每隔这么久重新拉取一次公钥
+const jwksRefreshInterval = time.Minute
+
+// PushVerifier 校验 Pub/Sub 推送请求中 Google 签发的 OIDC token
+type PushVerifier struct {
+	// Audience 推送订阅配置的 audience，必须与 token 的 aud 一致
+	Audience string
+	// ServiceAccount 推送订阅使用的服务账号邮箱，必须与 token 的 email 一致。
+	// 只校验 aud 时任何 Google 签发的 token 都能冒充推送，因此不能为空
+	ServiceAccount string
+	JWKSURL        string
+	HTTPClient     *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func NewPushVerifier(audience, serviceAccount string) *PushVerifier {
+	return &PushVerifier{
+		Audience:       audience,
+		ServiceAccount: serviceAccount,
+		JWKSURL:        GoogleJWKSURL,
+		HTTPClient:     http.DefaultClient,
+	}
+}
+
+// pushClaims Google OIDC token 中用到的声明
+type pushClaims struct {
+	jwt.RegisteredClaims
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// Verify 校验请求的 Authorization: Bearer token
+func (v *PushVerifier) Verify(r *http.Request) error {
+	if v.Audience == "" {
+		return errors.New("未配置推送的audience")
+	}
+	if v.ServiceAccount == "" {
+		return errors.New("未配置推送的服务账号")
+	}
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || raw == "" {
+		return errors.New("缺少Bearer token")
+	}
+
+	claims := &pushClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, v.keyFunc,
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(v.Audience),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return err
+	}
+	issuer, _ := claims.GetIssuer()
+	validIssuer := false
+	for _, iss := range googleIssuers {
+		validIssuer = validIssuer || issuer == iss
+	}
+	if !validIssuer {
+		return fmt.Errorf("无效的签发方: %q", issuer)
+	}
+	if !claims.EmailVerified || !strings.EqualFold(claims.Email, v.ServiceAccount) {
+		return fmt.Errorf("推送账号不匹配: %q", claims.Email)
+	}
+	return nil
+}
+
+// keyFunc 按 kid 查找公钥，找不到时重新拉取（Google 会定期轮换密钥）
+func (v *PushVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("未知的kid: %q", kid)
+	}
+	keys, err := v.fetchKeys()
+	v.fetchedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("未知的kid: %q", kid)
+}
+
+func (v *PushVerifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	resp, err := v.HTTPClient.Get(v.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("获取Google公钥失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取Google公钥失败: HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("获取Google公钥失败: %v", err)
+	}
+	return middleware.ParseJWKS(data)
+}
+
+// PushMessage Pub/Sub 推送的请求体
+type PushMessage struct {
+	Message struct {
+		Data        string `json:"data"`
+		MessageID   string `json:"messageId"`
+		PublishTime string `json:"publishTime"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// PushNotification Gmail 推送的内容：哪个邮箱在哪个 historyId 有变更
+type PushNotification struct {
+	EmailAddress string `json:"emailAddress"`
+	HistoryID    uint64 `json:"historyId"`
+}
+
+// decodePush 解码推送请求体中 base64 编码的 Gmail 通知
+func decodePush(body io.Reader) (*PushNotification, error) {
+	var msg PushMessage
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("解析推送消息失败: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Message.Data)
+	if err != nil {
+		return nil, fmt.Errorf("解码推送消息失败: %v", err)
+	}
+	var n PushNotification
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, fmt.Errorf("解析Gmail通知失败: %v", err)
+	}
+	if n.EmailAddress == "" {
+		return nil, errors.New("Gmail通知缺少emailAddress")
+	}
+	return &n, nil
+}
+
+// PushHandler 接收 Pub/Sub 推送：校验 token，按邮箱找到订阅的连接，记录通知中的 historyId 后触发增量同步，
+// 同步结果交给 ChangeHandler。同步失败时保留待同步标记并返回 500，由 Pub/Sub 重试；返回 2xx 表示确认消息
+func (s *GmailService) PushHandler(verifier *PushVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := verifier.Verify(c.Request); err != nil {
+			log.Printf("Gmail推送校验失败: %v", err)
+			c.JSON(401, gin.H{"error": "推送校验失败"})
+			return
+		}
+		notification, err := decodePush(c.Request.Body)
+		if err != nil {
+			// 格式错误的消息重试也无法处理，直接确认
+			log.Printf("忽略无法解析的Gmail推送: %v", err)
+			c.Status(204)
+			return
+		}
+
+		watches, err := s.watcher.store.FindByEmail(notification.EmailAddress)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if len(watches) == 0 {
+			log.Printf("忽略未订阅邮箱的Gmail推送: %s", notification.EmailAddress)
+		}
+		failed := false
+		for _, watch := range watches {
+			ref := watch.Ref()
+			pending, err := s.watcher.markPending(watch.UserID, watch.ConnectionID, notification.HistoryID)
+			if err != nil {
+				log.Printf("记录Gmail推送失败(user=%s connection=%s): %v", ref.UserID, ref.ConnectionID, err)
+				failed = true
+				continue
+			}
+			if !pending {
+				continue
+			}
+			// 同步完成后清除待同步标记；失败时标记保留，调用方仍可以通过同步接口拉取
+			result, err := s.Sync(ref, false)
+			if err != nil {
+				log.Printf("Gmail推送触发同步失败(user=%s connection=%s): %v", ref.UserID, ref.ConnectionID, err)
+				failed = true
+				continue
+			}
+			if s.changeHandler != nil && (result.Full || len(result.Events) > 0) {
+				s.changeHandler(ref, result)
+			}
+		}
+		if failed {
+			c.JSON(500, gin.H{"error": "同步失败"})
+			return
+		}
+		c.Status(204)
+	}
+}
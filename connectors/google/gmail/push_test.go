@@ -0,0 +1,281 @@
+package gmail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakePushSender 模拟 Pub/Sub 推送：用本地 RSA 密钥签发 OIDC token，并通过 JWKS 服务公开公钥
+type fakePushSender struct {
+	key  *rsa.PrivateKey
+	jwks *httptest.Server
+}
+
+func newFakePushSender(t *testing.T) *fakePushSender {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(jwks.Close)
+	return &fakePushSender{key: key, jwks: jwks}
+}
+
+func (s *fakePushSender) verifier() *PushVerifier {
+	v := NewPushVerifier("https://connector.example.com/webhooks/gmail", "push@project.iam.gserviceaccount.com")
+	v.JWKSURL = s.jwks.URL
+	return v
+}
+
+func (s *fakePushSender) token(t *testing.T, audience, email string) string {
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, pushClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "https://accounts.google.com",
+			Audience:  jwt.ClaimStrings{audience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Email:         email,
+		EmailVerified: true,
+	})
+	tok.Header["kid"] = "k1"
+	signed, err := tok.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// deliver 发送一条 Pub/Sub 推送
+func (s *fakePushSender) deliver(r http.Handler, token, email string, historyID uint64) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(map[string]any{"emailAddress": email, "historyId": historyID})
+	body, _ := json.Marshal(map[string]any{
+		"message":      map[string]string{"data": base64.StdEncoding.EncodeToString(data), "messageId": "1"},
+		"subscription": "projects/p/subscriptions/gmail-push",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/gmail", bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPushVerifier(t *testing.T) {
+	sender := newFakePushSender(t)
+	verifier := sender.verifier()
+	request := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/gmail", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return req
+	}
+
+	if err := verifier.Verify(request(sender.token(t, verifier.Audience, verifier.ServiceAccount))); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if err := verifier.Verify(request(sender.token(t, "https://other.example.com", verifier.ServiceAccount))); err == nil {
+		t.Fatal("token with wrong audience accepted")
+	}
+	if err := verifier.Verify(request(sender.token(t, verifier.Audience, "attacker@example.com"))); err == nil {
+		t.Fatal("token from another account accepted")
+	}
+	if err := verifier.Verify(request("")); err == nil {
+		t.Fatal("request without token accepted")
+	}
+	// 没有配置服务账号时拒绝全部请求
+	noAccount := NewPushVerifier(verifier.Audience, "")
+	noAccount.JWKSURL = verifier.JWKSURL
+	if err := noAccount.Verify(request(sender.token(t, verifier.Audience, "anyone@example.com"))); err == nil {
+		t.Fatal("token accepted without configured service account")
+	}
+
+	// 其他密钥签发的 token
+	other := newFakePushSender(t)
+	if err := verifier.Verify(request(other.token(t, verifier.Audience, verifier.ServiceAccount))); err == nil {
+		t.Fatal("token signed by unknown key accepted")
+	}
+}
+
+func TestPush_WatchAndSync(t *testing.T) {
+	mailbox := newFakeMailbox()
+	mailbox.add("m1", "INBOX")
+	var watchRequests []map[string]any
+	var mu sync.Mutex
+	mux := http.NewServeMux()
+	mailbox.register(mux)
+	mux.HandleFunc("POST /gmail/v1/users/me/watch", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		watchRequests = append(watchRequests, req)
+		mu.Unlock()
+		mailbox.mu.Lock()
+		historyID := mailbox.historyID
+		mailbox.mu.Unlock()
+		expiration := time.Now().Add(7 * 24 * time.Hour).UnixMilli()
+		json.NewEncoder(w).Encode(map[string]string{"historyId": strconv.FormatUint(historyID, 10), "expiration": strconv.FormatInt(expiration, 10)})
+	})
+	connector := newFakeGmail(t, mux)
+	r := newTestRouter(connector)
+
+	// 未配置 topic 时不能订阅
+	if w := do(r, http.MethodPost, "/api/google/gmail/watch", "u1"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("watch without topic: expected 503, got %d", w.Code)
+	}
+
+	gmailService.SetWatchTopic("projects/p/topics/gmail")
+	var changes []*SyncResult
+	gmailService.SetChangeHandler(func(ref utils.ConnectionRef, result *SyncResult) {
+		changes = append(changes, result)
+	})
+	w := do(r, http.MethodPost, "/api/google/gmail/watch?label=INBOX", "u1")
+	var watch WatchState
+	json.Unmarshal(w.Body.Bytes(), &watch)
+	if w.Code != http.StatusOK || watch.EmailAddress != "alice@example.com" || watch.HistoryID != 101 || time.Until(watch.Expiration) < 6*24*time.Hour {
+		t.Fatalf("watch: %d %s", w.Code, w.Body.String())
+	}
+	if got := fmt.Sprintf("%v %v %v", watchRequests[0]["topicName"], watchRequests[0]["labelIds"], watchRequests[0]["labelFilterBehavior"]); got != "projects/p/topics/gmail [INBOX] include" {
+		t.Fatalf("unexpected watch request: %s", got)
+	}
+
+	// 推送接口挂在 /api 之外，由推送 token 认证
+	sender := newFakePushSender(t)
+	verifier := sender.verifier()
+	push := gin.New()
+	push.POST("/webhooks/gmail", gmailService.PushHandler(verifier))
+	token := sender.token(t, verifier.Audience, verifier.ServiceAccount)
+
+	if w := sender.deliver(push, "", "alice@example.com", 101); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated push: expected 401, got %d", w.Code)
+	}
+	if w := sender.deliver(push, sender.token(t, "https://other.example.com", verifier.ServiceAccount), "alice@example.com", 101); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong audience: expected 401, got %d", w.Code)
+	}
+
+	pendingHistoryID := func() uint64 {
+		w := do(r, http.MethodGet, "/api/google/gmail/watch", "u1")
+		var watch WatchState
+		json.Unmarshal(w.Body.Bytes(), &watch)
+		return watch.PendingHistoryID
+	}
+
+	// 首次推送建立同步基线（全量），之后的推送触发增量同步，变更交给 ChangeHandler
+	if w := sender.deliver(push, token, "alice@example.com", 101); w.Code != http.StatusNoContent {
+		t.Fatalf("first push: %d %s", w.Code, w.Body.String())
+	}
+	mailbox.add("m2", "INBOX", "UNREAD")
+	if w := sender.deliver(push, token, "Alice@example.com", 102); w.Code != http.StatusNoContent {
+		t.Fatalf("second push: %d %s", w.Code, w.Body.String())
+	}
+	if len(changes) != 2 || !changes[0].Full || changes[1].Full || mailbox.requests["history"] != 1 {
+		t.Fatalf("unexpected sync results: %+v, requests %v", changes, mailbox.requests)
+	}
+	if got := fmt.Sprint(syncSummary(changes[1].Events)); got != "[message_added m2[INBOX UNREAD]]" {
+		t.Fatalf("unexpected push events: %s", got)
+	}
+	if p := pendingHistoryID(); p != 0 {
+		t.Fatalf("pending not cleared after sync: %d", p)
+	}
+	// 重复投递没有新变更，不回调
+	if w := sender.deliver(push, token, "alice@example.com", 102); w.Code != http.StatusNoContent || len(changes) != 2 {
+		t.Fatalf("duplicate push: %d, %d changes", w.Code, len(changes))
+	}
+
+	// 同步失败时保留待同步标记并返回 500，Pub/Sub 重试时补上同步
+	mailbox.mu.Lock()
+	mailbox.failHistory = true
+	mailbox.mu.Unlock()
+	mailbox.add("m3", "INBOX")
+	if w := sender.deliver(push, token, "alice@example.com", 103); w.Code != http.StatusInternalServerError {
+		t.Fatalf("failed sync: expected 500, got %d", w.Code)
+	}
+	if p := pendingHistoryID(); p != 103 || len(changes) != 2 {
+		t.Fatalf("pending after failed sync: %d, %d changes", p, len(changes))
+	}
+	mailbox.mu.Lock()
+	mailbox.failHistory = false
+	mailbox.mu.Unlock()
+	if w := sender.deliver(push, token, "alice@example.com", 103); w.Code != http.StatusNoContent {
+		t.Fatalf("retried push: %d %s", w.Code, w.Body.String())
+	}
+	if p := pendingHistoryID(); p != 0 || len(changes) != 3 || fmt.Sprint(syncSummary(changes[2].Events)) != "[message_added m3[INBOX]]" {
+		t.Fatalf("retried push: pending %d, changes %d", p, len(changes))
+	}
+
+	// 未订阅的邮箱直接确认，不触发同步
+	if w := sender.deliver(push, token, "bob@example.com", 1); w.Code != http.StatusNoContent || len(changes) != 3 {
+		t.Fatalf("unknown mailbox: %d, %d changes", w.Code, len(changes))
+	}
+
+	// 即将到期的订阅会被续订，续订保留待同步标记
+	mailbox.mu.Lock()
+	mailbox.failHistory = true
+	mailbox.mu.Unlock()
+	sender.deliver(push, token, "alice@example.com", 110)
+	watcher := gmailService.Watcher()
+	if n := watcher.renewExpiring(24 * time.Hour); n != 0 {
+		t.Fatalf("fresh watch renewed: %d", n)
+	}
+	if n := watcher.renewExpiring(8 * 24 * time.Hour); n != 1 || len(watchRequests) != 2 {
+		t.Fatalf("expiring watch not renewed: %d, %d requests", n, len(watchRequests))
+	}
+	if got := fmt.Sprint(watchRequests[1]["labelIds"]); got != "[INBOX]" {
+		t.Fatalf("renewal lost label filter: %s", got)
+	}
+	if renewed, _ := watcher.Get(utils.ConnectionRef{UserID: "u1"}); renewed == nil || renewed.PendingHistoryID != 110 {
+		t.Fatalf("renewal lost pending notification: %+v", renewed)
+	}
+}
+
+func TestBoltWatchStore(t *testing.T) {
+	boltStore, err := utils.NewBoltTokenStore(filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+	bucket, err := boltStore.StateBucket("gmail_watches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewBoltWatchStore(bucket)
+
+	if w, err := store.Get("u1", "c1"); w != nil || err != nil {
+		t.Fatalf("missing watch: %+v %v", w, err)
+	}
+	store.Save(&WatchState{UserID: "u1", ConnectionID: "c1", EmailAddress: "alice@example.com", PendingHistoryID: 7})
+	store.Save(&WatchState{UserID: "u2", ConnectionID: "c2", EmailAddress: "bob@example.com"})
+
+	found, err := store.FindByEmail("Alice@Example.com")
+	if err != nil || len(found) != 1 || found[0].Ref() != (utils.ConnectionRef{UserID: "u1", ConnectionID: "c1"}) || found[0].PendingHistoryID != 7 {
+		t.Fatalf("find by email: %+v %v", found, err)
+	}
+	store.Delete("u1", "c1")
+	if all, _ := store.List(); len(all) != 1 || all[0].UserID != "u2" {
+		t.Fatalf("unexpected watches after delete: %+v", all)
+	}
+}
@@ -1,6 +1,16 @@
 package gmail
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"connector-demo/middleware"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/googleapi"
+)
 
 var gmailService *GmailService
 
@@ -11,16 +21,177 @@ func SetGmailService(gs *GmailService) {
 func RegisterRoutes(rg *gin.RouterGroup) {
 	gmailGroup := rg.Group("/gmail")
 
+	// 获取收件箱邮件，支持 limit、page_token、q、label、include_spam_trash
 	gmailGroup.GET("/inbox", func(c *gin.Context) {
-		userID := c.Query("user_id")
-		messages, _ := gmailService.GetInboxMessages(userID, 10)
-		c.JSON(200, gin.H{"messages": messages})
+		ref := middleware.Connection(c)
+		messages, err := gmailService.GetInboxMessages(ref, listOptions(c))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, messages)
+	})
+
+	// 按 Gmail 搜索语法和标签获取邮件，如 q=from:x has:attachment after:2024/01/01
+	gmailGroup.GET("/messages", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		messages, err := gmailService.ListMessages(ref, listOptions(c))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, messages)
 	})
 
 	gmailGroup.GET("/detail/:id", func(c *gin.Context) {
-		userID := c.Query("user_id")
+		ref := middleware.Connection(c)
 		mailID := c.Param("id")
-		messages, _ := gmailService.GetMessageDetail(userID, mailID)
+		messages, _ := gmailService.GetMessageDetail(ref, mailID)
 		c.JSON(200, gin.H{"detail": messages})
 	})
+
+	// 获取会话列表，参数与 /messages 相同
+	gmailGroup.GET("/threads", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		threads, err := gmailService.ListThreads(ref, listOptions(c))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, threads)
+	})
+
+	// 获取会话详情，邮件按时间升序，reply 为去掉引用原文后的回复内容
+	gmailGroup.GET("/threads/:id", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		thread, err := gmailService.GetThread(ref, c.Param("id"))
+		if err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, thread)
+	})
+
+	// 增量同步邮箱，返回自上次同步以来的变更事件；full=true 时全量同步
+	gmailGroup.POST("/sync", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		result, err := gmailService.Sync(ref, c.Query("full") == "true")
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, result)
+	})
+
+	// 订阅邮箱变更推送，label 可选（只推送带这些标签的邮件变更）；重复调用会续订
+	gmailGroup.POST("/watch", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		watch, err := gmailService.Watch(ref, listOptions(c).LabelIDs)
+		if err != nil {
+			status := 500
+			if errors.Is(err, ErrWatchTopicNotConfigured) {
+				status = 503
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, watch)
+	})
+
+	// 获取推送订阅，pending_history_id 不为 0 时表示收到了推送、需要调用 /sync 拉取变更
+	gmailGroup.GET("/watch", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		watch, err := gmailService.GetWatch(ref)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if watch == nil {
+			c.JSON(404, gin.H{"error": "未订阅推送"})
+			return
+		}
+		c.JSON(200, watch)
+	})
+
+	// 取消邮箱变更推送
+	gmailGroup.DELETE("/watch", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		if err := gmailService.StopWatch(ref); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"message": "已取消推送订阅"})
+	})
+
+	// 获取全部标签及邮件、会话的总数和未读数，按连接短时间缓存
+	gmailGroup.GET("/labels", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		labels, err := gmailService.ListLabels(ref)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"labels": labels})
+	})
+
+	// 获取未读数，label 为空时为收件箱
+	gmailGroup.GET("/unread", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		count, err := gmailService.GetUnreadCount(ref, c.Query("label"))
+		if err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, count)
+	})
+
+	// 下载邮件附件，part_id 为邮件详情中附件的 partId；inline=true 时图片、PDF、纯文本在浏览器中直接打开
+	gmailGroup.GET("/messages/:id/attachments/:attachmentId", func(c *gin.Context) {
+		ref := middleware.Connection(c)
+		download, err := gmailService.DownloadAttachment(ref, c.Param("id"), c.Param("attachmentId"), c.Query("part_id"))
+		if err != nil {
+			c.JSON(apiErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// 附件类型由发件人决定，只有白名单内的类型允许 inline 打开，其余强制下载
+		contentType, headers := utils.AttachmentHeaders(download.Attachment.Filename, download.Attachment.MimeType, c.Query("inline") == "true")
+		c.DataFromReader(200, download.ContentLength, contentType, download.Body, headers)
+	})
+}
+
+// apiErrorStatus 把连接器错误映射为HTTP状态码，Gmail API 的 404 原样返回
+func apiErrorStatus(err error) int {
+	if errors.Is(err, ErrAttachmentTooLarge) {
+		return 413
+	}
+	if errors.Is(err, ErrAttachmentNotFound) {
+		return 404
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == 404 {
+		return 404
+	}
+	return 500
+}
+
+// listOptions 解析列表查询参数，label 可重复或逗号分隔，limit 默认 10
+func listOptions(c *gin.Context) ListOptions {
+	opts := ListOptions{
+		MaxResults: 10,
+		PageToken:  c.Query("page_token"),
+		Query:      c.Query("q"),
+	}
+	if l, err := strconv.ParseInt(c.Query("limit"), 10, 64); err == nil && l > 0 {
+		opts.MaxResults = min(l, maxListResults)
+	}
+	for _, v := range c.QueryArray("label") {
+		for _, label := range strings.Split(v, ",") {
+			if label = strings.TrimSpace(label); label != "" {
+				opts.LabelIDs = append(opts.LabelIDs, label)
+			}
+		}
+	}
+	opts.IncludeSpamTrash, _ = strconv.ParseBool(c.Query("include_spam_trash"))
+	return opts
 }
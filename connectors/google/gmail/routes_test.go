@@ -0,0 +1,397 @@
+package gmail
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connector-demo/auth"
+	"connector-demo/middleware"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newFakeGmail 模拟 Gmail API，要求正确的 Bearer token；mux 由各测试注册接口
+func newFakeGmail(t *testing.T, mux *http.ServeMux) *GmailConnector {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer gmail-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	tm := utils.NewTokenManager()
+	tm.SaveToken("u1", auth.ProviderGmail, &utils.TokenInfo{AccessToken: "gmail-access"})
+	connector := NewGmailConnector(tm)
+	connector.BaseURL = srv.URL + "/"
+	return connector
+}
+
+func newTestRouter(connector *GmailConnector) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	SetGmailService(NewService(connector))
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if userID := c.GetHeader("X-Test-User"); userID != "" {
+			middleware.SetPrincipal(c, &middleware.Principal{Kind: middleware.PrincipalUser, UserID: userID})
+		}
+	})
+	RegisterRoutes(r.Group("/api/google"))
+	return r
+}
+
+func get(r *gin.Engine, path, userID string) *httptest.ResponseRecorder {
+	return do(r, http.MethodGet, path, userID)
+}
+
+func do(r *gin.Engine, method, path, userID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("X-Test-User", userID)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// textMessage 构造只有纯文本正文的邮件
+func textMessage(id, subject, body string) map[string]any {
+	return map[string]any{
+		"id": id, "threadId": "t-" + id, "labelIds": []string{"INBOX"},
+		"payload": map[string]any{
+			"mimeType": "text/plain",
+			"headers":  []map[string]string{{"name": "Subject", "value": subject}},
+			"body":     map[string]any{"data": base64.URLEncoding.EncodeToString([]byte(body))},
+		},
+	}
+}
+
+func TestRoutes_ListMessages(t *testing.T) {
+	var lastQuery map[string][]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gmail/v1/users/me/messages", func(w http.ResponseWriter, r *http.Request) {
+		lastQuery = r.URL.Query()
+		if r.URL.Query().Get("pageToken") == "page-2" {
+			json.NewEncoder(w).Encode(map[string]any{"messages": []map[string]string{{"id": "m3"}}, "resultSizeEstimate": 3})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"messages":           []map[string]string{{"id": "m1"}, {"id": "m2"}},
+			"nextPageToken":      "page-2",
+			"resultSizeEstimate": 3,
+		})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		json.NewEncoder(w).Encode(textMessage(id, "Subject "+id, "body "+id))
+	})
+	r := newTestRouter(newFakeGmail(t, mux))
+
+	var page MessageList
+	w := get(r, "/api/google/gmail/inbox?limit=2", "u1")
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != http.StatusOK || len(page.Messages) != 2 || page.NextPageToken != "page-2" || page.ResultSizeEstimate != 3 {
+		t.Fatalf("inbox: %d %s", w.Code, w.Body.String())
+	}
+	if page.Messages[0].Subject != "Subject m1" || page.Messages[0].Data != "body m1" {
+		t.Fatalf("unexpected message: %+v", page.Messages[0])
+	}
+	if got := fmt.Sprint(lastQuery["labelIds"], lastQuery["maxResults"], lastQuery["includeSpamTrash"]); got != "[INBOX] [2] [false]" {
+		t.Fatalf("unexpected inbox query: %s", got)
+	}
+
+	page = MessageList{}
+	w = get(r, "/api/google/gmail/messages?q=from:alice+has:attachment&label=Label_1,UNREAD&include_spam_trash=true&page_token=page-2&limit=1000", "u1")
+	json.Unmarshal(w.Body.Bytes(), &page)
+	if w.Code != http.StatusOK || len(page.Messages) != 1 || page.NextPageToken != "" {
+		t.Fatalf("messages: %d %s", w.Code, w.Body.String())
+	}
+	got := fmt.Sprint(lastQuery["q"], lastQuery["labelIds"], lastQuery["includeSpamTrash"], lastQuery["pageToken"], lastQuery["maxResults"])
+	if got != "[from:alice has:attachment] [Label_1 UNREAD] [true] [page-2] [500]" {
+		t.Fatalf("unexpected search query: %s", got)
+	}
+
+	if w := get(r, "/api/google/gmail/inbox", "u2"); w.Code != http.StatusInternalServerError {
+		t.Fatalf("missing token: expected 500, got %d", w.Code)
+	}
+}
+
+func TestRoutes_DownloadAttachment(t *testing.T) {
+	invoice := "%PDF-1.4 invoice"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gmail/v1/users/me/messages/m1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "m1",
+			"payload": map[string]any{
+				"mimeType": "multipart/mixed",
+				"parts": []map[string]any{
+					{"mimeType": "text/plain", "body": map[string]any{"data": base64.URLEncoding.EncodeToString([]byte("see attached"))}},
+					{"mimeType": "application/pdf", "filename": "发票 2024.pdf", "body": map[string]any{"attachmentId": "att-1", "size": len(invoice)}},
+					{"mimeType": "video/mp4", "filename": "big.mp4", "body": map[string]any{"attachmentId": "att-big", "size": 50 << 20}},
+					{"mimeType": "text/html", "filename": "invite.html", "body": map[string]any{"attachmentId": "att-html", "size": 25}},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages/m1/attachments/att-1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"size": len(invoice), "data": base64.URLEncoding.EncodeToString([]byte(invoice))})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages/m1/attachments/att-html", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"size": 25, "data": base64.URLEncoding.EncodeToString([]byte("<script>alert(1)</script>"))})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages/m1/attachments/att-big", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("attachment over the size limit should not be fetched")
+	})
+	connector := newFakeGmail(t, mux)
+	r := newTestRouter(connector)
+
+	w := get(r, "/api/google/gmail/messages/m1/attachments/att-1", "u1")
+	if w.Code != http.StatusOK || w.Body.String() != invoice {
+		t.Fatalf("download: %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != "attachment; filename*=utf-8''%E5%8F%91%E7%A5%A8%202024.pdf" {
+		t.Fatalf("unexpected Content-Disposition: %s", cd)
+	}
+	if w.Header().Get("Content-Length") != fmt.Sprint(len(invoice)) {
+		t.Fatalf("unexpected Content-Length: %v", w.Header())
+	}
+
+	// 发件人声明的 text/html 附件即使要求 inline 也强制下载
+	w = get(r, "/api/google/gmail/messages/m1/attachments/att-html?inline=true", "u1")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" ||
+		!strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment;") || w.Header().Get("Content-Security-Policy") != "sandbox" {
+		t.Fatalf("html attachment must be forced to download: %d %v", w.Code, w.Header())
+	}
+
+	if w := get(r, "/api/google/gmail/messages/m1/attachments/att-big", "u1"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized attachment: expected 413, got %d", w.Code)
+	}
+	if w := get(r, "/api/google/gmail/messages/m2/attachments/att-1", "u1"); w.Code != http.StatusNotFound {
+		t.Fatalf("missing message: expected 404, got %d", w.Code)
+	}
+
+	// 上限可配置，<= 0 表示不限制
+	gmailService.SetMaxAttachmentSize(4)
+	if w := get(r, "/api/google/gmail/messages/m1/attachments/att-1", "u1"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("configured limit: expected 413, got %d", w.Code)
+	}
+}
+
+func TestRoutes_DownloadAttachmentWithChangedID(t *testing.T) {
+	report := "quarterly report"
+	mux := http.NewServeMux()
+	// 每次获取邮件 Gmail 都返回新的 attachmentId，partId 不变
+	mux.HandleFunc("/gmail/v1/users/me/messages/m1", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"id": "m1",
+			"payload": map[string]any{
+				"mimeType": "multipart/mixed",
+				"partId":   "",
+				"parts": []map[string]any{
+					{"partId": "0", "mimeType": "text/plain", "body": map[string]any{"data": base64.URLEncoding.EncodeToString([]byte("see attached"))}},
+					{"partId": "1", "mimeType": "text/csv", "filename": "report.csv", "body": map[string]any{"attachmentId": "att-fresh", "size": len(report)}},
+					{"partId": "2", "mimeType": "video/mp4", "filename": "big.mp4", "body": map[string]any{"attachmentId": "att-big-fresh", "size": 50 << 20}},
+					{"partId": "3", "mimeType": "text/plain", "filename": "note.txt", "body": map[string]any{"data": base64.URLEncoding.EncodeToString([]byte("hi")), "size": 2}},
+				},
+			},
+		})
+	})
+	for _, id := range []string{"att-fresh", "att-stale"} {
+		mux.HandleFunc("/gmail/v1/users/me/messages/m1/attachments/"+id, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{"size": len(report), "data": base64.URLEncoding.EncodeToString([]byte(report))})
+		})
+	}
+	mux.HandleFunc("/gmail/v1/users/me/messages/m1/attachments/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected attachment request: %s", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := newTestRouter(newFakeGmail(t, mux))
+
+	// 客户端从 /detail 拿到的 attachmentId 已经过期，按 part_id 定位
+	w := get(r, "/api/google/gmail/messages/m1/attachments/att-stale?part_id=1", "u1")
+	if w.Code != http.StatusOK || w.Body.String() != report || !strings.Contains(w.Header().Get("Content-Disposition"), "report.csv") {
+		t.Fatalf("download by part: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w := get(r, "/api/google/gmail/messages/m1/attachments/att-stale?part_id=2", "u1"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized part: expected 413 before download, got %d", w.Code)
+	}
+	if w := get(r, "/api/google/gmail/messages/m1/attachments/x?part_id=3", "u1"); w.Code != http.StatusOK || w.Body.String() != "hi" {
+		t.Fatalf("embedded part: %d %q", w.Code, w.Body.String())
+	}
+	if w := get(r, "/api/google/gmail/messages/m1/attachments/x?part_id=9", "u1"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown part: expected 404, got %d", w.Code)
+	}
+
+	// 没有 part_id 时过期的ID无法对应到附件，即使大小相同也不沿用其文件名和类型，按通用二进制文件下载
+	w = get(r, "/api/google/gmail/messages/m1/attachments/att-stale?inline=true", "u1")
+	if w.Code != http.StatusOK || w.Body.String() != report || w.Header().Get("Content-Type") != "application/octet-stream" ||
+		!strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") || strings.Contains(w.Header().Get("Content-Disposition"), "report.csv") {
+		t.Fatalf("download by stale id: %d %v", w.Code, w.Header())
+	}
+}
+
+func TestRoutes_Threads(t *testing.T) {
+	message := func(id string, internalDate int64, from, to, body string) map[string]any {
+		return map[string]any{
+			"id": id, "threadId": "t1", "internalDate": fmt.Sprint(internalDate), "labelIds": []string{"INBOX"}, "snippet": "snippet " + id,
+			"payload": map[string]any{
+				"mimeType": "text/plain",
+				"headers": []map[string]string{
+					{"name": "Subject", "value": "Release"}, {"name": "From", "value": from}, {"name": "To", "value": to},
+				},
+				"body": map[string]any{"data": base64.URLEncoding.EncodeToString([]byte(body))},
+			},
+		}
+	}
+	thread := map[string]any{
+		"id": "t1", "historyId": "900",
+		// Gmail 返回的顺序不保证按时间
+		"messages": []map[string]any{
+			message("m2", 2000, "Bob <bob@example.com>", "Alice <alice@example.com>, Carol <carol@example.com>", "Yes.\n\nOn Mon, May 6, 2024 Alice <alice@example.com> wrote:\n> Ship today?"),
+			message("m1", 1000, "Alice <alice@example.com>", "bob@example.com", "Ship today?"),
+		},
+	}
+
+	var listQuery, getQuery map[string][]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gmail/v1/users/me/threads", func(w http.ResponseWriter, r *http.Request) {
+		listQuery = r.URL.Query()
+		json.NewEncoder(w).Encode(map[string]any{"threads": []map[string]string{{"id": "t1"}}, "nextPageToken": "threads-2", "resultSizeEstimate": 7})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/threads/t1", func(w http.ResponseWriter, r *http.Request) {
+		getQuery = r.URL.Query()
+		json.NewEncoder(w).Encode(thread)
+	})
+	r := newTestRouter(newFakeGmail(t, mux))
+
+	var list ThreadList
+	w := get(r, "/api/google/gmail/threads?q=subject:release&limit=5", "u1")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if w.Code != http.StatusOK || len(list.Threads) != 1 || list.NextPageToken != "threads-2" {
+		t.Fatalf("threads: %d %s", w.Code, w.Body.String())
+	}
+	if got := fmt.Sprint(listQuery["q"], listQuery["maxResults"], getQuery["format"]); got != "[subject:release] [5] [metadata]" {
+		t.Fatalf("unexpected queries: %s", got)
+	}
+	summary := list.Threads[0]
+	if summary.Subject != "Release" || summary.MessageCount != 2 || summary.Messages != nil || summary.Snippet != "snippet m2" {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+
+	var detail Thread
+	w = get(r, "/api/google/gmail/threads/t1", "u1")
+	json.Unmarshal(w.Body.Bytes(), &detail)
+	if w.Code != http.StatusOK || len(detail.Messages) != 2 || detail.HistoryID != 900 {
+		t.Fatalf("thread: %d %s", w.Code, w.Body.String())
+	}
+	if detail.Messages[0].ID != "m1" || detail.Messages[1].ID != "m2" || detail.Messages[1].Reply != "Yes." || detail.Messages[0].Reply != "Ship today?" {
+		t.Fatalf("unexpected messages: %+v", detail.Messages)
+	}
+	var participants []string
+	for _, p := range detail.Participants {
+		participants = append(participants, p.Name+" <"+p.Email+">")
+	}
+	if got := fmt.Sprint(participants); got != "[Alice <alice@example.com> Bob <bob@example.com> Carol <carol@example.com>]" {
+		t.Fatalf("unexpected participants: %s", got)
+	}
+	if !detail.LastMessageAt.Equal(time.UnixMilli(2000)) {
+		t.Fatalf("unexpected last message time: %v", detail.LastMessageAt)
+	}
+
+	if w := get(r, "/api/google/gmail/threads/missing", "u1"); w.Code != http.StatusNotFound {
+		t.Fatalf("missing thread: expected 404, got %d", w.Code)
+	}
+}
+
+func TestRoutes_LabelsAndUnread(t *testing.T) {
+	labels := map[string]map[string]any{
+		"INBOX":   {"id": "INBOX", "name": "INBOX", "type": "system", "messagesTotal": 120, "messagesUnread": 7, "threadsTotal": 80, "threadsUnread": 5},
+		"SENT":    {"id": "SENT", "name": "SENT", "type": "system", "messagesTotal": 40},
+		"Label_2": {"id": "Label_2", "name": "invoices", "type": "user", "messagesTotal": 12, "messagesUnread": 3, "threadsTotal": 10, "threadsUnread": 2},
+		"Label_1": {"id": "Label_1", "name": "Contracts", "type": "user", "messagesTotal": 4},
+	}
+	var mu sync.Mutex
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gmail/v1/users/me/labels", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		var list []map[string]string
+		for id, l := range labels {
+			list = append(list, map[string]string{"id": id, "name": l["name"].(string), "type": l["type"].(string)})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"labels": list})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/labels/{id}", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		l, ok := labels[r.PathValue("id")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found."}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(l)
+	})
+	r := newTestRouter(newFakeGmail(t, mux))
+
+	// 没有标签列表缓存时单独查询收件箱
+	var unread UnreadCount
+	w := get(r, "/api/google/gmail/unread", "u1")
+	json.Unmarshal(w.Body.Bytes(), &unread)
+	if w.Code != http.StatusOK || unread != (UnreadCount{LabelID: "INBOX", Messages: 7, Threads: 5}) || requests != 1 {
+		t.Fatalf("inbox unread: %d %s (%d requests)", w.Code, w.Body.String(), requests)
+	}
+	get(r, "/api/google/gmail/unread", "u1")
+	if requests != 1 {
+		t.Fatalf("unread count not cached: %d requests", requests)
+	}
+
+	var list struct {
+		Labels []Label `json:"labels"`
+	}
+	w = get(r, "/api/google/gmail/labels", "u1")
+	json.Unmarshal(w.Body.Bytes(), &list)
+	var names []string
+	for _, l := range list.Labels {
+		names = append(names, l.Name)
+	}
+	if w.Code != http.StatusOK || fmt.Sprint(names) != "[INBOX SENT Contracts invoices]" || requests != 6 {
+		t.Fatalf("labels: %d %s (%d requests)", w.Code, w.Body.String(), requests)
+	}
+	if l := list.Labels[3]; l.MessagesTotal != 12 || l.MessagesUnread != 3 || l.ThreadsUnread != 2 {
+		t.Fatalf("unexpected label counts: %+v", l)
+	}
+
+	// 标签列表和任意标签的未读数都从缓存返回
+	get(r, "/api/google/gmail/labels", "u1")
+	w = get(r, "/api/google/gmail/unread?label=Label_2", "u1")
+	json.Unmarshal(w.Body.Bytes(), &unread)
+	if unread != (UnreadCount{LabelID: "Label_2", Messages: 3, Threads: 2}) || requests != 6 {
+		t.Fatalf("cached label unread: %s (%d requests)", w.Body.String(), requests)
+	}
+	if w := get(r, "/api/google/gmail/unread?label=Label_9", "u1"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown label: expected 404, got %d", w.Code)
+	}
+
+	// 缓存过期后重新查询
+	gmailService.SetLabelCacheTTL(0)
+	get(r, "/api/google/gmail/labels", "u1")
+	if requests != 12 {
+		t.Fatalf("expected refetch without cache, got %d requests", requests)
+	}
+}
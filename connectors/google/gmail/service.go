@@ -1,47 +1,199 @@
 package gmail
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"connector-demo/utils"
+)
 
 // Service Gmail数据处理接口
 type GmailService struct {
 	connector *GmailConnector
+	// maxAttachmentSize 允许下载的附件大小上限（字节），<= 0 表示不限制
+	maxAttachmentSize int64
+	syncer            *Syncer
+	watcher           *Watcher
+	labels            *labelCache
+	// changeHandler 推送触发的同步完成后的回调
+	changeHandler ChangeHandler
 }
 
+// ChangeHandler 处理推送触发的同步结果。推送触发的同步会推进同步进度，
+// 这些变更不会再出现在之后的 Sync 结果中，需要镜像邮箱的调用方应在这里处理
+type ChangeHandler func(ref utils.ConnectionRef, result *SyncResult)
+
 // NewService 创建新的Gmail服务
 func NewService(connector *GmailConnector) *GmailService {
 	return &GmailService{
-		connector: connector,
+		connector:         connector,
+		maxAttachmentSize: DefaultMaxAttachmentSize,
+		syncer:            NewSyncer(connector, NewMemorySyncStateStore()),
+		watcher:           NewWatcher(connector, NewMemoryWatchStore(), ""),
+		labels:            newLabelCache(DefaultLabelCacheTTL),
 	}
 }
 
-// GetInboxMessages 获取收件箱邮件
-func (s *GmailService) GetInboxMessages(userID string, limit int64) ([]Message, error) {
-	messages, err := s.connector.ListMessages(userID, limit)
+// SetLabelCacheTTL 设置标签统计的缓存时间，<= 0 表示不缓存
+func (s *GmailService) SetLabelCacheTTL(ttl time.Duration) {
+	s.labels = newLabelCache(ttl)
+}
+
+// SetWatchTopic 设置接收推送的 Pub/Sub topic（projects/{project}/topics/{topic}）
+func (s *GmailService) SetWatchTopic(topic string) {
+	s.watcher.topic = topic
+}
+
+// SetWatchStore 使用指定的推送订阅存储（默认内存存储）
+func (s *GmailService) SetWatchStore(store WatchStore) {
+	s.watcher = NewWatcher(s.connector, store, s.watcher.topic)
+}
+
+// SetChangeHandler 设置推送触发的同步完成后的回调
+func (s *GmailService) SetChangeHandler(handler ChangeHandler) {
+	s.changeHandler = handler
+}
+
+// Watcher 推送订阅管理，用于启动后台续订
+func (s *GmailService) Watcher() *Watcher {
+	return s.watcher
+}
+
+// SetSyncStateStore 使用指定的同步进度存储（默认内存存储）
+func (s *GmailService) SetSyncStateStore(store SyncStateStore) {
+	s.syncer = NewSyncer(s.connector, store)
+}
+
+// SetMaxAttachmentSize 设置允许下载的附件大小上限（字节），<= 0 表示不限制
+func (s *GmailService) SetMaxAttachmentSize(size int64) {
+	s.maxAttachmentSize = size
+}
+
+// InboxLabel 收件箱的系统标签
+const InboxLabel = "INBOX"
+
+// GetInboxMessages 获取收件箱邮件，opts 中的标签会与 INBOX 同时生效
+func (s *GmailService) GetInboxMessages(ref utils.ConnectionRef, opts ListOptions) (*MessageList, error) {
+	if !slices.Contains(opts.LabelIDs, InboxLabel) {
+		opts.LabelIDs = append([]string{InboxLabel}, opts.LabelIDs...)
+	}
+	messages, err := s.connector.ListMessages(ref, opts)
 	if err != nil {
 		return nil, fmt.Errorf("获取收件箱邮件失败: %v", err)
 	}
 	return messages, nil
 }
 
+// ListMessages 按搜索条件和标签获取邮件
+func (s *GmailService) ListMessages(ref utils.ConnectionRef, opts ListOptions) (*MessageList, error) {
+	return s.connector.ListMessages(ref, opts)
+}
+
 // GetMessageDetail 获取邮件详情
-func (s *GmailService) GetMessageDetail(userID string, messageID string) (*Message, error) {
-	message, err := s.connector.GetMessage(userID, messageID)
+func (s *GmailService) GetMessageDetail(ref utils.ConnectionRef, messageID string) (*Message, error) {
+	message, err := s.connector.GetMessage(ref, messageID)
 	if err != nil {
 		return nil, fmt.Errorf("获取邮件详情失败: %v", err)
 	}
 	return message, nil
 }
 
-// GetUnreadCount 获取未读邮件数量
-func (s *GmailService) GetUnreadCount(userID string) (int64, error) {
-	// 这里可以实现获取未读邮件数量的逻辑
-	// 暂时返回0，后续可以扩展
-	return 0, nil
+// ListThreads 获取会话列表
+func (s *GmailService) ListThreads(ref utils.ConnectionRef, opts ListOptions) (*ThreadList, error) {
+	return s.connector.ListThreads(ref, opts)
+}
+
+// GetThread 获取会话详情
+func (s *GmailService) GetThread(ref utils.ConnectionRef, threadID string) (*Thread, error) {
+	return s.connector.GetThread(ref, threadID)
+}
+
+// DownloadAttachment 下载邮件附件，partID 可选，超过大小上限时返回 ErrAttachmentTooLarge
+func (s *GmailService) DownloadAttachment(ref utils.ConnectionRef, messageID, attachmentID, partID string) (*AttachmentDownload, error) {
+	return s.connector.GetAttachment(ref, messageID, attachmentID, partID, s.maxAttachmentSize)
+}
+
+// Sync 增量同步邮箱，返回自上次同步以来的变更事件，并清除已同步的推送标记
+func (s *GmailService) Sync(ref utils.ConnectionRef, full bool) (*SyncResult, error) {
+	result, err := s.syncer.Sync(ref, full)
+	if err != nil {
+		return nil, err
+	}
+	if result.NextPageToken != "" {
+		// 全量同步还没有结束，保留推送标记
+		return result, nil
+	}
+	if err := s.watcher.clearPending(ref, result.HistoryID); err != nil {
+		log.Printf("清除Gmail推送标记失败(user=%s connection=%s): %v", ref.UserID, ref.ConnectionID, err)
+	}
+	return result, nil
+}
+
+// Watch 订阅邮箱变更推送
+func (s *GmailService) Watch(ref utils.ConnectionRef, labelIDs []string) (*WatchState, error) {
+	return s.watcher.Watch(ref, labelIDs)
+}
+
+// GetWatch 获取推送订阅，没有订阅时返回 nil, nil
+func (s *GmailService) GetWatch(ref utils.ConnectionRef) (*WatchState, error) {
+	return s.watcher.Get(ref)
+}
+
+// StopWatch 取消邮箱变更推送
+func (s *GmailService) StopWatch(ref utils.ConnectionRef) error {
+	return s.watcher.Stop(ref)
+}
+
+// ListLabels 获取全部标签及邮件、会话统计，按连接短时间缓存
+func (s *GmailService) ListLabels(ref utils.ConnectionRef) ([]Label, error) {
+	key, err := s.connector.connectionKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	if labels, ok := s.labels.get(key); ok {
+		return labels, nil
+	}
+	labels, err := s.connector.ListLabels(ref)
+	if err != nil {
+		return nil, err
+	}
+	s.labels.put(key, labels)
+	return labels, nil
+}
+
+// GetUnreadCount 获取标签下的未读邮件数和未读会话数，labelID 为空时为收件箱；
+// 优先使用缓存的标签统计，缓存中没有时单独查询该标签
+func (s *GmailService) GetUnreadCount(ref utils.ConnectionRef, labelID string) (*UnreadCount, error) {
+	if labelID == "" {
+		labelID = InboxLabel
+	}
+	key, err := s.connector.connectionKey(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cacheKey := range []string{key, key + "#" + labelID} {
+		labels, _ := s.labels.get(cacheKey)
+		for _, l := range labels {
+			if l.ID == labelID {
+				return &UnreadCount{LabelID: l.ID, Messages: l.MessagesUnread, Threads: l.ThreadsUnread}, nil
+			}
+		}
+	}
+
+	label, err := s.connector.GetLabel(ref, labelID)
+	if err != nil {
+		return nil, fmt.Errorf("获取未读数失败: %w", err)
+	}
+	s.labels.put(key+"#"+labelID, []Label{*label})
+	return &UnreadCount{LabelID: label.ID, Messages: label.MessagesUnread, Threads: label.ThreadsUnread}, nil
 }
 
 // TestConnection 测试Gmail连接
-func (s *GmailService) TestConnection(userID string) bool {
-	_, err := s.connector.GetUserInfo(userID)
+func (s *GmailService) TestConnection(ref utils.ConnectionRef) bool {
+	_, err := s.connector.GetUserInfo(ref)
 	if err != nil {
 		return false
 	}
@@ -0,0 +1,331 @@
+package gmail
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"connector-demo/utils"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ChangeType 邮箱变更类型
+type ChangeType string
+
+const (
+	ChangeMessageAdded   ChangeType = "message_added"
+	ChangeMessageDeleted ChangeType = "message_deleted"
+	ChangeLabelsAdded    ChangeType = "labels_added"
+	ChangeLabelsRemoved  ChangeType = "labels_removed"
+)
+
+// ChangeEvent 一次邮箱变更
+type ChangeEvent struct {
+	Type      ChangeType `json:"type"`
+	MessageID string     `json:"messageId"`
+	ThreadID  string     `json:"threadId,omitempty"`
+	// LabelIDs 新增邮件为邮件当前的标签，标签变更为本次增加或移除的标签
+	LabelIDs []string `json:"labelIds,omitempty"`
+	// HistoryID 变更所在的历史记录ID，全量同步时为同步开始时邮箱的 historyId
+	HistoryID uint64 `json:"historyId"`
+}
+
+// SyncState 一个连接的同步进度
+type SyncState struct {
+	// HistoryID 上次同步到的邮箱 historyId，下次从这里继续
+	HistoryID  uint64    `json:"historyId"`
+	LastSyncAt time.Time `json:"lastSyncAt"`
+	// FullSyncHistoryID、FullSyncPageToken 未完成的全量同步：开始时邮箱的 historyId 和下一页的 messages.list 游标。
+	// 全量同步分多次调用完成，最后一页之后才把 HistoryID 推进到 FullSyncHistoryID
+	FullSyncHistoryID uint64 `json:"fullSyncHistoryId,omitempty"`
+	FullSyncPageToken string `json:"fullSyncPageToken,omitempty"`
+}
+
+// SyncResult 一次同步的结果。Full 为 true 时 Events 是邮箱中邮件的 message_added 快照（带当前标签），
+// 每次调用最多返回 fullSyncPages 页；NextPageToken 不为空表示快照还没有结束，再次调用同步会从这里继续。
+// 调用方应累积到 NextPageToken 为空后再据此重建镜像，快照中没有的邮件视为已删除
+type SyncResult struct {
+	Full      bool          `json:"full"`
+	Events    []ChangeEvent `json:"events"`
+	HistoryID uint64        `json:"historyId"`
+	// NextPageToken 全量同步的续传游标（messages.list 的分页游标），与 HistoryID（全量同步开始时的 historyId）一起保存在同步进度中
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
+// SyncStateStore 同步进度存储
+type SyncStateStore interface {
+	// Get 获取同步进度，没有记录时返回 nil, nil
+	Get(key string) (*SyncState, error)
+	// Save 保存同步进度
+	Save(key string, state *SyncState) error
+}
+
+// MemorySyncStateStore 内存同步进度存储，进程重启后下一次同步为全量同步
+type MemorySyncStateStore struct {
+	states map[string]SyncState
+	mu     sync.RWMutex
+}
+
+func NewMemorySyncStateStore() *MemorySyncStateStore {
+	return &MemorySyncStateStore{states: make(map[string]SyncState)}
+}
+
+func (s *MemorySyncStateStore) Get(key string) (*SyncState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (s *MemorySyncStateStore) Save(key string, state *SyncState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[key] = *state
+	return nil
+}
+
+// BoltSyncStateStore 持久化的同步进度存储，进程重启后继续增量同步
+type BoltSyncStateStore struct {
+	bucket *utils.BoltStateBucket
+}
+
+func NewBoltSyncStateStore(bucket *utils.BoltStateBucket) *BoltSyncStateStore {
+	return &BoltSyncStateStore{bucket: bucket}
+}
+
+func (s *BoltSyncStateStore) Get(key string) (*SyncState, error) {
+	state := &SyncState{}
+	found, err := s.bucket.Get(key, state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *BoltSyncStateStore) Save(key string, state *SyncState) error {
+	return s.bucket.Put(key, state)
+}
+
+// historyTypes 同步关心的历史记录类型
+var historyTypes = []string{"messageAdded", "messageDeleted", "labelAdded", "labelRemoved"}
+
+// Syncer 基于 History API 增量同步邮箱，生成变更事件
+type Syncer struct {
+	connector *GmailConnector
+	store     SyncStateStore
+	// pageSize 每次请求的条数
+	pageSize int64
+	// fullSyncPages 全量同步每次调用最多处理的页数，避免大邮箱在一个请求里超时或耗尽配额
+	fullSyncPages int
+	locks         sync.Map // 同步进度键 -> *sync.Mutex，同一连接的同步串行执行
+}
+
+// DefaultFullSyncPages 全量同步每次调用默认处理的页数。每封邮件还要单独获取标签，
+// 2 页（1000 封）大约消耗 5000 个配额单位
+const DefaultFullSyncPages = 2
+
+func NewSyncer(connector *GmailConnector, store SyncStateStore) *Syncer {
+	return &Syncer{connector: connector, store: store, pageSize: maxListResults, fullSyncPages: DefaultFullSyncPages}
+}
+
+// Sync 同步连接的邮箱。没有同步记录、full=true 或 historyId 已过期（404）时全量同步；
+// 有未完成的全量同步时从上次的游标继续，full=true 时重新开始
+func (s *Syncer) Sync(ref utils.ConnectionRef, full bool) (*SyncResult, error) {
+	token, service, err := s.connector.getTokenService(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	key := strings.Join([]string{ref.UserID, token.ConnectionID}, "/")
+	lock, _ := s.locks.LoadOrStore(key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	state, err := s.store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("读取同步进度失败: %v", err)
+	}
+
+	var result *SyncResult
+	switch {
+	case state != nil && state.FullSyncHistoryID != 0 && !full:
+		if result, err = s.fullSync(service, state.FullSyncHistoryID, state.FullSyncPageToken); err != nil {
+			return nil, err
+		}
+	case state != nil && state.HistoryID != 0 && !full:
+		result, err = s.incremental(service, state.HistoryID)
+		if isHistoryExpired(err) {
+			log.Printf("Gmail historyId %d 已过期，全量同步: %s", state.HistoryID, key)
+			result, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if result == nil {
+		if result, err = s.fullSync(service, 0, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	next := &SyncState{HistoryID: result.HistoryID, LastSyncAt: time.Now()}
+	if result.NextPageToken != "" {
+		// 全量同步还没有结束，保留原来的进度，记下续传位置
+		next.HistoryID = 0
+		if state != nil {
+			next.HistoryID = state.HistoryID
+		}
+		next.FullSyncHistoryID, next.FullSyncPageToken = result.HistoryID, result.NextPageToken
+	}
+	if err := s.store.Save(key, next); err != nil {
+		return nil, fmt.Errorf("保存同步进度失败: %v", err)
+	}
+	return result, nil
+}
+
+// incremental 读取 startHistoryID 之后的全部历史记录
+func (s *Syncer) incremental(service *gmail.Service, startHistoryID uint64) (*SyncResult, error) {
+	result := &SyncResult{Events: []ChangeEvent{}, HistoryID: startHistoryID}
+	pageToken := ""
+	for {
+		call := service.Users.History.List("me").StartHistoryId(startHistoryID).
+			HistoryTypes(historyTypes...).MaxResults(s.pageSize)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return nil, fmt.Errorf("获取邮箱变更失败: %w", err)
+		}
+		for _, h := range resp.History {
+			result.Events = append(result.Events, historyEvents(h)...)
+		}
+		if resp.HistoryId > result.HistoryID {
+			result.HistoryID = resp.HistoryId
+		}
+		if resp.NextPageToken == "" || resp.NextPageToken == pageToken {
+			return result, nil
+		}
+		pageToken = resp.NextPageToken
+	}
+}
+
+// fullSync 先记下当前 historyId 再列出邮件，列出期间的变更会在下次增量同步中拿到。
+// historyID 为 0 时开始新的全量同步，否则从 pageToken 继续；每次最多处理 fullSyncPages 页
+func (s *Syncer) fullSync(service *gmail.Service, historyID uint64, pageToken string) (*SyncResult, error) {
+	if historyID == 0 {
+		profile, err := service.Users.GetProfile("me").Do()
+		if err != nil {
+			return nil, fmt.Errorf("获取邮箱信息失败: %v", err)
+		}
+		historyID, pageToken = profile.HistoryId, ""
+	}
+
+	result := &SyncResult{Full: true, Events: []ChangeEvent{}, HistoryID: historyID}
+	for pages := 1; ; pages++ {
+		call := service.Users.Messages.List("me").MaxResults(s.pageSize)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		resp, err := call.Do()
+		if err != nil {
+			return nil, fmt.Errorf("获取邮件列表失败: %v", err)
+		}
+		msgs, err := minimalMessages(service, resp.Messages)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range msgs {
+			result.Events = append(result.Events, ChangeEvent{
+				Type:      ChangeMessageAdded,
+				MessageID: m.Id,
+				ThreadID:  m.ThreadId,
+				LabelIDs:  m.LabelIds,
+				HistoryID: historyID,
+			})
+		}
+		if resp.NextPageToken == "" || resp.NextPageToken == pageToken {
+			return result, nil
+		}
+		pageToken = resp.NextPageToken
+		if pages >= s.fullSyncPages {
+			result.NextPageToken = pageToken
+			return result, nil
+		}
+	}
+}
+
+// minimalMessages messages.list 只返回 id 和 threadId，逐个以 minimal 格式获取邮件的标签。
+// 列出之后被删除（404）的邮件直接跳过，删除会在下次增量同步中拿到
+func minimalMessages(service *gmail.Service, list []*gmail.Message) ([]*gmail.Message, error) {
+	msgs := make([]*gmail.Message, len(list))
+	errs := make([]error, len(list))
+	sem := make(chan struct{}, labelFetchConcurrency)
+	var wg sync.WaitGroup
+	for i, m := range list {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			full, err := service.Users.Messages.Get("me", m.Id).Format("minimal").Do()
+			if apiErr := (*googleapi.Error)(nil); errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+				return
+			}
+			if err != nil {
+				errs[i] = fmt.Errorf("获取邮件 %s 失败: %v", m.Id, err)
+				return
+			}
+			msgs[i] = full
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return slices.DeleteFunc(msgs, func(m *gmail.Message) bool { return m == nil }), nil
+}
+
+// historyEvents 把一条历史记录转换为变更事件
+func historyEvents(h *gmail.History) []ChangeEvent {
+	var events []ChangeEvent
+	add := func(t ChangeType, msg *gmail.Message, labelIDs []string) {
+		if msg == nil {
+			return
+		}
+		events = append(events, ChangeEvent{Type: t, MessageID: msg.Id, ThreadID: msg.ThreadId, LabelIDs: labelIDs, HistoryID: h.Id})
+	}
+	for _, m := range h.MessagesAdded {
+		if m.Message != nil {
+			add(ChangeMessageAdded, m.Message, m.Message.LabelIds)
+		}
+	}
+	for _, m := range h.MessagesDeleted {
+		add(ChangeMessageDeleted, m.Message, nil)
+	}
+	for _, l := range h.LabelsAdded {
+		add(ChangeLabelsAdded, l.Message, l.LabelIds)
+	}
+	for _, l := range h.LabelsRemoved {
+		add(ChangeLabelsRemoved, l.Message, l.LabelIds)
+	}
+	return events
+}
+
+// isHistoryExpired startHistoryId 过旧（通常超过一周）时 Gmail 返回 404
+func isHistoryExpired(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
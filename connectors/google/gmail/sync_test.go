@@ -0,0 +1,288 @@
+package gmail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"connector-demo/utils"
+)
+
+// fakeMailbox 模拟 Gmail 的 profile、messages.list、messages.get 和 history.list，每次变更生成一条历史记录
+type fakeMailbox struct {
+	mu        sync.Mutex
+	historyID uint64
+	messages  map[string][]string // id -> labelIds
+	history   []map[string]any
+	// minHistoryID 早于它的 startHistoryId 视为过期，返回 404
+	minHistoryID uint64
+	// failHistory 为 true 时 history.list 返回 500
+	failHistory bool
+	requests    map[string]int
+}
+
+func newFakeMailbox() *fakeMailbox {
+	return &fakeMailbox{historyID: 100, messages: make(map[string][]string), requests: make(map[string]int)}
+}
+
+func (m *fakeMailbox) record(kind, id string, labels []string) {
+	m.historyID++
+	msg := map[string]any{"id": id, "threadId": "t-" + id}
+	entry := map[string]any{"message": msg}
+	switch kind {
+	case "messagesAdded":
+		msg["labelIds"] = labels
+	case "labelsAdded", "labelsRemoved":
+		entry["labelIds"] = labels
+	}
+	m.history = append(m.history, map[string]any{"id": strconv.FormatUint(m.historyID, 10), kind: []any{entry}})
+}
+
+func (m *fakeMailbox) add(id string, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[id] = labels
+	m.record("messagesAdded", id, labels)
+}
+
+func (m *fakeMailbox) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.messages, id)
+	m.record("messagesDeleted", id, nil)
+}
+
+func (m *fakeMailbox) label(id string, added bool, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kind := "labelsRemoved"
+	current := slices.DeleteFunc(slices.Clone(m.messages[id]), func(l string) bool { return slices.Contains(labels, l) })
+	if added {
+		kind = "labelsAdded"
+		current = append(current, labels...)
+	}
+	m.messages[id] = current
+	m.record(kind, id, labels)
+}
+
+// page 按 pageToken（偏移量）分页
+func page[T any](items []T, token string, limit int) ([]T, string) {
+	offset, _ := strconv.Atoi(token)
+	end := min(offset+limit, len(items))
+	next := ""
+	if end < len(items) {
+		next = strconv.Itoa(end)
+	}
+	return items[offset:end], next
+}
+
+func (m *fakeMailbox) register(mux *http.ServeMux) {
+	mux.HandleFunc("/gmail/v1/users/me/profile", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests["profile"]++
+		json.NewEncoder(w).Encode(map[string]any{"emailAddress": "alice@example.com", "historyId": strconv.FormatUint(m.historyID, 10)})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests["messages"]++
+		var ids []string
+		for id := range m.messages {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("maxResults"))
+		ids, next := page(ids, r.URL.Query().Get("pageToken"), limit)
+		var msgs []map[string]string
+		for _, id := range ids {
+			msgs = append(msgs, map[string]string{"id": id, "threadId": "t-" + id})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"messages": msgs, "nextPageToken": next})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/messages/", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests["messages.get"]++
+		id := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+		labels, ok := m.messages[id]
+		if !ok || r.URL.Query().Get("format") != "minimal" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found."}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": id, "threadId": "t-" + id, "labelIds": labels})
+	})
+	mux.HandleFunc("/gmail/v1/users/me/history", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.requests["history"]++
+		if m.failHistory {
+			http.Error(w, `{"error":{"code":500,"message":"backend error"}}`, http.StatusInternalServerError)
+			return
+		}
+		q := r.URL.Query()
+		if got := fmt.Sprint(q["historyTypes"]); got != "[messageAdded messageDeleted labelAdded labelRemoved]" {
+			http.Error(w, "unexpected historyTypes "+got, http.StatusBadRequest)
+			return
+		}
+		start, _ := strconv.ParseUint(q.Get("startHistoryId"), 10, 64)
+		if start < m.minHistoryID {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"Requested entity was not found."}}`))
+			return
+		}
+		var records []map[string]any
+		for _, h := range m.history {
+			if id, _ := strconv.ParseUint(h["id"].(string), 10, 64); id > start {
+				records = append(records, h)
+			}
+		}
+		limit, _ := strconv.Atoi(q.Get("maxResults"))
+		records, next := page(records, q.Get("pageToken"), limit)
+		json.NewEncoder(w).Encode(map[string]any{"history": records, "nextPageToken": next, "historyId": strconv.FormatUint(m.historyID, 10)})
+	})
+}
+
+func syncSummary(events []ChangeEvent) []string {
+	var out []string
+	for _, e := range events {
+		s := fmt.Sprintf("%s %s", e.Type, e.MessageID)
+		if len(e.LabelIDs) > 0 {
+			s += fmt.Sprint(e.LabelIDs)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestSyncer_History(t *testing.T) {
+	mailbox := newFakeMailbox()
+	mailbox.add("m1", "INBOX")
+	mailbox.add("m2", "INBOX", "UNREAD")
+	mailbox.add("m3", "SENT")
+	mux := http.NewServeMux()
+	mailbox.register(mux)
+	syncer := NewSyncer(newFakeGmail(t, mux), NewMemorySyncStateStore())
+	syncer.pageSize = 2
+	ref := utils.ConnectionRef{UserID: "u1"}
+
+	// 首次同步为全量同步
+	result, err := syncer.Sync(ref, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(syncSummary(result.Events)); !result.Full || result.HistoryID != 103 || got != "[message_added m1[INBOX] message_added m2[INBOX UNREAD] message_added m3[SENT]]" {
+		t.Fatalf("initial sync: full=%v history=%d %s", result.Full, result.HistoryID, got)
+	}
+
+	// 增量同步只返回之后的变更，跨多页
+	mailbox.add("m4", "INBOX", "UNREAD")
+	mailbox.label("m2", false, "UNREAD")
+	mailbox.label("m1", true, "Label_7")
+	mailbox.remove("m3")
+	result, err = syncer.Sync(ref, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[message_added m4[INBOX UNREAD] labels_removed m2[UNREAD] labels_added m1[Label_7] message_deleted m3]"
+	if got := fmt.Sprint(syncSummary(result.Events)); result.Full || result.HistoryID != 107 || got != want {
+		t.Fatalf("incremental sync: full=%v history=%d %s", result.Full, result.HistoryID, got)
+	}
+	if result.Events[0].ThreadID != "t-m4" || result.Events[0].HistoryID != 104 {
+		t.Fatalf("unexpected event: %+v", result.Events[0])
+	}
+
+	// 没有变化时没有事件
+	result, err = syncer.Sync(ref, false)
+	if err != nil || result.Full || len(result.Events) != 0 || result.HistoryID != 107 {
+		t.Fatalf("no-op sync: %v %+v", err, result)
+	}
+
+	// historyId 过期时回退到全量同步
+	mailbox.add("m5", "INBOX")
+	mailbox.mu.Lock()
+	mailbox.minHistoryID = 200
+	mailbox.mu.Unlock()
+	result, err = syncer.Sync(ref, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(syncSummary(result.Events)); !result.Full || result.HistoryID != 108 || got != "[message_added m1[INBOX Label_7] message_added m2[INBOX] message_added m4[INBOX UNREAD] message_added m5[INBOX]]" {
+		t.Fatalf("expired history: full=%v history=%d %s", result.Full, result.HistoryID, got)
+	}
+	if mailbox.requests["profile"] != 2 {
+		t.Fatalf("expected 2 full syncs, got %d", mailbox.requests["profile"])
+	}
+}
+
+func TestSyncer_ResumableFullSync(t *testing.T) {
+	mailbox := newFakeMailbox()
+	for _, id := range []string{"m1", "m2", "m3", "m4", "m5"} {
+		mailbox.add(id, "INBOX")
+	}
+	mux := http.NewServeMux()
+	mailbox.register(mux)
+	store := NewMemorySyncStateStore()
+	syncer := NewSyncer(newFakeGmail(t, mux), store)
+	syncer.pageSize = 2
+	syncer.fullSyncPages = 1
+	ref := utils.ConnectionRef{UserID: "u1"}
+	savedState := func() SyncState {
+		for _, state := range store.states {
+			return state
+		}
+		return SyncState{}
+	}
+
+	// 每次调用只处理一页，续传游标保存在同步进度中，historyId 在最后一页之前不推进
+	var pages []string
+	for i := 0; ; i++ {
+		if i == 1 {
+			mailbox.add("m6", "INBOX") // 全量同步期间的新邮件
+		}
+		result, err := syncer.Sync(ref, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Full || result.HistoryID != 105 {
+			t.Fatalf("page %d: full=%v history=%d", i, result.Full, result.HistoryID)
+		}
+		pages = append(pages, fmt.Sprint(syncSummary(result.Events)))
+		state := savedState()
+		if result.NextPageToken == "" {
+			if state.HistoryID != 105 || state.FullSyncHistoryID != 0 || state.FullSyncPageToken != "" {
+				t.Fatalf("final state: %+v", state)
+			}
+			break
+		}
+		if state.HistoryID != 0 || state.FullSyncHistoryID != 105 || state.FullSyncPageToken != result.NextPageToken {
+			t.Fatalf("partial state: %+v", state)
+		}
+	}
+	want := "[[message_added m1[INBOX] message_added m2[INBOX]] [message_added m3[INBOX] message_added m4[INBOX]] [message_added m5[INBOX] message_added m6[INBOX]]]"
+	if fmt.Sprint(pages) != want || mailbox.requests["profile"] != 1 {
+		t.Fatalf("pages: %v, profile requests %d", pages, mailbox.requests["profile"])
+	}
+
+	// 快照完成后从开始时的 historyId 增量同步，拿到全量同步期间的变更
+	result, err := syncer.Sync(ref, false)
+	if err != nil || result.Full || fmt.Sprint(syncSummary(result.Events)) != "[message_added m6[INBOX]]" {
+		t.Fatalf("incremental after full sync: %v %+v", err, result)
+	}
+
+	// full=true 丢弃未完成的全量同步，重新开始
+	if _, err := syncer.Sync(ref, true); err != nil {
+		t.Fatal(err)
+	}
+	result, err = syncer.Sync(ref, true)
+	if err != nil || result.NextPageToken == "" || fmt.Sprint(syncSummary(result.Events)) != "[message_added m1[INBOX] message_added m2[INBOX]]" || mailbox.requests["profile"] != 3 {
+		t.Fatalf("restarted full sync: %v %+v", err, result)
+	}
+}
@@ -0,0 +1,309 @@
+package gmail
+
+import (
+	"cmp"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"connector-demo/utils"
+
+	"golang.org/x/net/html"
+	"google.golang.org/api/gmail/v1"
+)
+
+// Participant 会话参与者
+type Participant struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email"`
+}
+
+// ThreadMessage 会话中的一封邮件，Reply 为去掉引用原文后的回复内容
+type ThreadMessage struct {
+	Message
+	Reply string `json:"reply"`
+}
+
+// Thread 邮件会话，Messages 按时间升序，列表接口中不返回 Messages
+type Thread struct {
+	ID        string `json:"id"`
+	Snippet   string `json:"snippet"`
+	HistoryID uint64 `json:"historyId"`
+	Subject   string `json:"subject"`
+	// Participants 发件人和收件人（From、To、Cc），按首次出现的顺序
+	Participants  []Participant   `json:"participants"`
+	LabelIDs      []string        `json:"labelIds"`
+	MessageCount  int             `json:"messageCount"`
+	LastMessageAt time.Time       `json:"lastMessageAt"`
+	Messages      []ThreadMessage `json:"messages,omitempty"`
+}
+
+// ThreadList 一页会话，NextPageToken 为空表示没有更多
+type ThreadList struct {
+	Threads            []Thread `json:"threads"`
+	NextPageToken      string   `json:"nextPageToken,omitempty"`
+	ResultSizeEstimate int64    `json:"resultSizeEstimate"`
+}
+
+// threadMetadataHeaders 会话列表只拉取组装摘要需要的邮件头
+var threadMetadataHeaders = []string{"From", "To", "Cc", "Subject", "Date"}
+
+// ListThreads 获取一页会话，每个会话带主题、参与者和邮件数
+func (gc *GmailConnector) ListThreads(ref utils.ConnectionRef, opts ListOptions) (*ThreadList, error) {
+	service, err := gc.GetService(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	call := service.Users.Threads.List("me").IncludeSpamTrash(opts.IncludeSpamTrash)
+	if opts.MaxResults > 0 {
+		call = call.MaxResults(min(opts.MaxResults, maxListResults))
+	}
+	if opts.PageToken != "" {
+		call = call.PageToken(opts.PageToken)
+	}
+	if opts.Query != "" {
+		call = call.Q(opts.Query)
+	}
+	if len(opts.LabelIDs) > 0 {
+		call = call.LabelIds(opts.LabelIDs...)
+	}
+	threads, err := call.Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取会话列表失败: %v", err)
+	}
+
+	result := &ThreadList{
+		Threads:            []Thread{},
+		NextPageToken:      threads.NextPageToken,
+		ResultSizeEstimate: threads.ResultSizeEstimate,
+	}
+	for _, t := range threads.Threads {
+		full, err := service.Users.Threads.Get("me", t.Id).Format("metadata").MetadataHeaders(threadMetadataHeaders...).Do()
+		if err != nil {
+			continue // 跳过失败的会话
+		}
+		thread := parseThread(full)
+		thread.Messages = nil
+		result.Threads = append(result.Threads, thread)
+	}
+	return result, nil
+}
+
+// GetThread 获取会话详情，包含全部邮件
+func (gc *GmailConnector) GetThread(ref utils.ConnectionRef, threadID string) (*Thread, error) {
+	service, err := gc.GetService(ref)
+	if err != nil {
+		return nil, err
+	}
+	full, err := service.Users.Threads.Get("me", threadID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取会话详情失败: %w", err)
+	}
+	thread := parseThread(full)
+	return &thread, nil
+}
+
+// parseThread 按时间排序邮件，汇总主题、参与者和标签
+func parseThread(t *gmail.Thread) Thread {
+	msgs := slices.Clone(t.Messages)
+	slices.SortStableFunc(msgs, func(a, b *gmail.Message) int {
+		return cmp.Compare(a.InternalDate, b.InternalDate)
+	})
+
+	thread := Thread{
+		ID:           t.Id,
+		Snippet:      t.Snippet,
+		HistoryID:    t.HistoryId,
+		Participants: []Participant{},
+		LabelIDs:     []string{},
+		MessageCount: len(msgs),
+		Messages:     make([]ThreadMessage, 0, len(msgs)),
+	}
+	seen := make(map[string]int) // 小写邮箱 -> Participants 下标
+	for _, m := range msgs {
+		msg := parseGmailMessage(m)
+		if thread.Subject == "" {
+			thread.Subject = msg.Subject
+		}
+		for _, field := range []string{msg.From, msg.To, msg.Cc} {
+			for _, p := range parseParticipants(field) {
+				key := strings.ToLower(p.Email)
+				i, ok := seen[key]
+				if !ok {
+					seen[key] = len(thread.Participants)
+					thread.Participants = append(thread.Participants, p)
+				} else if thread.Participants[i].Name == "" {
+					// 同一地址有的邮件头不带名称，用后面出现的名称补全
+					thread.Participants[i].Name = p.Name
+				}
+			}
+		}
+		for _, label := range m.LabelIds {
+			if !slices.Contains(thread.LabelIDs, label) {
+				thread.LabelIDs = append(thread.LabelIDs, label)
+			}
+		}
+		thread.LastMessageAt = time.UnixMilli(m.InternalDate).UTC()
+		thread.Messages = append(thread.Messages, ThreadMessage{Message: msg, Reply: messageReply(msg)})
+	}
+	if len(thread.Messages) > 0 {
+		thread.Snippet = thread.Messages[len(thread.Messages)-1].Snippet
+	}
+	return thread
+}
+
+// parseParticipants 解析地址列表，格式错误时把整段作为一个地址
+func parseParticipants(field string) []Participant {
+	if strings.TrimSpace(field) == "" {
+		return nil
+	}
+	addrs, err := mail.ParseAddressList(field)
+	if err != nil {
+		return []Participant{{Email: strings.TrimSpace(field)}}
+	}
+	participants := make([]Participant, 0, len(addrs))
+	for _, a := range addrs {
+		participants = append(participants, Participant{Name: a.Name, Email: a.Address})
+	}
+	return participants
+}
+
+// 引用原文的开头：各客户端的 "On ... wrote:"、"在 ... 写道："、Outlook 的分隔线和原邮件头
+var (
+	attributionPattern = regexp.MustCompile(`^(On\s.+\swrote:|在\s?.+写道[：:]|Le\s.+a écrit\s?:|Am\s.+schrieb\s.+:)\s*$`)
+	separatorPattern   = regexp.MustCompile(`^(-{2,}\s*(Original Message|Forwarded message|原始邮件|转发邮件)\s*-{2,}|_{10,})\s*$`)
+	outlookFromPattern = regexp.MustCompile(`^(From|发件人)\s*[:：]`)
+)
+
+// stripQuotedReply 去掉回复邮件中引用的原文，只保留新写的内容；整封都是引用时原样返回
+func stripQuotedReply(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	cut := len(lines)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		// Gmail 会把较长的 "On ... wrote:" 折成两行
+		joined := line
+		if i+1 < len(lines) {
+			joined = line + " " + strings.TrimSpace(lines[i+1])
+		}
+		if attributionPattern.MatchString(line) || separatorPattern.MatchString(line) ||
+			(strings.HasPrefix(line, "On ") && !strings.HasSuffix(line, "wrote:") && attributionPattern.MatchString(joined)) ||
+			(outlookFromPattern.MatchString(line) && i > 0 && strings.TrimSpace(lines[i-1]) == "") {
+			cut = i
+			break
+		}
+		// 剩下的全部是 > 引用或空行
+		if strings.HasPrefix(line, ">") && onlyQuoted(lines[i:]) {
+			cut = i
+			break
+		}
+	}
+
+	reply := strings.TrimSpace(strings.Join(lines[:cut], "\n"))
+	if reply == "" {
+		return strings.TrimSpace(text)
+	}
+	return reply
+}
+
+// messageReply 邮件的回复内容。只有 HTML 正文时先转成纯文本，并去掉 <blockquote> 和 gmail_quote 中引用的原文
+func messageReply(msg Message) string {
+	if strings.TrimSpace(msg.Text) != "" || msg.HTML == "" {
+		return stripQuotedReply(msg.Text)
+	}
+	doc, err := html.Parse(strings.NewReader(msg.HTML))
+	if err != nil {
+		return ""
+	}
+	if reply := stripQuotedReply(htmlText(doc, true)); reply != "" {
+		return reply
+	}
+	// 整封都是引用时原样返回，与纯文本一致
+	return strings.TrimSpace(htmlText(doc, false))
+}
+
+// htmlBlockTags 转纯文本时前后换行的块级元素
+var htmlBlockTags = map[string]bool{
+	"p": true, "div": true, "li": true, "tr": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "hr": true,
+}
+
+// htmlText 把 HTML 渲染成纯文本，skipQuotes 为 true 时跳过引用的原文
+func htmlText(doc *html.Node, skipQuotes bool) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "head", "script", "style", "title":
+				return
+			}
+			if skipQuotes && isQuoteNode(n) {
+				return
+			}
+			if n.Data == "br" {
+				b.WriteString("\n")
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(strings.Join(strings.Fields(n.Data), " "))
+			if strings.HasSuffix(n.Data, " ") || strings.HasSuffix(n.Data, "\n") {
+				b.WriteString(" ")
+			}
+		}
+		block := n.Type == html.ElementNode && htmlBlockTags[n.Data]
+		if block {
+			b.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			b.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	lines := strings.Split(b.String(), "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		// 合并连续的空行
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// isQuoteNode 引用原文的元素：<blockquote> 和 Gmail 的 gmail_quote 容器
+func isQuoteNode(n *html.Node) bool {
+	if n.Data == "blockquote" {
+		return true
+	}
+	for _, attr := range n.Attr {
+		if attr.Key == "class" && slices.Contains(strings.Fields(attr.Val), "gmail_quote") {
+			return true
+		}
+	}
+	return false
+}
+
+func onlyQuoted(lines []string) bool {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, ">") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package gmail
+
+import "testing"
+
+func TestStripQuotedReply(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "no quote",
+			text: "Sounds good.\n\nThanks,\nBob",
+			want: "Sounds good.\n\nThanks,\nBob",
+		},
+		{
+			name: "gmail attribution",
+			text: "Sounds good.\r\n\r\nOn Mon, May 6, 2024 at 10:00 AM Alice <alice@example.com> wrote:\r\n> Can we ship today?\r\n",
+			want: "Sounds good.",
+		},
+		{
+			name: "wrapped attribution",
+			text: "Yes.\n\nOn Mon, May 6, 2024 at 10:00 AM Alice Wonderland <\nalice@example.com> wrote:\n> Can we ship today?",
+			want: "Yes.",
+		},
+		{
+			name: "chinese attribution",
+			text: "收到。\n\n在 2024年5月6日 10:00，Alice <alice@example.com> 写道：\n> 今天能发布吗？",
+			want: "收到。",
+		},
+		{
+			name: "outlook original message",
+			text: "Approved.\n\n-----Original Message-----\nFrom: Alice\nSent: Monday\n\nCan we ship today?",
+			want: "Approved.",
+		},
+		{
+			name: "outlook header block",
+			text: "Approved.\n\nFrom: Alice <alice@example.com>\nSent: Monday, May 6, 2024 10:00 AM\nTo: Bob\n\nCan we ship today?",
+			want: "Approved.",
+		},
+		{
+			name: "trailing quoted lines",
+			text: "Agreed.\n\n> first\n>\n> second\n",
+			want: "Agreed.",
+		},
+		{
+			name: "interleaved reply keeps quotes",
+			text: "> question one\nanswer one\n> question two\nanswer two",
+			want: "> question one\nanswer one\n> question two\nanswer two",
+		},
+		{
+			name: "fully quoted message is kept",
+			text: "> only quote",
+			want: "> only quote",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripQuotedReply(tt.text); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageReply(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want string
+	}{
+		{
+			name: "plain text preferred",
+			msg:  Message{Text: "Sounds good.\n\n> old", HTML: "<div>ignored</div>"},
+			want: "Sounds good.",
+		},
+		{
+			name: "html only gmail quote",
+			msg: Message{HTML: `<div dir="ltr">Sounds <b>good</b>.<br>Bob</div><br>` +
+				`<div class="gmail_quote"><div class="gmail_attr">On Mon, May 6, 2024 at 10:00 AM Alice &lt;alice@example.com&gt; wrote:<br></div>` +
+				`<blockquote class="gmail_quote">Can we ship today?</blockquote></div>`},
+			want: "Sounds good.\nBob",
+		},
+		{
+			name: "html only blockquote",
+			msg:  Message{HTML: `<html><head><style>p{}</style></head><body><p>Approved.</p><blockquote>Can we ship today?</blockquote></body></html>`},
+			want: "Approved.",
+		},
+		{
+			name: "html fully quoted is kept",
+			msg:  Message{HTML: `<blockquote>only quote</blockquote>`},
+			want: "only quote",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageReply(tt.msg); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package gmail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"connector-demo/auth"
+	"connector-demo/utils"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// ErrWatchTopicNotConfigured 没有配置接收推送的 Pub/Sub topic
+var ErrWatchTopicNotConfigured = errors.New("未配置Gmail推送的Pub/Sub topic")
+
+// WatchState 一个连接的推送订阅
+type WatchState struct {
+	UserID       string   `json:"userId"`
+	ConnectionID string   `json:"connectionId"`
+	EmailAddress string   `json:"emailAddress"`
+	Topic        string   `json:"topic"`
+	LabelIDs     []string `json:"labelIds,omitempty"`
+	// HistoryID 订阅时邮箱的 historyId
+	HistoryID uint64 `json:"historyId"`
+	// Expiration 订阅到期时间，Gmail 的订阅最长 7 天，需要在到期前续订
+	Expiration time.Time `json:"expiration"`
+	// PendingHistoryID 收到推送但还没有同步的最新 historyId，为 0 表示没有待同步的变更。
+	// 推送先记录这个值再触发同步，同步失败时保留，由下一次推送、Pub/Sub 重试或调用方调用同步接口补上
+	PendingHistoryID uint64    `json:"pendingHistoryId,omitempty"`
+	NotifiedAt       time.Time `json:"notifiedAt,omitzero"`
+}
+
+// Ref 订阅所属的连接
+func (w *WatchState) Ref() utils.ConnectionRef {
+	return utils.ConnectionRef{UserID: w.UserID, ConnectionID: w.ConnectionID}
+}
+
+// WatchStore 推送订阅存储
+type WatchStore interface {
+	// Get 获取连接的订阅，没有时返回 nil, nil
+	Get(userID, connectionID string) (*WatchState, error)
+	// FindByEmail 按邮箱地址查找订阅，推送消息中只有邮箱地址
+	FindByEmail(email string) ([]*WatchState, error)
+	// List 列出全部订阅
+	List() ([]*WatchState, error)
+	Save(state *WatchState) error
+	Delete(userID, connectionID string) error
+}
+
+// MemoryWatchStore 内存订阅存储，进程重启后需要重新订阅
+type MemoryWatchStore struct {
+	watches map[string]WatchState // userID/connectionID -> 订阅
+	mu      sync.RWMutex
+}
+
+func NewMemoryWatchStore() *MemoryWatchStore {
+	return &MemoryWatchStore{watches: make(map[string]WatchState)}
+}
+
+func watchKey(userID, connectionID string) string {
+	return userID + "/" + connectionID
+}
+
+func (s *MemoryWatchStore) Get(userID, connectionID string) (*WatchState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	w, ok := s.watches[watchKey(userID, connectionID)]
+	if !ok {
+		return nil, nil
+	}
+	return &w, nil
+}
+
+func (s *MemoryWatchStore) FindByEmail(email string) ([]*WatchState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var result []*WatchState
+	for _, w := range s.watches {
+		if strings.EqualFold(w.EmailAddress, email) {
+			result = append(result, &w)
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryWatchStore) List() ([]*WatchState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]*WatchState, 0, len(s.watches))
+	for _, w := range s.watches {
+		result = append(result, &w)
+	}
+	return result, nil
+}
+
+func (s *MemoryWatchStore) Save(state *WatchState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watches[watchKey(state.UserID, state.ConnectionID)] = *state
+	return nil
+}
+
+func (s *MemoryWatchStore) Delete(userID, connectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watches, watchKey(userID, connectionID))
+	return nil
+}
+
+// BoltWatchStore 持久化的订阅存储，键为 userID/connectionID
+type BoltWatchStore struct {
+	bucket *utils.BoltStateBucket
+}
+
+func NewBoltWatchStore(bucket *utils.BoltStateBucket) *BoltWatchStore {
+	return &BoltWatchStore{bucket: bucket}
+}
+
+func (s *BoltWatchStore) Get(userID, connectionID string) (*WatchState, error) {
+	w := &WatchState{}
+	found, err := s.bucket.Get(watchKey(userID, connectionID), w)
+	if err != nil || !found {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *BoltWatchStore) FindByEmail(email string) ([]*WatchState, error) {
+	watches, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var result []*WatchState
+	for _, w := range watches {
+		if strings.EqualFold(w.EmailAddress, email) {
+			result = append(result, w)
+		}
+	}
+	return result, nil
+}
+
+func (s *BoltWatchStore) List() ([]*WatchState, error) {
+	result := []*WatchState{}
+	err := s.bucket.ForEach(func(key string, data []byte) error {
+		w := &WatchState{}
+		if err := json.Unmarshal(data, w); err != nil {
+			return fmt.Errorf("解析Gmail推送订阅失败(%s): %v", key, err)
+		}
+		result = append(result, w)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltWatchStore) Save(state *WatchState) error {
+	return s.bucket.Put(watchKey(state.UserID, state.ConnectionID), state)
+}
+
+func (s *BoltWatchStore) Delete(userID, connectionID string) error {
+	return s.bucket.Delete(watchKey(userID, connectionID))
+}
+
+// Watcher 管理各连接的 users.watch 订阅并在到期前续订
+type Watcher struct {
+	connector *GmailConnector
+	store     WatchStore
+	// topic 接收推送的 Pub/Sub topic，格式 projects/{project}/topics/{topic}
+	topic string
+	// mu 串行化订阅记录的读改写，避免续订、推送和同步互相覆盖待同步标记
+	mu sync.Mutex
+}
+
+func NewWatcher(connector *GmailConnector, store WatchStore, topic string) *Watcher {
+	return &Watcher{connector: connector, store: store, topic: topic}
+}
+
+// Watch 为连接订阅邮箱变更推送，labelIDs 为空时订阅全部邮件；重复调用会续订
+func (w *Watcher) Watch(ref utils.ConnectionRef, labelIDs []string) (*WatchState, error) {
+	if w.topic == "" {
+		return nil, ErrWatchTopicNotConfigured
+	}
+	token, service, err := w.connector.getTokenService(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := service.Users.GetProfile("me").Do()
+	if err != nil {
+		return nil, fmt.Errorf("获取邮箱信息失败: %v", err)
+	}
+	req := &gmail.WatchRequest{TopicName: w.topic, LabelIds: labelIDs}
+	if len(labelIDs) > 0 {
+		req.LabelFilterBehavior = "include"
+	}
+	resp, err := service.Users.Watch("me", req).Do()
+	if err != nil {
+		return nil, fmt.Errorf("订阅Gmail推送失败: %v", err)
+	}
+
+	state := &WatchState{
+		UserID:       ref.UserID,
+		ConnectionID: token.ConnectionID,
+		EmailAddress: profile.EmailAddress,
+		Topic:        w.topic,
+		LabelIDs:     labelIDs,
+		HistoryID:    resp.HistoryId,
+		Expiration:   time.UnixMilli(resp.Expiration),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// 续订时保留尚未同步的推送
+	if old, err := w.store.Get(state.UserID, state.ConnectionID); err == nil && old != nil {
+		state.PendingHistoryID, state.NotifiedAt = old.PendingHistoryID, old.NotifiedAt
+	}
+	if err := w.store.Save(state); err != nil {
+		return nil, fmt.Errorf("保存Gmail推送订阅失败: %v", err)
+	}
+	return state, nil
+}
+
+// Get 获取连接的推送订阅，没有订阅时返回 nil, nil
+func (w *Watcher) Get(ref utils.ConnectionRef) (*WatchState, error) {
+	token, err := w.connector.tokenManager.GetConnectionToken(ref, auth.ProviderGmail)
+	if err != nil {
+		return nil, fmt.Errorf("获取Google访问令牌失败: %w", err)
+	}
+	return w.store.Get(ref.UserID, token.ConnectionID)
+}
+
+// markPending 记录推送通知中的 historyId，返回订阅是否有待同步的变更（包括之前同步失败留下的）
+func (w *Watcher) markPending(userID, connectionID string, historyID uint64) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watch, err := w.store.Get(userID, connectionID)
+	if err != nil || watch == nil {
+		return false, err
+	}
+	if historyID > watch.PendingHistoryID {
+		watch.PendingHistoryID = historyID
+		watch.NotifiedAt = time.Now()
+		if err := w.store.Save(watch); err != nil {
+			return false, err
+		}
+	}
+	return watch.PendingHistoryID != 0, nil
+}
+
+// clearPending 同步进度推进到 historyID 后清除已经同步的推送标记
+func (w *Watcher) clearPending(ref utils.ConnectionRef, historyID uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	watch, err := w.Get(ref)
+	if err != nil || watch == nil || watch.PendingHistoryID == 0 || watch.PendingHistoryID > historyID {
+		return err
+	}
+	watch.PendingHistoryID = 0
+	return w.store.Save(watch)
+}
+
+// Stop 取消连接的推送订阅
+func (w *Watcher) Stop(ref utils.ConnectionRef) error {
+	token, service, err := w.connector.getTokenService(ref)
+	if err != nil {
+		return err
+	}
+	if err := service.Users.Stop("me").Do(); err != nil {
+		return fmt.Errorf("取消Gmail推送订阅失败: %v", err)
+	}
+	return w.store.Delete(ref.UserID, token.ConnectionID)
+}
+
+// StartRenewer 启动后台续订，每隔 interval 扫描一次，续订 ahead 内到期的订阅
+func (w *Watcher) StartRenewer(ctx context.Context, interval, ahead time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		log.Printf("Gmail推送后台续订已启动，扫描间隔 %s，提前 %s 续订", interval, ahead)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.renewExpiring(ahead)
+			}
+		}
+	}()
+}
+
+// renewExpiring 续订所有即将到期的订阅，返回尝试续订的数量；连接已断开的订阅直接删除
+func (w *Watcher) renewExpiring(ahead time.Duration) int {
+	watches, err := w.store.List()
+	if err != nil {
+		log.Printf("扫描Gmail推送订阅失败: %v", err)
+		return 0
+	}
+
+	deadline := time.Now().Add(ahead)
+	attempted := 0
+	for _, watch := range watches {
+		if watch.Expiration.After(deadline) {
+			continue
+		}
+		attempted++
+		if _, err := w.Watch(watch.Ref(), watch.LabelIDs); err != nil {
+			if errors.Is(err, utils.ErrTokenNotFound) {
+				w.store.Delete(watch.UserID, watch.ConnectionID)
+				continue
+			}
+			log.Printf("续订Gmail推送失败(user=%s connection=%s): %v", watch.UserID, watch.ConnectionID, err)
+		}
+	}
+	return attempted
+}
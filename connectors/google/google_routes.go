@@ -4,6 +4,7 @@ import (
 	"connector-demo/connectors/google/drive"
 	"connector-demo/connectors/google/gmail"
 
+	"connector-demo/middleware"
 	"connector-demo/routes"
 
 	"github.com/gin-gonic/gin"
@@ -27,13 +28,8 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	drive.RegisterRoutes(googleGroup)
 
 	googleGroup.GET("/test", func(c *gin.Context) {
-		userID := c.Query("user_id")
-		if userID == "" {
-			c.JSON(400, gin.H{"error": "缺少user_id参数"})
-			return
-		}
-
-		status := googleService.TestConnection(userID)
+		ref := middleware.Connection(c)
+		status := googleService.TestConnection(ref)
 		c.JSON(200, status)
 	})
 
@@ -33,14 +33,14 @@ func NewGoogleService(tokenManager *utils.TokenManager) *GoogleService {
 }
 
 // TestConnection 测试Google连接，返回各平台测试状态
-func (gs *GoogleService) TestConnection(userID string) map[string]bool {
-	platforms := map[string]func(string) bool{
-		auth.ProviderGmail:       func(uid string) bool { return gs.Gmail != nil && gs.Gmail.TestConnection(uid) },
-		auth.ProviderGoogleDrive: func(uid string) bool { return gs.Drive != nil && gs.Drive.TestConnection(uid) },
+func (gs *GoogleService) TestConnection(ref utils.ConnectionRef) map[string]bool {
+	platforms := map[string]func(utils.ConnectionRef) bool{
+		auth.ProviderGmail:       func(ref utils.ConnectionRef) bool { return gs.Gmail != nil && gs.Gmail.TestConnection(ref) },
+		auth.ProviderGoogleDrive: func(ref utils.ConnectionRef) bool { return gs.Drive != nil && gs.Drive.TestConnection(ref) },
 	}
 	result := make(map[string]bool, len(platforms))
 	for k, test := range platforms {
-		result[k] = test(userID)
+		result[k] = test(ref)
 	}
 	return result
 }
@@ -47,17 +47,17 @@ func NewSlackConnector(tm *utils.TokenManager) *SlackConnector {
 }
 
 // 获取Slack客户端
-func (sc *SlackConnector) getClient(userID string) (*slack.Client, error) {
-	token, exists := sc.tokenManager.GetToken(userID, auth.ProviderSlack)
-	if !exists {
-		return nil, fmt.Errorf("未找到用户的Slack token")
+func (sc *SlackConnector) getClient(ref utils.ConnectionRef) (*slack.Client, error) {
+	token, err := sc.tokenManager.GetConnectionToken(ref, auth.ProviderSlack)
+	if err != nil {
+		return nil, fmt.Errorf("获取用户的Slack token失败: %w", err)
 	}
 	return slack.New(token.AccessToken), nil
 }
 
 // 原始API调用封装
-func (sc *SlackConnector) GetUserInfo(userID string) (*slack.User, error) {
-	client, err := sc.getClient(userID)
+func (sc *SlackConnector) GetUserInfo(ref utils.ConnectionRef) (*slack.User, error) {
+	client, err := sc.getClient(ref)
 	if err != nil {
 		return nil, err
 	}
@@ -68,8 +68,8 @@ func (sc *SlackConnector) GetUserInfo(userID string) (*slack.User, error) {
 	return client.GetUserInfo(authTest.UserID)
 }
 
-func (sc *SlackConnector) ListChannels(userID string) ([]slack.Channel, error) {
-	client, err := sc.getClient(userID)
+func (sc *SlackConnector) ListChannels(ref utils.ConnectionRef) ([]slack.Channel, error) {
+	client, err := sc.getClient(ref)
 	if err != nil {
 		return nil, err
 	}
@@ -78,8 +78,8 @@ func (sc *SlackConnector) ListChannels(userID string) ([]slack.Channel, error) {
 }
 
 // ListMessages 获取指定 channel 的历史消息
-func (sc *SlackConnector) ListMessages(userID, channelID string, limit int, oldest, latest string) ([]SlackMessage, error) {
-	client, err := sc.getClient(userID)
+func (sc *SlackConnector) ListMessages(ref utils.ConnectionRef, channelID string, limit int, oldest, latest string) ([]SlackMessage, error) {
+	client, err := sc.getClient(ref)
 	if err != nil {
 		return nil, err
 	}
@@ -1,6 +1,7 @@
 package slack
 
 import (
+	"connector-demo/middleware"
 	"connector-demo/routes"
 	"strconv"
 
@@ -17,12 +18,8 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	slackGroup := rg.Group("/slack")
 
 	slackGroup.GET("/user-info", func(c *gin.Context) {
-		userID := c.Query("user_id")
-		if userID == "" {
-			c.JSON(400, gin.H{"error": "缺少user_id"})
-			return
-		}
-		info, err := slackService.GetUserInfo(userID)
+		ref := middleware.Connection(c)
+		info, err := slackService.GetUserInfo(ref)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -31,8 +28,8 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	})
 
 	slackGroup.GET("/channels", func(c *gin.Context) {
-		userID := c.Query("user_id")
-		channels, err := slackService.ListChannels(userID)
+		ref := middleware.Connection(c)
+		channels, err := slackService.ListChannels(ref)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -41,8 +38,8 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 	})
 
 	slackGroup.GET("/test", func(c *gin.Context) {
-		userID := c.Query("user_id")
-		if !slackService.TestConnection(userID) {
+		ref := middleware.Connection(c)
+		if !slackService.TestConnection(ref) {
 			c.JSON(500, gin.H{"error": "Slack连接测试失败"})
 			return
 		}
@@ -51,10 +48,10 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 
 	// 获取消息列表
 	slackGroup.GET("/messages/:channel_id", func(c *gin.Context) {
-		userID := c.Query("user_id")
+		ref := middleware.Connection(c)
 		channelID := c.Param("channel_id")
-		if userID == "" || channelID == "" {
-			c.JSON(400, gin.H{"error": "缺少 user_id 或 channel_id"})
+		if channelID == "" {
+			c.JSON(400, gin.H{"error": "缺少 channel_id"})
 			return
 		}
 
@@ -71,7 +68,7 @@ func RegisterRoutes(rg *gin.RouterGroup) {
 		oldest := c.Query("oldest")
 		latest := c.Query("latest")
 
-		messages, err := slackService.ListMessages(userID, channelID, limit, oldest, latest)
+		messages, err := slackService.ListMessages(ref, channelID, limit, oldest, latest)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
@@ -18,28 +18,28 @@ func NewSlackService(tokenManager *utils.TokenManager) *SlackService {
 }
 
 // 获取用户信息
-func (s *SlackService) GetUserInfo(userID string) (*slack.User, error) {
-	return s.connector.GetUserInfo(userID)
+func (s *SlackService) GetUserInfo(ref utils.ConnectionRef) (*slack.User, error) {
+	return s.connector.GetUserInfo(ref)
 }
 
 // 获取频道列表
-func (s *SlackService) ListChannels(userID string) ([]slack.Channel, error) {
-	return s.connector.ListChannels(userID)
+func (s *SlackService) ListChannels(ref utils.ConnectionRef) ([]slack.Channel, error) {
+	return s.connector.ListChannels(ref)
 }
 
 // 获取指定频道的历史消息
-func (s *SlackService) ListMessages(userID, channelID string, limit int, oldest, latest string) ([]SlackMessage, error) {
-	return s.connector.ListMessages(userID, channelID, limit, oldest, latest)
+func (s *SlackService) ListMessages(ref utils.ConnectionRef, channelID string, limit int, oldest, latest string) ([]SlackMessage, error) {
+	return s.connector.ListMessages(ref, channelID, limit, oldest, latest)
 }
 
 // 测试连接，返回bool
-func (s *SlackService) TestConnection(userID string) bool {
-	_, err := s.connector.GetUserInfo(userID)
+func (s *SlackService) TestConnection(ref utils.ConnectionRef) bool {
+	_, err := s.connector.GetUserInfo(ref)
 	if err != nil {
 		log.Printf("Slack连接测试失败: %v", err)
 		return false
 	}
-	log.Printf("Slack连接测试成功: %s", userID)
+	log.Printf("Slack连接测试成功: %s", ref.UserID)
 	return true
 }
 
@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"connector-demo/auth"
 	"connector-demo/config"
+	"connector-demo/connectors/confluence"
 	"connector-demo/connectors/google"
+	"connector-demo/connectors/google/gmail"
 	"connector-demo/connectors/slack"
+	"connector-demo/middleware"
 	"connector-demo/routes"
 	"connector-demo/utils"
 
@@ -20,24 +29,93 @@ import (
 func main() {
 	// 加载配置
 	cfg := config.LoadConfig()
+
+	// 命令行子命令
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1], os.Args[2:])
+		return
+	}
+
 	// 初始化OAuth2提供者
 	if err := auth.SetupProviders(cfg); err != nil {
 		log.Fatalf("初始化OAuth2提供者失败: %v", err)
 	}
 
-	// 初始化token管理器
-	tokenManager := utils.NewTokenManager()
+	// 初始化API认证
+	authenticator, err := middleware.NewAuthenticator(cfg)
+	if err != nil {
+		log.Fatalf("初始化API认证失败: %v", err)
+	}
+	requireUser := []gin.HandlerFunc{authenticator.RequireAuth(), middleware.RequireUser()}
+
+	// 初始化token存储和管理器
+	tokenStore, err := utils.NewTokenStore(cfg)
+	if err != nil {
+		log.Fatalf("初始化token存储失败: %v", err)
+	}
+	tokenManager := utils.NewTokenManagerWithStore(tokenStore)
+	tokenManager.SetRefreshSkew(cfg.TokenRefreshSkew)
+	// 服务退出时关闭存储，bolt 数据库需要正常关闭才能释放文件锁
+	defer tokenManager.Close()
+
+	// 收到 SIGINT/SIGTERM 时停止后台任务并优雅关闭服务
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 配置了旧密钥时在后台把已有token重新加密到新密钥，服务无需停机
+	if encStore, ok := tokenStore.(*utils.EncryptedTokenStore); ok && encStore.NeedsRotation() {
+		go func() {
+			n, err := encStore.RotateKeys()
+			if err != nil {
+				log.Printf("token密钥轮换失败: %v", err)
+				return
+			}
+			log.Printf("token密钥轮换完成，重新加密 %d 条token", n)
+		}()
+	}
 	// 注入测试 token
 	utils.InjectTestTokens(tokenManager)
+	// 后台提前刷新即将过期的token
+	tokenManager.StartRefresher(ctx, cfg.TokenRefreshInterval, cfg.TokenRefreshAhead)
 
 	// 初始化认证处理器
-	authHandler := auth.NewAuthHandler(tokenManager)
+	stateSecret := []byte(cfg.SessionSecret)
+	if len(stateSecret) == 0 {
+		log.Println("未配置SESSION_SECRET，使用随机密钥，服务重启后进行中的授权将失效")
+		stateSecret = make([]byte, 32)
+		if _, err := rand.Read(stateSecret); err != nil {
+			log.Fatalf("生成随机密钥失败: %v", err)
+		}
+	}
+	authHandler := auth.NewAuthHandler(tokenManager, stateSecret)
 	// 创建Google
 	googleService := google.NewGoogleService(tokenManager)
+	googleService.Gmail.SetMaxAttachmentSize(cfg.GmailAttachmentMaxSize)
+	//Confluence连接器
+	confluenceService := confluence.NewConfluenceService(tokenManager)
+	// 使用bolt存储时，同步进度和推送订阅与token存在同一个文件中，重启后继续增量同步
+	if boltStore, ok := utils.AsBoltTokenStore(tokenStore); ok {
+		stateBucket := func(name string) *utils.BoltStateBucket {
+			bucket, err := boltStore.StateBucket(name)
+			if err != nil {
+				log.Fatalf("初始化同步状态存储失败: %v", err)
+			}
+			return bucket
+		}
+		googleService.Gmail.SetWatchStore(gmail.NewBoltWatchStore(stateBucket("gmail_watches")))
+		googleService.Gmail.SetSyncStateStore(gmail.NewBoltSyncStateStore(stateBucket("gmail_sync")))
+		confluenceService.SetSyncStateStore(confluence.NewBoltSyncStateStore(stateBucket("confluence_sync")))
+	}
+	googleService.Gmail.SetWatchTopic(cfg.GmailPubSubTopic)
+	if cfg.GmailPubSubTopic != "" {
+		// Gmail推送订阅7天后到期，后台每小时检查一次并提前续订
+		googleService.Gmail.Watcher().StartRenewer(ctx, time.Hour, cfg.GmailWatchRenewAhead)
+	}
 	google.SetGoogleService(googleService)
 	//Slack连接器
 	slackService := slack.NewSlackService(tokenManager)
 	slack.SetSlackService(slackService)
+	confluence.SetConfluenceService(confluenceService)
 
 	// 创建Gin路由
 	r := gin.Default()
@@ -49,54 +127,53 @@ func main() {
 		})
 	})
 
-	routes.RegisterAllModules(r)
+	routes.RegisterAllModules(r, requireUser...)
 
 	// OAuth2认证路由组
 	oauth := r.Group("/auth")
 	{
-		oauth.GET("/:provider", authHandler.Connect)           // 开始认证
-		oauth.GET("/:provider/callback", authHandler.Callback) // 回调处理
+		oauth.GET("/:provider", append(requireUser, authHandler.Connect)...) // 开始认证（需登录）
+		oauth.GET("/:provider/callback", authHandler.Callback)               // 回调处理，用户从 state 中获取
 	}
 
-	// Token管理路由组
-	tokens := r.Group("/tokens")
+	// Token管理路由组（只返回脱敏后的连接信息）
+	tokens := r.Group("/tokens", requireUser...)
 	{
-		tokens.GET("/list", authHandler.GetTokens)                     // 获取用户token列表
-		tokens.DELETE("/disconnect/:provider", authHandler.Disconnect) // 断开连接
-		tokens.GET("/refresh/:provider", func(c *gin.Context) {        // 刷新 token
-			// 假设用户ID从上下文获取（例如通过中间件 auth 解析 JWT）
-			userID := c.Query("user_id")
-			if userID == "" {
-				c.JSON(401, gin.H{"error": "用户未登录"})
-				return
-			}
-
-			provider := c.Param("provider")
-			if provider == "" {
-				c.JSON(400, gin.H{"error": "provider不能为空"})
-				return
-			}
+		tokens.GET("/list", authHandler.GetTokens)                     // 获取用户连接列表
+		tokens.DELETE("/disconnect/:provider", authHandler.Disconnect) // 断开连接（?connection_id= 指定单个连接）
+		tokens.GET("/refresh/:provider", authHandler.Refresh)          // 刷新 token（?connection_id= 指定连接）
+		tokens.PUT("/default/:provider", authHandler.SetDefault)       // 设置默认连接（?connection_id=）
+	}
 
-			newToken, err := tokenManager.RefreshToken(userID, provider)
-			if err != nil {
-				c.JSON(500, gin.H{"error": "刷新token失败", "detail": err.Error()})
-				return
-			}
+	// 内部接口：返回token明文，仅允许通过API key认证的内部服务调用
+	if cfg.APIKeys != "" {
+		internal := r.Group("/internal", append(requireUser, middleware.RequireService())...)
+		internal.GET("/tokens/:provider", authHandler.GetRawToken)
+	}
 
-			c.JSON(200, gin.H{
-				"access_token":  newToken.AccessToken,
-				"refresh_token": newToken.RefreshToken,
-				"expiry":        newToken.Expiry,
-				"provider":      newToken.Provider,
+	// Gmail推送接口：由 Pub/Sub 调用，使用 Google 签发的 OIDC token 认证，不经过用户认证。
+	// 必须同时校验签发的服务账号，否则任何 Google 签发的 token 都能调用
+	if cfg.GmailPushAudience != "" {
+		if cfg.GmailPushAccount == "" {
+			log.Printf("未配置 GMAIL_PUSH_SERVICE_ACCOUNT，不挂载Gmail推送接口")
+		} else {
+			verifier := gmail.NewPushVerifier(cfg.GmailPushAudience, cfg.GmailPushAccount)
+			// 推送触发的同步会推进同步进度，接入下游（如消息队列、搜索索引）时在这里消费变更
+			googleService.Gmail.SetChangeHandler(func(ref utils.ConnectionRef, result *gmail.SyncResult) {
+				log.Printf("Gmail推送同步完成(user=%s connection=%s): %d 个变更，historyId %d",
+					ref.UserID, ref.ConnectionID, len(result.Events), result.HistoryID)
 			})
-		})
+			r.POST("/webhooks/gmail", googleService.Gmail.PushHandler(verifier))
+		}
 	}
 
-	// 调试路由
-	r.GET("/debug/tokens", func(c *gin.Context) {
-		tokenManager.PrintAllTokens()
-		c.JSON(200, gin.H{"message": "token信息已打印到控制台"})
-	})
+	// 调试路由，仅调试模式下挂载
+	if cfg.DebugMode {
+		r.GET("/debug/tokens", func(c *gin.Context) {
+			tokenManager.PrintAllTokens()
+			c.JSON(200, gin.H{"message": "连接信息已打印到控制台"})
+		})
+	}
 
 	// 健康检查
 	r.GET("/health", func(c *gin.Context) {
@@ -112,5 +189,65 @@ func main() {
 	log.Printf("🔗 认证地址: http://localhost%s/auth/{google|slack}", port)
 	log.Printf("🧪 测试地址: http://localhost%s/api", port)
 
-	log.Fatal(http.ListenAndServe(port, r))
+	srv := &http.Server{Addr: port, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("服务启动失败: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("正在关闭服务...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("关闭服务失败: %v", err)
+	}
+}
+
+// runCommand 执行命令行子命令
+//   - generate-key:          生成新的token加密密钥
+//   - rotate-keys:           使用当前密钥重新加密存储中的所有token（服务运行中会在启动时自动执行）
+//   - issue-token <user_id>: 使用 JWT_SECRET 签发24小时有效的用户JWT（本地开发用）
+func runCommand(cfg *config.Config, cmd string, args []string) {
+	switch cmd {
+	case "generate-key":
+		key, err := utils.GenerateKey()
+		if err != nil {
+			log.Fatalf("生成密钥失败: %v", err)
+		}
+		fmt.Println(key)
+	case "rotate-keys":
+		tokenStore, err := utils.NewTokenStore(cfg)
+		if err != nil {
+			log.Fatalf("初始化token存储失败: %v", err)
+		}
+		defer tokenStore.Close()
+
+		encStore, ok := tokenStore.(*utils.EncryptedTokenStore)
+		if !ok {
+			log.Fatalf("未配置token加密密钥，无法轮换")
+		}
+		n, err := encStore.RotateKeys()
+		if err != nil {
+			log.Fatalf("token密钥轮换失败: %v", err)
+		}
+		log.Printf("token密钥轮换完成，重新加密 %d 条token", n)
+	case "issue-token":
+		if len(args) != 1 {
+			log.Fatalf("用法: issue-token <user_id>")
+		}
+		authenticator, err := middleware.NewAuthenticator(cfg)
+		if err != nil {
+			log.Fatalf("初始化API认证失败: %v", err)
+		}
+		token, err := authenticator.IssueToken(args[0], 24*time.Hour)
+		if err != nil {
+			log.Fatalf("签发token失败: %v", err)
+		}
+		fmt.Println(token)
+	default:
+		log.Fatalf("未知命令: %s（支持 generate-key, rotate-keys, issue-token）", cmd)
+	}
 }
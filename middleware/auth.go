@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"connector-demo/config"
+	"connector-demo/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// APIKeyHeader 服务间调用使用的API key请求头
+	APIKeyHeader = "X-API-Key"
+	// OnBehalfOfHeader 服务调用方代为操作的用户ID
+	OnBehalfOfHeader = "X-On-Behalf-Of"
+	// ConnectionIDParam 指定调用使用的连接的查询参数，不传时使用默认连接
+	ConnectionIDParam = "connection_id"
+
+	principalKey = "principal"
+)
+
+// 调用方类型
+const (
+	PrincipalUser    = "user"    // 通过JWT认证的终端用户
+	PrincipalService = "service" // 通过API key认证的内部服务
+)
+
+// Principal 已认证的调用方
+type Principal struct {
+	Kind    string // user 或 service
+	UserID  string // 应用用户ID；服务调用时来自 X-On-Behalf-Of，可能为空
+	Service string // 服务名，仅 service 类型有值
+}
+
+// Authenticator 负责校验请求身份，支持 HS256/RS256 JWT 和静态API key
+type Authenticator struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey // kid -> 公钥
+	apiKeys    map[string]string         // key -> 服务名
+	issuer     string
+	audience   string
+}
+
+// NewAuthenticator 根据配置创建 Authenticator，未配置任何认证方式时返回错误
+func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys:  make(map[string]string),
+		issuer:   cfg.JWTIssuer,
+		audience: cfg.JWTAudience,
+	}
+	if cfg.JWTSecret != "" {
+		a.hmacSecret = []byte(cfg.JWTSecret)
+	}
+	if cfg.JWTJWKSFile != "" {
+		keys, err := LoadJWKSFile(cfg.JWTJWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.rsaKeys = keys
+	}
+	for _, entry := range strings.Split(cfg.APIKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("API_KEYS 格式错误，应为 name:key: %q", entry)
+		}
+		a.apiKeys[key] = name
+	}
+
+	if a.hmacSecret == nil && len(a.rsaKeys) == 0 && len(a.apiKeys) == 0 {
+		return nil, errors.New("未配置任何认证方式，请设置 JWT_SECRET、JWT_JWKS_FILE 或 API_KEYS")
+	}
+	return a, nil
+}
+
+// RequireAuth 认证中间件，认证通过后把 Principal 写入 gin.Context
+func (a *Authenticator) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := a.Authenticate(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "认证失败", "detail": err.Error()})
+			return
+		}
+		SetPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+// RequireService 只允许内部服务调用，需放在 RequireAuth 之后
+func RequireService() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentPrincipal(c)
+		if !ok || principal.Kind != PrincipalService {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "仅允许内部服务调用"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUser 要求请求代表某个应用用户，服务调用必须通过 X-On-Behalf-Of 指定用户
+// 需放在 RequireAuth 之后
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if UserID(c) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "用户未登录"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Authenticate 校验请求携带的API key或Bearer JWT
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return a.authenticateAPIKey(key, r.Header.Get(OnBehalfOfHeader))
+	}
+
+	header := r.Header.Get("Authorization")
+	raw, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || raw == "" {
+		return nil, errors.New("缺少认证信息")
+	}
+	return a.authenticateJWT(raw)
+}
+
+func (a *Authenticator) authenticateAPIKey(key, onBehalfOf string) (*Principal, error) {
+	// 逐个常量时间比较，避免通过响应时间猜测key
+	for candidate, name := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			return &Principal{Kind: PrincipalService, Service: name, UserID: onBehalfOf}, nil
+		}
+	}
+	return nil, errors.New("无效的API key")
+}
+
+func (a *Authenticator) authenticateJWT(raw string) (*Principal, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if a.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(a.issuer))
+	}
+	if a.audience != "" {
+		opts = append(opts, jwt.WithAudience(a.audience))
+	}
+
+	claims := &jwt.RegisteredClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, a.keyFunc, opts...); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token缺少sub")
+	}
+	return &Principal{Kind: PrincipalUser, UserID: claims.Subject}, nil
+}
+
+// keyFunc 根据签名算法选择校验密钥，防止算法混淆攻击
+func (a *Authenticator) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case "HS256":
+		if a.hmacSecret == nil {
+			return nil, errors.New("未配置HS256密钥")
+		}
+		return a.hmacSecret, nil
+	case "RS256":
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := a.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("未知的kid: %q", kid)
+	}
+	return nil, fmt.Errorf("不支持的签名算法: %s", token.Method.Alg())
+}
+
+// IssueToken 使用HS256密钥签发用户JWT（本地开发和测试用）
+func (a *Authenticator) IssueToken(userID string, ttl time.Duration) (string, error) {
+	if a.hmacSecret == nil {
+		return "", errors.New("未配置HS256密钥")
+	}
+	now := time.Now()
+	claims := jwt.RegisteredClaims{
+		Subject:   userID,
+		Issuer:    a.issuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	if a.audience != "" {
+		claims.Audience = jwt.ClaimStrings{a.audience}
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.hmacSecret)
+}
+
+// SetPrincipal 把调用方写入 gin.Context
+func SetPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+}
+
+// CurrentPrincipal 获取当前请求的调用方
+func CurrentPrincipal(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := v.(*Principal)
+	return principal, ok
+}
+
+// UserID 获取当前请求代表的应用用户ID，未认证或服务调用未指定用户时返回空字符串
+func UserID(c *gin.Context) string {
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		return ""
+	}
+	return principal.UserID
+}
+
+// Connection 获取当前请求要使用的连接：当前用户 + 查询参数 connection_id
+func Connection(c *gin.Context) utils.ConnectionRef {
+	return utils.ConnectionRef{
+		UserID:       UserID(c),
+		ConnectionID: c.Query(ConnectionIDParam),
+	}
+}
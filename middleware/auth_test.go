@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"connector-demo/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestRouter(a *Authenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/me", a.RequireAuth(), RequireUser(), func(c *gin.Context) {
+		principal, _ := CurrentPrincipal(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": UserID(c), "kind": principal.Kind})
+	})
+	r.GET("/internal", a.RequireAuth(), RequireService(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return r
+}
+
+func doRequest(r *gin.Engine, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthenticator_HS256(t *testing.T) {
+	a, err := NewAuthenticator(&config.Config{JWTSecret: "test-secret", JWTIssuer: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(a)
+
+	token, _ := a.IssueToken("user-1", time.Hour)
+	w := doRequest(r, "/me", map[string]string{"Authorization": "Bearer " + token})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["user_id"] != "user-1" || body["kind"] != PrincipalUser {
+		t.Fatalf("unexpected principal: %v", body)
+	}
+
+	// 过期、签名错误、issuer不匹配的token均被拒绝
+	expired, _ := a.IssueToken("user-1", -time.Hour)
+	other, _ := (&Authenticator{hmacSecret: []byte("other"), issuer: "app"}).IssueToken("user-1", time.Hour)
+	wrongIssuer, _ := (&Authenticator{hmacSecret: []byte("test-secret"), issuer: "evil"}).IssueToken("user-1", time.Hour)
+	for name, tok := range map[string]string{"expired": expired, "bad signature": other, "wrong issuer": wrongIssuer} {
+		if w := doRequest(r, "/me", map[string]string{"Authorization": "Bearer " + tok}); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %d", name, w.Code)
+		}
+	}
+
+	// 用户不能访问内部接口
+	if w := doRequest(r, "/internal", map[string]string{"Authorization": "Bearer " + token}); w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for user on internal route, got %d", w.Code)
+	}
+	if w := doRequest(r, "/me?user_id=user-2", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", w.Code)
+	}
+}
+
+func TestAuthenticator_RS256WithJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, jwks, 0o600)
+
+	a, err := NewAuthenticator(&config.Config{JWTJWKSFile: path, JWTAudience: "connector"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(a)
+
+	sign := func(kid, aud string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+			Subject:   "user-rs",
+			Audience:  jwt.ClaimStrings{aud},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		})
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	if w := doRequest(r, "/me", map[string]string{"Authorization": "Bearer " + sign("k1", "connector")}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doRequest(r, "/me", map[string]string{"Authorization": "Bearer " + sign("k2", "connector")}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown kid: expected 401, got %d", w.Code)
+	}
+	if w := doRequest(r, "/me", map[string]string{"Authorization": "Bearer " + sign("k1", "other")}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong audience: expected 401, got %d", w.Code)
+	}
+}
+
+func TestAuthenticator_APIKey(t *testing.T) {
+	a, err := NewAuthenticator(&config.Config{APIKeys: "indexer:key-1, sync:key-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRouter(a)
+
+	w := doRequest(r, "/me", map[string]string{APIKeyHeader: "key-2", OnBehalfOfHeader: "user-9"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var body map[string]string
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["user_id"] != "user-9" || body["kind"] != PrincipalService {
+		t.Fatalf("unexpected principal: %v", body)
+	}
+
+	if w := doRequest(r, "/me", map[string]string{APIKeyHeader: "key-1"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("service without on-behalf-of user: expected 401, got %d", w.Code)
+	}
+	if w := doRequest(r, "/internal", map[string]string{APIKeyHeader: "key-1"}); w.Code != http.StatusNoContent {
+		t.Fatalf("expected service to reach internal route, got %d", w.Code)
+	}
+	if w := doRequest(r, "/me", map[string]string{APIKeyHeader: "wrong", OnBehalfOfHeader: "user-9"}); w.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid key: expected 401, got %d", w.Code)
+	}
+}
+
+func TestNewAuthenticator_RequiresConfiguration(t *testing.T) {
+	if _, err := NewAuthenticator(&config.Config{}); err == nil {
+		t.Fatal("expected error when no authentication method is configured")
+	}
+}
@@ -13,9 +13,9 @@ func RegisterModule(name string, registerFn ModuleRegister) {
 	moduleRegistry[name] = registerFn
 }
 
-// 聚合所有模块路由
-func RegisterAllModules(r *gin.Engine) {
-	apiGroup := r.Group("/api")
+// 聚合所有模块路由，middlewares 作用于整个 /api 路由组
+func RegisterAllModules(r *gin.Engine, middlewares ...gin.HandlerFunc) {
+	apiGroup := r.Group("/api", middlewares...)
 	for _, registerFn := range moduleRegistry {
 		registerFn(apiGroup)
 	}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"mime"
+	"strings"
+)
+
+// inlineSafeTypes 可以在浏览器中直接打开的附件类型。附件类型由上传者或发件人决定，
+// text/html、image/svg+xml 等能执行脚本的类型在我们的域名下打开会造成存储型 XSS
+var inlineSafeTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"image/bmp":       true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+// AttachmentHeaders 返回下载第三方附件时使用的 Content-Type 和响应头。
+// 只有白名单内的类型保留原类型并允许 inline 打开，其余一律作为 application/octet-stream 下载
+func AttachmentHeaders(filename, mediaType string, inline bool) (string, map[string]string) {
+	contentType := "application/octet-stream"
+	disposition := "attachment"
+	if mt, params, err := mime.ParseMediaType(mediaType); err == nil && inlineSafeTypes[strings.ToLower(mt)] {
+		contentType = mime.FormatMediaType(strings.ToLower(mt), params)
+		if inline {
+			disposition = "inline"
+		}
+	}
+	return contentType, map[string]string{
+		"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": filename}),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "sandbox",
+	}
+}
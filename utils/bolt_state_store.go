@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltStateBucket 与token存放在同一个 bbolt 文件中的状态桶，按键保存 JSON，
+// 供连接器持久化同步进度、推送订阅等非敏感状态（不加密）
+type BoltStateBucket struct {
+	db   *bolt.DB
+	name []byte
+}
+
+// StateBucket 打开（或创建）指定名称的状态桶。bbolt 文件同一时间只能被打开一次，
+// 因此状态与token共用 BoltTokenStore 的数据库连接
+func (s *BoltTokenStore) StateBucket(name string) (*BoltStateBucket, error) {
+	if name == string(tokensBucket) {
+		return nil, fmt.Errorf("状态桶名称与token桶冲突: %s", name)
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(name))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("初始化状态存储失败(%s): %v", name, err)
+	}
+	return &BoltStateBucket{db: s.db, name: []byte(name)}, nil
+}
+
+// AsBoltTokenStore 取出（可能被加密包装的）bolt token存储，不是 bolt 存储时返回 false
+func AsBoltTokenStore(store TokenStore) (*BoltTokenStore, bool) {
+	if enc, ok := store.(*EncryptedTokenStore); ok {
+		store = enc.inner
+	}
+	boltStore, ok := store.(*BoltTokenStore)
+	return boltStore, ok
+}
+
+// Get 读取键对应的状态到 out，没有记录时返回 false
+func (b *BoltStateBucket) Get(key string, out any) (bool, error) {
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(b.name).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, out)
+	})
+	return found, err
+}
+
+// Put 保存键对应的状态
+func (b *BoltStateBucket) Put(key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Put([]byte(key), data)
+	})
+}
+
+// Delete 删除键对应的状态，不存在时不报错
+func (b *BoltStateBucket) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).Delete([]byte(key))
+	})
+}
+
+// ForEach 遍历桶中全部状态，fn 中不能读写同一个桶
+func (b *BoltStateBucket) ForEach(fn func(key string, data []byte) error) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(b.name).ForEach(func(k, v []byte) error {
+			return fn(string(k), v)
+		})
+	})
+}
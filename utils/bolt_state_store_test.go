@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStateBucket_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.db")
+	keyring, _ := NewKeyring(newTestKey(t))
+
+	open := func() (*EncryptedTokenStore, *BoltStateBucket) {
+		inner, err := NewBoltTokenStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 加密包装后仍能取到底层的 bolt 存储
+		store := NewEncryptedTokenStore(inner, keyring)
+		boltStore, ok := AsBoltTokenStore(store)
+		if !ok {
+			t.Fatal("bolt store not found behind encryption")
+		}
+		bucket, err := boltStore.StateBucket("sync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store, bucket
+	}
+
+	type state struct {
+		HistoryID uint64 `json:"history_id"`
+	}
+	store, bucket := open()
+	if err := bucket.Put("u1/c1", state{HistoryID: 42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save("u1", "c1", &TokenInfo{AccessToken: "at", Provider: "gmail"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// 模拟进程重启：状态保留，且不会出现在token列表中
+	store, bucket = open()
+	defer store.Close()
+	var got state
+	if found, err := bucket.Get("u1/c1", &got); err != nil || !found || got.HistoryID != 42 {
+		t.Fatalf("state lost after reopen: %v %v %+v", found, err, got)
+	}
+	if all, err := store.ListAll(); err != nil || len(all) != 1 || len(all["u1"]) != 1 {
+		t.Fatalf("unexpected tokens: %v %v", all, err)
+	}
+	if err := bucket.Delete("u1/c1"); err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := bucket.Get("u1/c1", &got); found {
+		t.Fatal("state not deleted")
+	}
+	if _, err := (&BoltTokenStore{db: nil}).StateBucket("tokens"); err == nil {
+		t.Fatal("token bucket name accepted")
+	}
+	if _, ok := AsBoltTokenStore(NewMemoryTokenStore()); ok {
+		t.Fatal("memory store reported as bolt")
+	}
+}
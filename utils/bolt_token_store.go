@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var tokensBucket = []byte("tokens")
+
+// BoltTokenStore 基于 bbolt 的文件token存储，进程重启后token依然可用
+// 数据布局：tokens 桶 -> userID 子桶 -> 连接ID 键 -> TokenInfo JSON
+type BoltTokenStore struct {
+	db *bolt.DB
+}
+
+// NewBoltTokenStore 打开（或创建）指定路径的token数据库文件
+func NewBoltTokenStore(path string) (*BoltTokenStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("创建token存储目录失败: %v", err)
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开token存储失败: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tokensBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化token存储失败: %v", err)
+	}
+
+	return &BoltTokenStore{db: db}, nil
+}
+
+func (s *BoltTokenStore) Save(userID, connectionID string, token *TokenInfo) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		userBucket, err := tx.Bucket(tokensBucket).CreateBucketIfNotExists([]byte(userID))
+		if err != nil {
+			return err
+		}
+		return userBucket.Put([]byte(connectionID), data)
+	})
+}
+
+func (s *BoltTokenStore) Get(userID, connectionID string) (*TokenInfo, error) {
+	var token *TokenInfo
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBucket := tx.Bucket(tokensBucket).Bucket([]byte(userID))
+		if userBucket == nil {
+			return ErrTokenNotFound
+		}
+		data := userBucket.Get([]byte(connectionID))
+		if data == nil {
+			return ErrTokenNotFound
+		}
+		token = &TokenInfo{}
+		return json.Unmarshal(data, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *BoltTokenStore) Delete(userID, connectionID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(tokensBucket)
+		userBucket := root.Bucket([]byte(userID))
+		if userBucket == nil {
+			return nil
+		}
+		if err := userBucket.Delete([]byte(connectionID)); err != nil {
+			return err
+		}
+		// 用户没有任何token时删除其子桶
+		if k, _ := userBucket.Cursor().First(); k == nil {
+			return root.DeleteBucket([]byte(userID))
+		}
+		return nil
+	})
+}
+
+func (s *BoltTokenStore) List(userID string) (map[string]*TokenInfo, error) {
+	result := make(map[string]*TokenInfo)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		userBucket := tx.Bucket(tokensBucket).Bucket([]byte(userID))
+		if userBucket == nil {
+			return nil
+		}
+		return decodeUserBucket(userBucket, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltTokenStore) ListAll() (map[string]map[string]*TokenInfo, error) {
+	result := make(map[string]map[string]*TokenInfo)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEachBucket(func(userID []byte) error {
+			userTokens := make(map[string]*TokenInfo)
+			userBucket := tx.Bucket(tokensBucket).Bucket(userID)
+			if err := decodeUserBucket(userBucket, userTokens); err != nil {
+				return err
+			}
+			result[string(userID)] = userTokens
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltTokenStore) Close() error {
+	return s.db.Close()
+}
+
+// decodeUserBucket 将用户子桶中的所有token解码到 out
+func decodeUserBucket(b *bolt.Bucket, out map[string]*TokenInfo) error {
+	return b.ForEach(func(k, v []byte) error {
+		token := &TokenInfo{}
+		if err := json.Unmarshal(v, token); err != nil {
+			return fmt.Errorf("解析token失败(%s): %v", k, err)
+		}
+		out[string(k)] = token
+		return nil
+	})
+}
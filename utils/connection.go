@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// ConnectionRef 指定一次API调用使用的连接
+// ConnectionID 为空时使用用户在该平台的默认连接
+type ConnectionRef struct {
+	UserID       string
+	ConnectionID string
+}
+
+// NewConnectionID 生成新的连接ID
+func NewConnectionID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "conn_" + hex.EncodeToString(b), nil
+}
+
+// normalizeConnection 兼容旧数据：旧版本每个平台只有一个连接，以平台名为键且没有连接ID
+// 这类记录以平台名作为连接ID，并视为该平台的默认连接
+func normalizeConnection(key string, token *TokenInfo) *TokenInfo {
+	if token.ConnectionID != "" {
+		return token
+	}
+	legacy := *token
+	legacy.ConnectionID = key
+	legacy.Provider = key
+	legacy.Default = true
+	return &legacy
+}
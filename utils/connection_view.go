@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+)
+
+// ConnectionView 连接的脱敏视图，对外接口默认返回该结构，不包含任何token明文
+type ConnectionView struct {
+	ConnectionID    string           `json:"connection_id"`
+	Provider        string           `json:"provider"`
+	Default         bool             `json:"default"`
+	Account         string           `json:"account,omitempty"`
+	AccountID       string           `json:"account_id,omitempty"`
+	AccountEmail    string           `json:"account_email,omitempty"`
+	Scopes          []string         `json:"scopes,omitempty"`
+	Sites           []ConnectionSite `json:"sites,omitempty"`
+	Expiry          time.Time        `json:"expiry,omitzero"`
+	Status          ConnectionStatus `json:"status"`
+	LastRefreshedAt time.Time        `json:"last_refreshed_at,omitzero"`
+	LastError       string           `json:"last_error,omitempty"`
+	Fingerprint     string           `json:"fingerprint,omitempty"` // access token 的哈希前缀，用于比对token是否变化
+	HasRefreshToken bool             `json:"has_refresh_token"`
+}
+
+// View 返回token的脱敏视图
+func (t *TokenInfo) View() ConnectionView {
+	return ConnectionView{
+		ConnectionID:    t.ConnectionID,
+		Provider:        t.Provider,
+		Default:         t.Default,
+		Account:         t.Account,
+		AccountID:       t.AccountID,
+		AccountEmail:    t.AccountEmail,
+		Scopes:          t.Scopes,
+		Sites:           t.Sites,
+		Expiry:          t.Expiry,
+		Status:          t.Health(),
+		LastRefreshedAt: t.LastRefreshedAt,
+		LastError:       t.LastError,
+		Fingerprint:     TokenFingerprint(t.AccessToken),
+		HasRefreshToken: t.RefreshToken != "",
+	}
+}
+
+// TokenFingerprint 计算token指纹（sha256前16位十六进制），空token返回空字符串
+func TokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// GetConnections 获取用户所有连接的脱敏视图，按平台分组，同一平台内默认连接在前
+func (tm *TokenManager) GetConnections(userID string) []ConnectionView {
+	tokens := tm.GetAllTokens(userID)
+	conns := make([]*TokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		conns = append(conns, token)
+	}
+	sortConnections(conns)
+	sort.SliceStable(conns, func(i, j int) bool {
+		return conns[i].Provider < conns[j].Provider
+	})
+
+	views := make([]ConnectionView, 0, len(conns))
+	for _, token := range conns {
+		views = append(views, token.View())
+	}
+	return views
+}
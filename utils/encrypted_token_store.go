@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+)
+
+// EncryptedTokenStore 在写入底层存储前加密 AccessToken 和 RefreshToken，读取时解密
+// 其余字段保持明文，方便按过期时间等条件扫描
+type EncryptedTokenStore struct {
+	inner   TokenStore
+	keyring *Keyring
+	// mu 保证密钥轮换时的“读取-重新加密-写回”不会覆盖并发写入的新token
+	mu sync.Mutex
+}
+
+// NewEncryptedTokenStore 用 keyring 包装底层存储
+func NewEncryptedTokenStore(inner TokenStore, keyring *Keyring) *EncryptedTokenStore {
+	return &EncryptedTokenStore{inner: inner, keyring: keyring}
+}
+
+func (s *EncryptedTokenStore) Save(userID, connectionID string, token *TokenInfo) error {
+	encrypted, err := s.encrypt(userID, connectionID, token)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inner.Save(userID, connectionID, encrypted)
+}
+
+func (s *EncryptedTokenStore) Get(userID, connectionID string) (*TokenInfo, error) {
+	token, err := s.inner.Get(userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	return s.decrypt(userID, connectionID, token)
+}
+
+func (s *EncryptedTokenStore) Delete(userID, connectionID string) error {
+	return s.inner.Delete(userID, connectionID)
+}
+
+func (s *EncryptedTokenStore) List(userID string) (map[string]*TokenInfo, error) {
+	tokens, err := s.inner.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	for connectionID, token := range tokens {
+		if tokens[connectionID], err = s.decrypt(userID, connectionID, token); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+func (s *EncryptedTokenStore) ListAll() (map[string]map[string]*TokenInfo, error) {
+	all, err := s.inner.ListAll()
+	if err != nil {
+		return nil, err
+	}
+	for userID, tokens := range all {
+		for connectionID, token := range tokens {
+			if tokens[connectionID], err = s.decrypt(userID, connectionID, token); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return all, nil
+}
+
+func (s *EncryptedTokenStore) Close() error {
+	return s.inner.Close()
+}
+
+// NeedsRotation 是否配置了旧密钥，需要把已有token重新加密到当前密钥
+func (s *EncryptedTokenStore) NeedsRotation() bool {
+	return s.keyring.HasRetiredKeys()
+}
+
+// RotateKeys 将所有未使用当前密钥加密（包括明文）的token重新加密，返回重新加密的数量
+// 逐条处理，服务可在轮换过程中正常读写
+func (s *EncryptedTokenStore) RotateKeys() (int, error) {
+	all, err := s.inner.ListAll()
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for userID, tokens := range all {
+		for connectionID := range tokens {
+			ok, err := s.rotateOne(userID, connectionID)
+			if err != nil {
+				return rotated, fmt.Errorf("重新加密token失败(user=%s connection=%s): %v", userID, connectionID, err)
+			}
+			if ok {
+				rotated++
+			}
+		}
+	}
+	return rotated, nil
+}
+
+// rotateOne 在锁内重新读取并加密单条token，避免覆盖期间刷新写入的token
+func (s *EncryptedTokenStore) rotateOne(userID, connectionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, err := s.inner.Get(userID, connectionID)
+	if err != nil {
+		if err == ErrTokenNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if !s.needsReencrypt(stored) {
+		return false, nil
+	}
+
+	plain, err := s.decrypt(userID, connectionID, stored)
+	if err != nil {
+		return false, err
+	}
+	encrypted, err := s.encrypt(userID, connectionID, plain)
+	if err != nil {
+		return false, err
+	}
+	return true, s.inner.Save(userID, connectionID, encrypted)
+}
+
+func (s *EncryptedTokenStore) needsReencrypt(token *TokenInfo) bool {
+	active := s.keyring.ActiveKeyID()
+	for _, v := range []string{token.AccessToken, token.RefreshToken} {
+		if v != "" && encryptedKeyID(v) != active {
+			return true
+		}
+	}
+	return false
+}
+
+// encrypt 返回加密后的副本，不修改调用方持有的token
+func (s *EncryptedTokenStore) encrypt(userID, connectionID string, token *TokenInfo) (*TokenInfo, error) {
+	out := *token
+	var err error
+	if out.AccessToken, err = s.encryptField(out.AccessToken, userID, connectionID, "access_token"); err != nil {
+		return nil, err
+	}
+	if out.RefreshToken, err = s.encryptField(out.RefreshToken, userID, connectionID, "refresh_token"); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s *EncryptedTokenStore) decrypt(userID, connectionID string, token *TokenInfo) (*TokenInfo, error) {
+	out := *token
+	var err error
+	if out.AccessToken, err = s.keyring.Decrypt(out.AccessToken, fieldAAD(userID, connectionID, "access_token")); err != nil {
+		return nil, err
+	}
+	if out.RefreshToken, err = s.keyring.Decrypt(out.RefreshToken, fieldAAD(userID, connectionID, "refresh_token")); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (s *EncryptedTokenStore) encryptField(value, userID, connectionID, field string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	return s.keyring.Encrypt(value, fieldAAD(userID, connectionID, field))
+}
+
+// fieldAAD 把密文绑定到具体用户、连接和字段，防止密文在记录间被挪用
+func fieldAAD(userID, connectionID, field string) string {
+	return userID + "\x00" + connectionID + "\x00" + field
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptedTokenStore_NoPlaintextOnDisk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.db")
+	bolt, err := NewBoltTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := NewKeyring(newTestKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewEncryptedTokenStore(bolt, keyring)
+
+	token := &TokenInfo{AccessToken: "plain-access-secret", RefreshToken: "plain-refresh-secret", Provider: "gmail"}
+	if err := store.Save("u1", "gmail", token); err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "plain-access-secret" {
+		t.Fatal("Save must not modify the caller's token")
+	}
+
+	got, err := store.Get("u1", "gmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AccessToken != "plain-access-secret" || got.RefreshToken != "plain-refresh-secret" {
+		t.Fatalf("unexpected decrypted token: %+v", got)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("plain-access-secret")) || bytes.Contains(data, []byte("plain-refresh-secret")) {
+		t.Fatal("token secrets written to disk in plaintext")
+	}
+}
+
+func TestEncryptedTokenStore_RotateKeys(t *testing.T) {
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+	inner := NewMemoryTokenStore()
+
+	oldKeyring, _ := NewKeyring(oldKey)
+	oldStore := NewEncryptedTokenStore(inner, oldKeyring)
+	oldStore.Save("u1", "gmail", &TokenInfo{AccessToken: "a1", RefreshToken: "r1"})
+	oldStore.Save("u2", "slack", &TokenInfo{AccessToken: "a2"})
+	// 加密前写入的明文token也应被轮换加密
+	inner.Save("u3", "confluence", &TokenInfo{AccessToken: "a3", RefreshToken: "r3"})
+
+	keyring, err := NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewEncryptedTokenStore(inner, keyring)
+	if !store.NeedsRotation() {
+		t.Fatal("expected rotation to be needed")
+	}
+
+	n, err := store.RotateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 rotated tokens, got %d", n)
+	}
+	if n, _ := store.RotateKeys(); n != 0 {
+		t.Fatalf("second rotation should be a no-op, rotated %d", n)
+	}
+
+	// 只持有新密钥也能解密全部token
+	newOnly, _ := NewKeyring(newKey)
+	all, err := NewEncryptedTokenStore(inner, newOnly).ListAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if all["u1"]["gmail"].RefreshToken != "r1" || all["u2"]["slack"].AccessToken != "a2" || all["u3"]["confluence"].RefreshToken != "r3" {
+		t.Fatalf("unexpected tokens after rotation: %+v", all)
+	}
+	raw, _ := inner.Get("u3", "confluence")
+	if encryptedKeyID(raw.AccessToken) != keyring.ActiveKeyID() {
+		t.Fatal("plaintext token was not encrypted by rotation")
+	}
+}
+
+func TestKeyring_RejectsSwappedCiphertext(t *testing.T) {
+	keyring, _ := NewKeyring(newTestKey(t))
+	enc, err := keyring.Encrypt("secret", fieldAAD("u1", "gmail", "access_token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keyring.Decrypt(enc, fieldAAD("u2", "gmail", "access_token")); err == nil {
+		t.Fatal("ciphertext must be bound to its record")
+	}
+}
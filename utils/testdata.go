@@ -19,14 +19,15 @@ func InjectTestTokens(tm *TokenManager) {
 		googleToken := &TokenInfo{
 			AccessToken:  accessToken,
 			RefreshToken: config.GetEnv("TEST_TOKEN_GOOGLE_REFRESH", ""),
-			Provider:     "google",
 			TokenType:    "Bearer",
+			AccountID:    "test-google", // 固定账号ID，重启后覆盖之前注入的连接而不是新建
+		}
+		for _, platform := range []string{"gmail", "google-drive"} {
+			googleToken.Provider = platform
+			if conn, err := tm.SaveConnection("1", googleToken); err == nil {
+				tm.RefreshToken("1", conn.ConnectionID)
+			}
 		}
-		tm.SaveToken("1", "gmail", googleToken)
-		tm.SaveToken("1", "google-drive", googleToken)
-
-		tm.RefreshToken("1", "gmail")
-		tm.RefreshToken("1", "google-drive")
 		log.Println("已注入Google测试token")
 	}
 
@@ -37,9 +38,11 @@ func InjectTestTokens(tm *TokenManager) {
 			RefreshToken: config.GetEnv("TEST_TOKEN_SLACK_REFRESH", ""),
 			Provider:     "slack",
 			TokenType:    "Bearer",
+			AccountID:    "test-slack",
+		}
+		if conn, err := tm.SaveConnection("1", slackToken); err == nil {
+			tm.RefreshToken("1", conn.ConnectionID)
 		}
-		tm.SaveToken("1", "slack", slackToken)
-		tm.RefreshToken("1", "slack")
 		log.Println("已注入Slack测试token")
 	}
 }
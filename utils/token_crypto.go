@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"connector-demo/config"
+)
+
+// 加密后字段的前缀，格式：enc:v1:<kid>:<被KEK加密的DEK>:<被DEK加密的数据>
+const envelopePrefix = "enc:v1:"
+
+// ErrUnknownKey 表示密文使用的密钥不在当前 Keyring 中
+var ErrUnknownKey = errors.New("unknown token encryption key")
+
+// kek 密钥加密密钥（key-encryption key）
+type kek struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring 管理用于信封加密的KEK
+// active 用于加密新数据，retired 仅用于解密旧数据，便于密钥轮换
+type Keyring struct {
+	active *kek
+	keys   map[string]*kek
+}
+
+// NewKeyring 创建 Keyring，active 为当前使用的密钥，retired 为已轮换下来的旧密钥
+// 所有密钥都必须是32字节（AES-256）
+func NewKeyring(active []byte, retired ...[]byte) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[string]*kek)}
+
+	k, err := newKEK(active)
+	if err != nil {
+		return nil, err
+	}
+	kr.active = k
+	kr.keys[k.id] = k
+
+	for _, raw := range retired {
+		k, err := newKEK(raw)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := kr.keys[k.id]; !ok {
+			kr.keys[k.id] = k
+		}
+	}
+	return kr, nil
+}
+
+// LoadKeyring 根据配置加载 Keyring，未配置任何密钥时返回 nil
+// 密钥文件每行一个 base64 编码的密钥，第一行为当前密钥，其余为旧密钥，# 开头为注释
+func LoadKeyring(cfg *config.Config) (*Keyring, error) {
+	var encoded []string
+	if cfg.TokenEncryptionKeyFile != "" {
+		keys, err := readKeyFile(cfg.TokenEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = keys
+	} else if cfg.TokenEncryptionKey != "" {
+		encoded = append(encoded, cfg.TokenEncryptionKey)
+		for _, k := range strings.Split(cfg.TokenEncryptionOldKeys, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				encoded = append(encoded, k)
+			}
+		}
+	}
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	keys := make([][]byte, 0, len(encoded))
+	for i, e := range encoded {
+		raw, err := base64.StdEncoding.DecodeString(e)
+		if err != nil {
+			return nil, fmt.Errorf("解析第%d个加密密钥失败: %v", i+1, err)
+		}
+		keys = append(keys, raw)
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// GenerateKey 生成一个新的 base64 编码的 AES-256 密钥
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// ActiveKeyID 返回当前加密密钥的ID
+func (kr *Keyring) ActiveKeyID() string {
+	return kr.active.id
+}
+
+// HasRetiredKeys 是否存在需要轮换掉的旧密钥
+func (kr *Keyring) HasRetiredKeys() bool {
+	return len(kr.keys) > 1
+}
+
+// Encrypt 使用一次性DEK加密明文，DEK再由当前KEK加密，aad 用于绑定密文所属的记录
+func (kr *Keyring) Encrypt(plaintext, aad string) (string, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+	dekAEAD, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+
+	wrappedDEK, err := seal(kr.active.aead, dek, []byte(kr.active.id))
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dekAEAD, []byte(plaintext), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+
+	return envelopePrefix + kr.active.id + ":" +
+		base64.RawURLEncoding.EncodeToString(wrappedDEK) + ":" +
+		base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Decrypt 解密 Encrypt 生成的密文，非密文原样返回以兼容加密前写入的数据
+func (kr *Keyring) Decrypt(value, aad string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("密文格式错误")
+	}
+	k, ok := kr.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownKey, parts[0])
+	}
+	wrappedDEK, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %v", err)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %v", err)
+	}
+
+	dek, err := open(k.aead, wrappedDEK, []byte(k.id))
+	if err != nil {
+		return "", fmt.Errorf("解密DEK失败: %v", err)
+	}
+	dekAEAD, err := newAEAD(dek)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dekAEAD, data, []byte(aad))
+	if err != nil {
+		return "", fmt.Errorf("解密数据失败: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted 判断字段是否为信封加密后的密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix)
+}
+
+// encryptedKeyID 返回密文使用的KEK ID，非密文返回空字符串
+func encryptedKeyID(value string) string {
+	if !IsEncrypted(value) {
+		return ""
+	}
+	kid, _, _ := strings.Cut(strings.TrimPrefix(value, envelopePrefix), ":")
+	return kid
+}
+
+func newKEK(raw []byte) (*kek, error) {
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("加密密钥长度必须为32字节，实际为%d字节", len(raw))
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &kek{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal 加密并把随机 nonce 放在密文前面
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("密文过短")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func readKeyFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %v", err)
+	}
+	return keys, nil
+}
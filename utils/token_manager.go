@@ -2,156 +2,581 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
 )
 
-// TokenInfo 存储token信息
+// ConnectionStatus 连接的健康状态
+type ConnectionStatus string
+
+const (
+	StatusActive        ConnectionStatus = "active"         // token可用
+	StatusRefreshing    ConnectionStatus = "refreshing"     // 正在刷新
+	StatusRefreshFailed ConnectionStatus = "refresh_failed" // 刷新失败（网络等临时错误），会继续重试
+	StatusNeedsReauth   ConnectionStatus = "needs_reauth"   // refresh token被拒绝，需要用户重新授权
+	StatusRevoked       ConnectionStatus = "revoked"        // 授权已被撤销
+)
+
+var (
+	// ErrNeedsReauth 连接需要用户重新授权
+	ErrNeedsReauth = errors.New("connection needs re-authorization")
+	// ErrTokenRevoked 连接的授权已被撤销
+	ErrTokenRevoked = errors.New("connection has been revoked")
+)
+
+// TokenInfo 存储token信息，每条记录对应用户在某个平台的一个连接（账号/工作区）
 type TokenInfo struct {
-	AccessToken  string    `json:"access_token"`
-	RefreshToken string    `json:"refresh_token,omitempty"`
-	Expiry       time.Time `json:"expiry,omitempty"`
-	TokenType    string    `json:"token_type,omitempty"`
-	Provider     string    `json:"provider,omitempty"`
+	ConnectionID    string            `json:"connection_id,omitempty"`
+	AccessToken     string            `json:"access_token"`
+	RefreshToken    string            `json:"refresh_token,omitempty"`
+	Expiry          time.Time         `json:"expiry,omitzero"`
+	TokenType       string            `json:"token_type,omitempty"`
+	Provider        string            `json:"provider,omitempty"`
+	Account         string            `json:"account,omitempty"`       // provider侧账号的可读标识（邮箱等）
+	AccountID       string            `json:"account_id,omitempty"`    // provider侧的账号ID
+	AccountEmail    string            `json:"account_email,omitempty"` // provider侧的账号邮箱
+	Metadata        map[string]string `json:"metadata,omitempty"`      // provider特有的连接信息，如 Confluence 的 cloud_id
+	Scopes          []string          `json:"scopes,omitempty"`
+	Sites           []ConnectionSite  `json:"sites,omitempty"`   // 连接可访问的站点，如 Atlassian 站点
+	Default         bool              `json:"default,omitempty"` // 未指定连接时使用该平台的默认连接
+	CreatedAt       time.Time         `json:"created_at,omitzero"`
+	Status          ConnectionStatus  `json:"status,omitempty"`
+	LastRefreshedAt time.Time         `json:"last_refreshed_at,omitzero"`
+	LastError       string            `json:"last_error,omitempty"`
 }
 
-// TokenManager 管理用户的token
-// 注意：这是内存存储，生产环境应使用数据库
+// ConnectionSite 连接可以访问的一个站点（如一个 Atlassian 站点）
+type ConnectionSite struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	URL    string   `json:"url,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// Health 返回连接状态，旧数据没有状态时视为可用
+func (t *TokenInfo) Health() ConnectionStatus {
+	if t.Status == "" {
+		return StatusActive
+	}
+	return t.Status
+}
+
+// DefaultRefreshSkew 默认在token到期前多久开始刷新
+const DefaultRefreshSkew = 2 * time.Minute
+
+// TokenManager 管理用户的连接和token，具体的读写委托给 TokenStore
 type TokenManager struct {
-	tokens map[string]map[string]*TokenInfo // userID -> platform -> token
-	mu     sync.RWMutex
+	store TokenStore
+	// refreshSkew token在到期前这段时间内即视为需要刷新
+	refreshSkew time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*refreshCall // userID+connectionID -> 正在进行的刷新
+
+	// connMu 串行化连接的“读取-修改-写回”，保证每个平台只有一个默认连接
+	connMu sync.Mutex
+}
+
+// refreshCall 一次正在进行的刷新，并发调用方共享其结果
+type refreshCall struct {
+	done  chan struct{}
+	token *TokenInfo
+	err   error
 }
 
-// NewTokenManager 创建新的token管理器
+// NewTokenManager 创建使用内存存储的token管理器
 func NewTokenManager() *TokenManager {
+	return NewTokenManagerWithStore(NewMemoryTokenStore())
+}
+
+// NewTokenManagerWithStore 创建使用指定存储的token管理器
+func NewTokenManagerWithStore(store TokenStore) *TokenManager {
 	return &TokenManager{
-		tokens: make(map[string]map[string]*TokenInfo),
+		store:       store,
+		refreshSkew: DefaultRefreshSkew,
+		inflight:    make(map[string]*refreshCall),
 	}
 }
 
-// SaveToken 保存用户的token
-func (tm *TokenManager) SaveToken(userID, platform string, token *TokenInfo) error {
-	tm.mu.Lock()
-	defer tm.mu.Unlock()
+// SetRefreshSkew 设置提前刷新的时间窗口
+func (tm *TokenManager) SetRefreshSkew(skew time.Duration) {
+	tm.refreshSkew = skew
+}
+
+// Close 关闭底层token存储
+func (tm *TokenManager) Close() error {
+	return tm.store.Close()
+}
 
-	if tm.tokens[userID] == nil {
-		tm.tokens[userID] = make(map[string]*TokenInfo)
+// SaveConnection 保存用户的一个连接，返回保存后的连接
+// 未指定连接ID时，同一平台下 provider 账号相同的连接会被覆盖（重新授权），否则新建连接；
+// provider 没有返回账号ID时无法判断是否同一账号，总是新建连接。
+// 用户在该平台下的第一个连接自动成为默认连接
+func (tm *TokenManager) SaveConnection(userID string, token *TokenInfo) (*TokenInfo, error) {
+	if token.Provider == "" {
+		return nil, errors.New("连接缺少provider")
 	}
-	tm.tokens[userID][platform] = token
-	return nil
+
+	tm.connMu.Lock()
+	defer tm.connMu.Unlock()
+
+	existing, err := tm.ListConnections(userID, token.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	saved := *token
+	if saved.ConnectionID == "" && saved.AccountID != "" {
+		for _, conn := range existing {
+			if conn.AccountID == saved.AccountID {
+				saved.ConnectionID = conn.ConnectionID
+				saved.Default = conn.Default
+				saved.CreatedAt = conn.CreatedAt
+				break
+			}
+		}
+	}
+	if saved.ConnectionID == "" {
+		if saved.ConnectionID, err = NewConnectionID(); err != nil {
+			return nil, err
+		}
+	}
+	if saved.CreatedAt.IsZero() {
+		saved.CreatedAt = time.Now()
+	}
+	saved.Default = !hasOtherDefault(existing, saved.ConnectionID)
+
+	if err := tm.store.Save(userID, saved.ConnectionID, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
 }
 
-// GetToken 获取用户的token（自动刷新过期token）
+// SaveToken 保存用户在指定平台的token，等同于以该平台保存连接
+func (tm *TokenManager) SaveToken(userID, platform string, token *TokenInfo) error {
+	withProvider := *token
+	withProvider.Provider = platform
+	_, err := tm.SaveConnection(userID, &withProvider)
+	return err
+}
+
+// GetToken 获取用户在指定平台默认连接的token（即将过期或已过期时自动刷新）
 func (tm *TokenManager) GetToken(userID, platform string) (*TokenInfo, bool) {
-	tm.mu.RLock()
-	userTokens, ok := tm.tokens[userID]
-	tm.mu.RUnlock()
-	if !ok {
+	token, err := tm.GetValidToken(userID, platform)
+	if err != nil {
 		return nil, false
 	}
-	token, ok := userTokens[platform]
-	if !ok || token == nil {
-		return nil, false
+	return token, true
+}
+
+// LookupToken 读取存储中的连接，不做刷新和状态检查
+func (tm *TokenManager) LookupToken(userID, connectionID string) (*TokenInfo, error) {
+	token, err := tm.store.Get(userID, connectionID)
+	if err != nil {
+		return nil, err
 	}
-	// 判断token是否过期
-	if !token.Expiry.IsZero() && token.Expiry.Before(time.Now()) {
-		// 已过期，尝试刷新
-		newToken, err := tm.RefreshToken(userID, platform)
+	return normalizeConnection(connectionID, token), nil
+}
+
+// ResolveConnection 按 ref 选择平台下的连接，不做刷新和状态检查
+// 未指定连接ID时返回默认连接；指定的连接不属于该平台时返回 ErrTokenNotFound
+func (tm *TokenManager) ResolveConnection(ref ConnectionRef, platform string) (*TokenInfo, error) {
+	if ref.ConnectionID == "" {
+		conns, err := tm.ListConnections(ref.UserID, platform)
 		if err != nil {
-			return nil, false
+			return nil, err
 		}
-		token = newToken
+		if len(conns) == 0 {
+			return nil, ErrTokenNotFound
+		}
+		return conns[0], nil
 	}
-	return token, true
+
+	token, err := tm.LookupToken(ref.UserID, ref.ConnectionID)
+	if err != nil {
+		return nil, err
+	}
+	if token.Provider != platform {
+		return nil, fmt.Errorf("%w: 连接 %s 不属于 %s", ErrTokenNotFound, ref.ConnectionID, platform)
+	}
+	return token, nil
+}
+
+// GetValidToken 获取用户在指定平台默认连接的可用token
+func (tm *TokenManager) GetValidToken(userID, platform string) (*TokenInfo, error) {
+	return tm.GetConnectionToken(ConnectionRef{UserID: userID}, platform)
 }
 
-func (tm *TokenManager) RefreshToken(userID, platform string) (*TokenInfo, error) {
-	// 获取当前 token
-	tm.mu.RLock()
-	userTokens, ok := tm.tokens[userID]
-	tm.mu.RUnlock()
-	if !ok {
-		return nil, errors.New("token not found")
+// GetConnectionToken 获取 ref 指定连接的可用token，即将过期或已过期时自动刷新
+// 连接需要重新授权时返回 ErrNeedsReauth，已撤销时返回 ErrTokenRevoked
+func (tm *TokenManager) GetConnectionToken(ref ConnectionRef, platform string) (*TokenInfo, error) {
+	token, err := tm.ResolveConnection(ref, platform)
+	if err != nil {
+		if !errors.Is(err, ErrTokenNotFound) {
+			log.Printf("读取token失败: %v", err)
+		}
+		return nil, err
+	}
+	if err := statusError(token); err != nil {
+		return nil, err
 	}
-	token, ok := userTokens[platform]
-	if !ok || token == nil {
-		return nil, errors.New("token not found")
+	if !tm.needsRefresh(token) {
+		return token, nil
+	}
+
+	newToken, err := tm.refresh(ref.UserID, token.ConnectionID, tm.refreshSkew)
+	if err != nil {
+		// 仍处于提前刷新窗口内的token还可以继续使用
+		if token.Expiry.After(time.Now()) && !errors.Is(err, ErrNeedsReauth) && !errors.Is(err, ErrTokenRevoked) {
+			log.Printf("提前刷新token失败，继续使用当前token(user=%s connection=%s): %v", ref.UserID, token.ConnectionID, err)
+			return token, nil
+		}
+		return nil, err
+	}
+	return newToken, nil
+}
+
+// statusError 根据连接状态返回对应错误，可用时返回 nil
+func statusError(token *TokenInfo) error {
+	switch token.Health() {
+	case StatusNeedsReauth:
+		return ErrNeedsReauth
+	case StatusRevoked:
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// needsRefresh 判断token是否已过期或处于提前刷新窗口内
+func (tm *TokenManager) needsRefresh(token *TokenInfo) bool {
+	return expiresWithin(token, tm.refreshSkew)
+}
+
+// expiresWithin 判断token是否会在 d 时间内到期，没有过期时间的token视为不会到期
+func expiresWithin(token *TokenInfo, d time.Duration) bool {
+	if token.Expiry.IsZero() {
+		return false
+	}
+	return time.Now().Add(d).After(token.Expiry)
+}
+
+// forceRefresh 传给 refresh 表示无论token是否临近到期都刷新
+const forceRefresh time.Duration = -1
+
+// RefreshToken 刷新用户指定连接的token
+// 同一连接的并发刷新只会真正调用一次 provider，其余调用方等待并共享结果，
+// 避免轮换式 refresh token（Atlassian、Slack）被并发刷新作废
+func (tm *TokenManager) RefreshToken(userID, connectionID string) (*TokenInfo, error) {
+	return tm.refresh(userID, connectionID, forceRefresh)
+}
+
+// refresh 刷新 within 时间内到期的token。刷新前会重新读取存储，
+// 如果其他调用方刚刷新完、token已不在 within 内到期，直接返回存储中的token，不再请求 provider
+func (tm *TokenManager) refresh(userID, connectionID string, within time.Duration) (*TokenInfo, error) {
+	key := refreshKey(userID, connectionID)
+
+	tm.mu.Lock()
+	if call, ok := tm.inflight[key]; ok {
+		tm.mu.Unlock()
+		<-call.done
+		return call.token, call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	tm.inflight[key] = call
+	tm.mu.Unlock()
+
+	call.token, call.err = tm.doRefresh(userID, connectionID, within)
+
+	tm.mu.Lock()
+	delete(tm.inflight, key)
+	tm.mu.Unlock()
+	close(call.done)
+
+	return call.token, call.err
+}
+
+func refreshKey(userID, connectionID string) string {
+	return userID + "\x00" + connectionID
+}
+
+// doRefresh 调用 provider 刷新token并写回存储，同时更新连接的健康状态
+func (tm *TokenManager) doRefresh(userID, connectionID string, within time.Duration) (*TokenInfo, error) {
+	// 在刷新内部重新读取，确保使用的是最新的 refresh token
+	token, err := tm.LookupToken(userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := statusError(token); err != nil {
+		return nil, err
+	}
+	// 其他调用方已经刷新过，再次刷新会让轮换式 refresh token 白白作废
+	if within != forceRefresh && !expiresWithin(token, within) {
+		return token, nil
 	}
 
 	// 获取  provider
-	provider, err := goth.GetProvider(platform)
+	provider, err := goth.GetProvider(token.Provider)
 	if err != nil {
 		// provider 获取失败
 		log.Printf("provider not found: %v", err)
 		return nil, err
 	}
+	if token.RefreshToken == "" || !provider.RefreshTokenAvailable() {
+		return nil, tm.markFailed(userID, token, StatusNeedsReauth, errors.New("no refresh token available"))
+	}
 
 	// 使用 goth provider 的 RefreshToken 方法
 	newOAuthToken, err := provider.RefreshToken(token.RefreshToken)
 	if err != nil {
-		return nil, err
+		return nil, tm.markFailed(userID, token, classifyRefreshError(err), err)
 	}
 	if newOAuthToken == nil {
-		return nil, errors.New("refresh token returned nil")
+		return nil, tm.markFailed(userID, token, StatusRefreshFailed, errors.New("refresh token returned nil"))
 	}
 
-	// 更新内存 token
-	newToken := &TokenInfo{
-		AccessToken:  newOAuthToken.AccessToken,
-		RefreshToken: newOAuthToken.RefreshToken,
-		Expiry:       newOAuthToken.Expiry,
-		TokenType:    newOAuthToken.TokenType,
-		Provider:     platform,
+	// 在原token基础上更新，provider 未返回新的 refresh token 时（如Google）沿用旧的
+	applyRefresh := func(t *TokenInfo) {
+		t.AccessToken = newOAuthToken.AccessToken
+		t.Expiry = newOAuthToken.Expiry
+		t.Status = StatusActive
+		t.LastRefreshedAt = time.Now()
+		t.LastError = ""
+		if newOAuthToken.RefreshToken != "" {
+			t.RefreshToken = newOAuthToken.RefreshToken
+		}
+		if newOAuthToken.TokenType != "" {
+			t.TokenType = newOAuthToken.TokenType
+		}
 	}
 
-	err = tm.SaveToken(userID, platform, newToken)
+	// 更新存储中的 token
+	// 保存失败时不能继续使用新token：轮换式 provider 的旧 refresh token 已经作废，需要让调用方知道
+	newToken, err := tm.UpdateConnection(userID, connectionID, applyRefresh)
 	if err != nil {
-		log.Printf("failed to save refreshed token: %v", err)
+		return nil, fmt.Errorf("保存刷新后的token失败: %w", err)
 	}
-
 	return newToken, nil
 }
 
-// GetAllTokens 获取用户的所有token
-func (tm *TokenManager) GetAllTokens(userID string) map[string]*TokenInfo {
-	tm.mu.RLock()
-	defer tm.mu.RUnlock()
+// markFailed 记录刷新失败后的连接状态，需要重新授权时返回 ErrNeedsReauth
+func (tm *TokenManager) markFailed(userID string, token *TokenInfo, status ConnectionStatus, cause error) error {
+	_, err := tm.UpdateConnection(userID, token.ConnectionID, func(t *TokenInfo) {
+		t.Status = status
+		t.LastError = cause.Error()
+	})
+	if err != nil {
+		log.Printf("failed to save token status: %v", err)
+	}
+	log.Printf("刷新token失败(user=%s connection=%s provider=%s status=%s): %v", userID, token.ConnectionID, token.Provider, status, cause)
 
-	if tokens, ok := tm.tokens[userID]; ok {
-		// 返回副本避免并发问题
-		result := make(map[string]*TokenInfo)
-		for k, v := range tokens {
-			result[k] = v
+	switch status {
+	case StatusNeedsReauth:
+		return fmt.Errorf("%w: %v", ErrNeedsReauth, cause)
+	case StatusRevoked:
+		return fmt.Errorf("%w: %v", ErrTokenRevoked, cause)
+	}
+	return cause
+}
+
+// classifyRefreshError 根据 provider 返回的错误判断连接状态
+func classifyRefreshError(err error) ConnectionStatus {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		switch retrieveErr.ErrorCode {
+		case "invalid_grant", "unauthorized_client":
+			return StatusNeedsReauth
+		case "token_revoked":
+			return StatusRevoked
 		}
-		return result
 	}
-	return make(map[string]*TokenInfo)
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "invalid_grant"):
+		return StatusNeedsReauth
+	case strings.Contains(msg, "token_revoked"):
+		return StatusRevoked
+	}
+	return StatusRefreshFailed
+}
+
+// SetStatus 更新连接的健康状态，例如 API 调用发现授权已被撤销时
+func (tm *TokenManager) SetStatus(userID, connectionID string, status ConnectionStatus, reason string) error {
+	_, err := tm.UpdateConnection(userID, connectionID, func(t *TokenInfo) {
+		t.Status = status
+		t.LastError = reason
+	})
+	return err
 }
 
-// DeleteToken 删除用户的token
-func (tm *TokenManager) DeleteToken(userID, platform string) {
+// UpdateConnection 在锁内重新读取连接、应用修改并写回，避免覆盖并发写入的字段（如刷新后的token）
+func (tm *TokenManager) UpdateConnection(userID, connectionID string, mutate func(*TokenInfo)) (*TokenInfo, error) {
+	tm.connMu.Lock()
+	defer tm.connMu.Unlock()
+
+	current, err := tm.LookupToken(userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	updated := *current
+	mutate(&updated)
+	if err := tm.store.Save(userID, connectionID, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// GetAllTokens 获取用户的所有连接，connectionID -> token，正在刷新的连接状态为 refreshing
+func (tm *TokenManager) GetAllTokens(userID string) map[string]*TokenInfo {
+	tokens, err := tm.listTokens(userID)
+	if err != nil {
+		log.Printf("读取用户token失败: %v", err)
+		return make(map[string]*TokenInfo)
+	}
+
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
+	for connectionID, token := range tokens {
+		if _, ok := tm.inflight[refreshKey(userID, connectionID)]; ok {
+			refreshing := *token
+			refreshing.Status = StatusRefreshing
+			tokens[connectionID] = &refreshing
+		}
+	}
+	return tokens
+}
 
-	if userTokens, ok := tm.tokens[userID]; ok {
-		delete(userTokens, platform)
-		if len(userTokens) == 0 {
-			delete(tm.tokens, userID)
+// ListConnections 获取用户在指定平台的所有连接，默认连接排在最前，其余按创建时间排序
+func (tm *TokenManager) ListConnections(userID, platform string) ([]*TokenInfo, error) {
+	tokens, err := tm.listTokens(userID)
+	if err != nil {
+		return nil, err
+	}
+	var conns []*TokenInfo
+	for _, token := range tokens {
+		if token.Provider == platform {
+			conns = append(conns, token)
+		}
+	}
+	sortConnections(conns)
+	return conns, nil
+}
+
+// SetDefaultConnection 把指定连接设为其平台的默认连接
+func (tm *TokenManager) SetDefaultConnection(userID, connectionID string) (*TokenInfo, error) {
+	tm.connMu.Lock()
+	defer tm.connMu.Unlock()
+
+	target, err := tm.LookupToken(userID, connectionID)
+	if err != nil {
+		return nil, err
+	}
+	conns, err := tm.ListConnections(userID, target.Provider)
+	if err != nil {
+		return nil, err
+	}
+	for _, conn := range conns {
+		isDefault := conn.ConnectionID == connectionID
+		if conn.Default == isDefault {
+			continue
+		}
+		updated := *conn
+		updated.Default = isDefault
+		if err := tm.store.Save(userID, conn.ConnectionID, &updated); err != nil {
+			return nil, err
 		}
 	}
+	target.Default = true
+	return target, nil
 }
 
-// PrintAllTokens 打印所有token（调试用）
+// DeleteToken 删除用户的指定连接，删除的是默认连接时由该平台最早创建的连接接替
+func (tm *TokenManager) DeleteToken(userID, connectionID string) error {
+	tm.connMu.Lock()
+	defer tm.connMu.Unlock()
+
+	token, err := tm.LookupToken(userID, connectionID)
+	if errors.Is(err, ErrTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("删除token失败: %v", err)
+		return err
+	}
+	if err := tm.store.Delete(userID, connectionID); err != nil {
+		log.Printf("删除token失败: %v", err)
+		return err
+	}
+
+	if !token.Default {
+		return nil
+	}
+	remaining, err := tm.ListConnections(userID, token.Provider)
+	if err != nil || len(remaining) == 0 {
+		return nil
+	}
+	next := *remaining[0]
+	next.Default = true
+	if err := tm.store.Save(userID, next.ConnectionID, &next); err != nil {
+		log.Printf("设置默认连接失败: %v", err)
+	}
+	return nil
+}
+
+// listTokens 读取用户的所有连接并兼容旧数据
+func (tm *TokenManager) listTokens(userID string) (map[string]*TokenInfo, error) {
+	tokens, err := tm.store.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	for connectionID, token := range tokens {
+		tokens[connectionID] = normalizeConnection(connectionID, token)
+	}
+	return tokens, nil
+}
+
+// PrintAllTokens 打印所有连接的脱敏信息（调试用）
 func (tm *TokenManager) PrintAllTokens() {
-	tm.mu.RLock()
-	defer tm.mu.RUnlock()
+	all, err := tm.store.ListAll()
+	if err != nil {
+		log.Printf("读取token失败: %v", err)
+		return
+	}
+
+	for userID, tokens := range all {
+		for connectionID, token := range tokens {
+			view := normalizeConnection(connectionID, token).View()
+			log.Printf("User: %s Connection: %s Platform: %s Account: %s Status: %s Expiry: %s Fingerprint: %s",
+				userID, view.ConnectionID, view.Provider, view.Account, view.Status, view.Expiry.Format(time.RFC3339), view.Fingerprint)
+		}
+	}
+}
+
+// sortConnections 默认连接排在最前，其余按创建时间、连接ID排序
+func sortConnections(conns []*TokenInfo) {
+	sort.Slice(conns, func(i, j int) bool {
+		a, b := conns[i], conns[j]
+		if a.Default != b.Default {
+			return a.Default
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ConnectionID < b.ConnectionID
+	})
+}
 
-	for userID, platforms := range tm.tokens {
-		for platform, token := range platforms {
-			log.Printf("User: %s Platform: %s AccessToken: %s RefreshToken: %s", userID, platform, token.AccessToken, token.RefreshToken)
+func hasOtherDefault(conns []*TokenInfo, connectionID string) bool {
+	for _, conn := range conns {
+		if conn.Default && conn.ConnectionID != connectionID {
+			return true
 		}
 	}
+	return false
 }
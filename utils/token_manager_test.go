@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"golang.org/x/oauth2"
+)
+
+// fakeProvider 模拟轮换式 refresh token 的 provider：旧 refresh token 使用一次后即失效
+type fakeProvider struct {
+	name  string
+	calls atomic.Int32
+
+	mu           sync.Mutex
+	validRefresh string
+	generation   int
+}
+
+func (p *fakeProvider) Name() string                                  { return p.name }
+func (p *fakeProvider) SetName(name string)                           { p.name = name }
+func (p *fakeProvider) BeginAuth(string) (goth.Session, error)        { return nil, nil }
+func (p *fakeProvider) UnmarshalSession(string) (goth.Session, error) { return nil, nil }
+func (p *fakeProvider) FetchUser(goth.Session) (goth.User, error)     { return goth.User{}, nil }
+func (p *fakeProvider) Debug(bool)                                    {}
+func (p *fakeProvider) RefreshTokenAvailable() bool                   { return true }
+
+func (p *fakeProvider) RefreshToken(refreshToken string) (*oauth2.Token, error) {
+	p.calls.Add(1)
+	time.Sleep(20 * time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if refreshToken != p.validRefresh {
+		return nil, errors.New("invalid_grant")
+	}
+	p.generation++
+	p.validRefresh = "refresh-" + string(rune('a'+p.generation))
+	return &oauth2.Token{
+		AccessToken:  "access-" + string(rune('a'+p.generation)),
+		RefreshToken: p.validRefresh,
+		Expiry:       time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestTokenManager_ConcurrentRefreshIsSingleFlight(t *testing.T) {
+	provider := &fakeProvider{name: "fake-rotating", validRefresh: "refresh-a"}
+	goth.UseProviders(provider)
+
+	tm := NewTokenManager()
+	tm.SaveToken("u1", provider.name, &TokenInfo{
+		AccessToken:  "access-a",
+		RefreshToken: "refresh-a",
+		Expiry:       time.Now().Add(-time.Minute),
+		Provider:     provider.name,
+	})
+
+	const n = 20
+	var wg sync.WaitGroup
+	results := make([]*TokenInfo, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token, ok := tm.GetToken("u1", provider.name)
+			if !ok {
+				t.Errorf("caller %d got no token", i)
+				return
+			}
+			results[i] = token
+		}(i)
+	}
+	wg.Wait()
+
+	if calls := provider.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 provider refresh, got %d", calls)
+	}
+	for i, token := range results {
+		if token == nil || token.AccessToken != "access-b" {
+			t.Fatalf("caller %d got unexpected token %+v", i, token)
+		}
+	}
+}
+
+func TestTokenManager_RefreshesWithinSkew(t *testing.T) {
+	provider := &fakeProvider{name: "fake-skew", validRefresh: "refresh-a"}
+	goth.UseProviders(provider)
+
+	tm := NewTokenManager()
+	tm.SetRefreshSkew(5 * time.Minute)
+	tm.SaveToken("u1", provider.name, &TokenInfo{
+		AccessToken:  "access-a",
+		RefreshToken: "refresh-a",
+		Expiry:       time.Now().Add(time.Minute),
+	})
+
+	token, ok := tm.GetToken("u1", provider.name)
+	if !ok || token.AccessToken != "access-b" {
+		t.Fatalf("expected token to be refreshed inside skew window, got %+v", token)
+	}
+
+	// 刷新后的token距离到期还很远，不应再次刷新
+	if token, _ := tm.GetToken("u1", provider.name); token.AccessToken != "access-b" || provider.calls.Load() != 1 {
+		t.Fatalf("unexpected second refresh: %+v calls=%d", token, provider.calls.Load())
+	}
+}
+
+func TestTokenManager_LateCallerReusesFreshToken(t *testing.T) {
+	provider := &fakeProvider{name: "fake-late", validRefresh: "refresh-a"}
+	goth.UseProviders(provider)
+
+	tm := NewTokenManager()
+	conn, _ := tm.SaveConnection("u1", &TokenInfo{
+		AccessToken:  "access-a",
+		RefreshToken: "refresh-a",
+		Expiry:       time.Now().Add(-time.Minute),
+		Provider:     provider.name,
+	})
+	if _, err := tm.RefreshToken("u1", conn.ConnectionID); err != nil {
+		t.Fatal(err)
+	}
+
+	// 读到过期token的调用方在上一次刷新结束后才进入刷新，应直接拿到新token
+	token, err := tm.refresh("u1", conn.ConnectionID, tm.refreshSkew)
+	if err != nil || token.AccessToken != "access-b" {
+		t.Fatalf("expected stored token, got %+v %v", token, err)
+	}
+	if calls := provider.calls.Load(); calls != 1 {
+		t.Fatalf("expected 1 provider refresh, got %d", calls)
+	}
+}
+
+// failingSaveStore 写入失败的存储，用于模拟刷新后保存token失败
+type failingSaveStore struct {
+	*MemoryTokenStore
+	fail bool
+}
+
+func (s *failingSaveStore) Save(userID, connectionID string, token *TokenInfo) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.MemoryTokenStore.Save(userID, connectionID, token)
+}
+
+func TestTokenManager_RefreshFailsWhenSaveFails(t *testing.T) {
+	provider := &fakeProvider{name: "fake-save", validRefresh: "refresh-a"}
+	goth.UseProviders(provider)
+
+	store := &failingSaveStore{MemoryTokenStore: NewMemoryTokenStore()}
+	tm := NewTokenManagerWithStore(store)
+	conn, _ := tm.SaveConnection("u1", &TokenInfo{
+		AccessToken:  "access-a",
+		RefreshToken: "refresh-a",
+		Expiry:       time.Now().Add(-time.Minute),
+		Provider:     provider.name,
+	})
+
+	store.fail = true
+	if token, err := tm.RefreshToken("u1", conn.ConnectionID); err == nil {
+		t.Fatalf("expected save error, got %+v", token)
+	}
+}
+
+func TestTokenManager_MultipleConnectionsPerProvider(t *testing.T) {
+	tm := NewTokenManager()
+	work, err := tm.SaveConnection("u1", &TokenInfo{Provider: "gmail", AccessToken: "work", AccountID: "a1", Account: "work@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	personal, _ := tm.SaveConnection("u1", &TokenInfo{Provider: "gmail", AccessToken: "personal", AccountID: "a2", Account: "me@example.com"})
+	if work.ConnectionID == personal.ConnectionID || !work.Default || personal.Default {
+		t.Fatalf("expected two connections with the first as default: %+v %+v", work, personal)
+	}
+
+	// 同一 provider 账号重新授权时覆盖原连接
+	again, _ := tm.SaveConnection("u1", &TokenInfo{Provider: "gmail", AccessToken: "personal-2", AccountID: "a2"})
+	if again.ConnectionID != personal.ConnectionID || len(tm.GetAllTokens("u1")) != 2 {
+		t.Fatalf("reconnect should reuse connection %s, got %s", personal.ConnectionID, again.ConnectionID)
+	}
+
+	// 没有账号ID的连接无法判断是否同一账号，不能互相覆盖
+	anon1, _ := tm.SaveConnection("u1", &TokenInfo{Provider: "slack", AccessToken: "anon-1"})
+	anon2, _ := tm.SaveConnection("u1", &TokenInfo{Provider: "slack", AccessToken: "anon-2"})
+	if anon1.ConnectionID == anon2.ConnectionID {
+		t.Fatalf("connections without account ID must not be merged: %s", anon1.ConnectionID)
+	}
+	tm.DeleteToken("u1", anon1.ConnectionID)
+	tm.DeleteToken("u1", anon2.ConnectionID)
+
+	if token, _ := tm.GetValidToken("u1", "gmail"); token.AccessToken != "work" {
+		t.Fatalf("expected default connection, got %+v", token)
+	}
+	token, err := tm.GetConnectionToken(ConnectionRef{UserID: "u1", ConnectionID: personal.ConnectionID}, "gmail")
+	if err != nil || token.AccessToken != "personal-2" {
+		t.Fatalf("expected selected connection, got %+v %v", token, err)
+	}
+	if _, err := tm.GetConnectionToken(ConnectionRef{UserID: "u1", ConnectionID: personal.ConnectionID}, "slack"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("connection of another provider must not be usable, got %v", err)
+	}
+
+	if _, err := tm.SetDefaultConnection("u1", personal.ConnectionID); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := tm.GetValidToken("u1", "gmail"); token.ConnectionID != personal.ConnectionID {
+		t.Fatalf("expected new default, got %+v", token)
+	}
+
+	// 删除默认连接后由剩下的连接接替
+	tm.DeleteToken("u1", personal.ConnectionID)
+	if token, _ := tm.GetValidToken("u1", "gmail"); token == nil || token.ConnectionID != work.ConnectionID || !token.Default {
+		t.Fatalf("expected remaining connection to become default, got %+v", token)
+	}
+}
+
+func TestTokenManager_LegacyRecordsKeyedByPlatform(t *testing.T) {
+	store := NewMemoryTokenStore()
+	store.Save("u1", "slack", &TokenInfo{AccessToken: "legacy", Provider: "google"})
+	tm := NewTokenManagerWithStore(store)
+
+	token, err := tm.GetValidToken("u1", "slack")
+	if err != nil || token.AccessToken != "legacy" || token.ConnectionID != "slack" || !token.Default {
+		t.Fatalf("expected legacy record as default connection, got %+v %v", token, err)
+	}
+	views := tm.GetConnections("u1")
+	if len(views) != 1 || views[0].Provider != "slack" || views[0].ConnectionID != "slack" {
+		t.Fatalf("unexpected views: %+v", views)
+	}
+}
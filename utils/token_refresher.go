@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartRefresher 启动后台刷新任务，每隔 interval 扫描一次所有token，
+// 将在 ahead 时间内到期的token提前刷新，ctx 取消时退出
+func (tm *TokenManager) StartRefresher(ctx context.Context, interval, ahead time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		log.Printf("token后台刷新已启动，扫描间隔 %s，提前 %s 刷新", interval, ahead)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tm.refreshExpiring(ahead)
+			}
+		}
+	}()
+}
+
+// refreshExpiring 刷新所有即将到期的token，返回尝试刷新的数量
+func (tm *TokenManager) refreshExpiring(ahead time.Duration) int {
+	all, err := tm.store.ListAll()
+	if err != nil {
+		log.Printf("扫描token失败: %v", err)
+		return 0
+	}
+
+	deadline := time.Now().Add(ahead)
+	attempted := 0
+	for userID, tokens := range all {
+		for key, token := range tokens {
+			token = normalizeConnection(key, token)
+			if token.Expiry.IsZero() || token.Expiry.After(deadline) {
+				continue
+			}
+			// 需要用户介入的连接不再重试，避免反复请求 provider
+			if statusError(token) != nil {
+				continue
+			}
+			attempted++
+			if _, err := tm.refresh(userID, token.ConnectionID, ahead); err != nil {
+				log.Printf("后台刷新token失败(user=%s connection=%s provider=%s): %v", userID, token.ConnectionID, token.Provider, err)
+			}
+		}
+	}
+	return attempted
+}
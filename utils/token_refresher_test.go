@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+)
+
+func TestTokenManager_RefreshExpiring(t *testing.T) {
+	provider := &fakeProvider{name: "fake-refresher", validRefresh: "refresh-a"}
+	goth.UseProviders(provider)
+
+	tm := NewTokenManager()
+	tm.SaveToken("u1", provider.name, &TokenInfo{AccessToken: "access-a", RefreshToken: "refresh-a", Expiry: time.Now().Add(5 * time.Minute)})
+	tm.SaveToken("u2", provider.name, &TokenInfo{AccessToken: "fresh", RefreshToken: "x", Expiry: time.Now().Add(time.Hour)})
+	tm.SaveToken("u3", provider.name, &TokenInfo{AccessToken: "no-expiry"})
+
+	if n := tm.refreshExpiring(10 * time.Minute); n != 1 {
+		t.Fatalf("expected 1 refresh attempt, got %d", n)
+	}
+	token, _ := tm.ResolveConnection(ConnectionRef{UserID: "u1"}, provider.name)
+	if token.AccessToken != "access-b" || token.Health() != StatusActive || token.LastRefreshedAt.IsZero() {
+		t.Fatalf("unexpected refreshed token: %+v", token)
+	}
+}
+
+func TestTokenManager_InvalidGrantNeedsReauth(t *testing.T) {
+	provider := &fakeProvider{name: "fake-revoked", validRefresh: "refresh-a"}
+	goth.UseProviders(provider)
+
+	tm := NewTokenManager()
+	tm.SaveToken("u1", provider.name, &TokenInfo{AccessToken: "access-a", RefreshToken: "stale", Expiry: time.Now().Add(-time.Minute)})
+
+	if _, err := tm.GetValidToken("u1", provider.name); !errors.Is(err, ErrNeedsReauth) {
+		t.Fatalf("expected ErrNeedsReauth, got %v", err)
+	}
+	token, _ := tm.ResolveConnection(ConnectionRef{UserID: "u1"}, provider.name)
+	if token.Health() != StatusNeedsReauth || token.LastError == "" {
+		t.Fatalf("expected needs_reauth status, got %+v", token)
+	}
+
+	// 需要重新授权的连接不会再被后台任务刷新
+	if n := tm.refreshExpiring(time.Hour); n != 0 || provider.calls.Load() != 1 {
+		t.Fatalf("needs_reauth connection was refreshed again: attempts=%d calls=%d", n, provider.calls.Load())
+	}
+}
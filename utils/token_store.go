@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"connector-demo/config"
+)
+
+// 支持的token存储驱动
+const (
+	TokenStoreMemory = "memory"
+	TokenStoreBolt   = "bolt"
+)
+
+// ErrTokenNotFound 表示存储中没有对应的token
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore token持久化接口，TokenManager 通过它读写token
+// 记录以 userID + 连接ID 为键；旧版本数据以平台名为键，读取时由 TokenManager 兼容
+type TokenStore interface {
+	// Save 保存（覆盖）用户的指定连接
+	Save(userID, connectionID string, token *TokenInfo) error
+	// Get 获取用户的指定连接，不存在时返回 ErrTokenNotFound
+	Get(userID, connectionID string) (*TokenInfo, error)
+	// Delete 删除用户的指定连接，不存在时不报错
+	Delete(userID, connectionID string) error
+	// List 获取用户的所有连接，connectionID -> token
+	List(userID string) (map[string]*TokenInfo, error)
+	// ListAll 获取所有用户的连接，userID -> connectionID -> token
+	ListAll() (map[string]map[string]*TokenInfo, error)
+	// Close 释放存储占用的资源
+	Close() error
+}
+
+// NewTokenStore 根据配置创建token存储
+// 配置了加密密钥时用 EncryptedTokenStore 包装；持久化存储必须配置密钥，避免token明文落盘
+func NewTokenStore(cfg *config.Config) (TokenStore, error) {
+	keyring, err := LoadKeyring(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("加载token加密密钥失败: %v", err)
+	}
+
+	var store TokenStore
+	switch cfg.TokenStoreDriver {
+	case "", TokenStoreMemory:
+		store = NewMemoryTokenStore()
+	case TokenStoreBolt:
+		if keyring == nil {
+			return nil, errors.New("使用bolt存储时必须配置 TOKEN_ENCRYPTION_KEY 或 TOKEN_ENCRYPTION_KEY_FILE")
+		}
+		if store, err = NewBoltTokenStore(cfg.TokenStorePath); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("不支持的token存储驱动: %s", cfg.TokenStoreDriver)
+	}
+
+	if keyring != nil {
+		store = NewEncryptedTokenStore(store, keyring)
+	}
+	return store, nil
+}
+
+// MemoryTokenStore 内存token存储，进程重启后数据丢失（开发环境）
+type MemoryTokenStore struct {
+	tokens map[string]map[string]*TokenInfo // userID -> connectionID -> token
+	mu     sync.RWMutex
+}
+
+// NewMemoryTokenStore 创建内存token存储
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		tokens: make(map[string]map[string]*TokenInfo),
+	}
+}
+
+func (s *MemoryTokenStore) Save(userID, connectionID string, token *TokenInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokens[userID] == nil {
+		s.tokens[userID] = make(map[string]*TokenInfo)
+	}
+	s.tokens[userID][connectionID] = token
+	return nil
+}
+
+func (s *MemoryTokenStore) Get(userID, connectionID string) (*TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[userID][connectionID]
+	if !ok || token == nil {
+		return nil, ErrTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *MemoryTokenStore) Delete(userID, connectionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if userTokens, ok := s.tokens[userID]; ok {
+		delete(userTokens, connectionID)
+		if len(userTokens) == 0 {
+			delete(s.tokens, userID)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) List(userID string) (map[string]*TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// 返回副本避免并发问题
+	result := make(map[string]*TokenInfo)
+	for k, v := range s.tokens[userID] {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (s *MemoryTokenStore) ListAll() (map[string]map[string]*TokenInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]map[string]*TokenInfo, len(s.tokens))
+	for userID, connections := range s.tokens {
+		result[userID] = make(map[string]*TokenInfo, len(connections))
+		for k, v := range connections {
+			result[userID][k] = v
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryTokenStore) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltTokenStore_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.db")
+
+	store, err := NewBoltTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := NewTokenManagerWithStore(store)
+	expiry := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if err := tm.SaveToken("u1", "gmail", &TokenInfo{AccessToken: "at", RefreshToken: "rt", Expiry: expiry, Provider: "gmail"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.SaveToken("u1", "slack", &TokenInfo{AccessToken: "slack-at", Provider: "slack"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟进程重启
+	store, err = NewBoltTokenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm = NewTokenManagerWithStore(store)
+	defer tm.Close()
+
+	token, ok := tm.GetToken("u1", "gmail")
+	if !ok {
+		t.Fatal("token lost after reopen")
+	}
+	if token.AccessToken != "at" || token.RefreshToken != "rt" || !token.Expiry.Equal(expiry) {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+	if all := tm.GetAllTokens("u1"); len(all) != 2 {
+		t.Fatalf("expected 2 tokens, got %d", len(all))
+	}
+
+	for connectionID := range tm.GetAllTokens("u1") {
+		tm.DeleteToken("u1", connectionID)
+	}
+	if _, err := store.Get("u1", token.ConnectionID); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected ErrTokenNotFound, got %v", err)
+	}
+	if all, _ := store.ListAll(); len(all) != 0 {
+		t.Fatalf("expected empty store, got %v", all)
+	}
+}